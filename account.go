@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/web"
+)
+
+// googleRevokeURL is where Google takes tokens to revoke them. It's only
+// changed by tests.
+var googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// errLastGoogleUser is returned when unlinking the only Google account a user
+// can log in with.
+var errLastGoogleUser = errors.New("can't unlink your only Google account; you'd have no way to log in")
+
+// linkedAccount is a Google account linked to a user, as shown on their
+// account page.
+type linkedAccount struct {
+	GoogleUserID int64
+	Email        string
+	Name         string
+	// Scopes are the scopes the account has granted, if it has a link.
+	Scopes []string
+	// Live is false if the account's link is dead or missing, in which case it
+	// must be linked again before mainframe can use it.
+	Live     bool
+	LinkedAt time.Time
+}
+
+// maxAPITokenNameLength is how long an API token's name can be.
+const maxAPITokenNameLength = 100
+
+// apiScope is a scope an API token can be granted.
+type apiScope struct {
+	Name        string
+	Description string
+}
+
+// apiScopes are every scope an API token can be granted. Endpoints that accept
+// API tokens are wrapped in auth.RequireScope with one of these.
+var apiScopes = []apiScope{
+	{Name: scopeCronsRead, Description: "See cron jobs and their recent runs"},
+	{Name: scopeCronsRun, Description: "Run cron jobs"},
+	{Name: scopeExport, Description: "Download an export of all data"},
+}
+
+// accountPage is what the account template renders.
+type accountPage struct {
+	Accounts []linkedAccount
+	Tokens   []auth.Token
+	Scopes   []apiScope
+	// NewToken is an API token that was just created. It's shown once, since
+	// only its hash is kept.
+	NewToken string
+	// Error is shown at the top of the page, if set.
+	Error string
+}
+
+// isAPIScope returns true if scope is one of apiScopes.
+func isAPIScope(scope string) bool {
+	for _, s := range apiScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedAccounts returns every Google account linked to the given user.
+func linkedAccounts(ctx context.Context, db *sql.DB, google *googleClient, userID int64) ([]linkedAccount, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`
+		SELECT
+			google_users.id,
+			google_users.email,
+			google_users.name,
+			google_links.id,
+			google_links.dead_at,
+			google_users.created_at
+		FROM google_users
+		LEFT JOIN google_links
+			ON google_links.id = (
+				SELECT MAX(id)
+				FROM google_links
+				WHERE google_user_id = google_users.id
+			)
+		WHERE google_users.user_id = $1
+		ORDER BY google_users.id
+		`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query linked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		accounts []linkedAccount
+		linkIDs  []sql.NullInt64
+	)
+	for rows.Next() {
+		var (
+			a        linkedAccount
+			linkID   sql.NullInt64
+			deadAt   sql.NullString
+			linkedAt string
+		)
+		if err := rows.Scan(&a.GoogleUserID, &a.Email, &a.Name, &linkID, &deadAt, &linkedAt); err != nil {
+			return nil, fmt.Errorf("can't scan linked account: %w", err)
+		}
+		a.Live = linkID.Valid && !deadAt.Valid
+		if a.LinkedAt, err = time.Parse(time.DateTime, linkedAt); err != nil {
+			return nil, fmt.Errorf("invalid created_at %s: %w", linkedAt, err)
+		}
+		a.LinkedAt = a.LinkedAt.In(time.Local)
+		accounts = append(accounts, a)
+		linkIDs = append(linkIDs, linkID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read linked accounts: %w", err)
+	}
+	rows.Close()
+
+	for i, linkID := range linkIDs {
+		if !linkID.Valid {
+			continue
+		}
+		if accounts[i].Scopes, err = google.scopes(ctx, linkID.Int64); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+// unlinkGoogleUser deletes the given Google account and its links, as long as
+// it belongs to the given user and isn't their last one. If revoke is true,
+// Google is first told to revoke everything the account granted us, and the
+// account is kept if it can't be.
+//
+// If the account doesn't belong to the user, sql.ErrNoRows is returned.
+func unlinkGoogleUser(
+	ctx context.Context,
+	db *sql.DB,
+	google *googleClient,
+	userID, googleUserID int64,
+	revoke bool,
+) error {
+	if revoke {
+		// Revoking happens outside any transaction, so waiting on Google doesn't
+		// hold up the database.
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("can't begin transaction: %w", err)
+		}
+		_, refreshTokens, err := googleUserLinks(ctx, tx, userID, googleUserID)
+		tx.Rollback()
+		if err != nil {
+			return err
+		}
+		for _, refreshToken := range refreshTokens {
+			plaintext, err := google.keys.Decrypt(refreshToken)
+			if err != nil {
+				return fmt.Errorf("can't decrypt refresh token: %w", err)
+			}
+			if err := revokeGoogleToken(ctx, plaintext); err != nil {
+				return err
+			}
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	linkIDs, _, err := googleUserLinks(ctx, tx, userID, googleUserID)
+	if err != nil {
+		return err
+	}
+	for _, linkID := range linkIDs {
+		for _, query := range []string{
+			`DELETE FROM google_link_scopes WHERE google_link_id = $1`,
+			`DELETE FROM google_links WHERE id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, linkID); err != nil {
+				return fmt.Errorf("can't delete google link %d: %w", linkID, err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`
+		DELETE FROM google_users
+		WHERE id = $1
+		AND user_id = $2
+		`,
+		googleUserID,
+		userID,
+	); err != nil {
+		return fmt.Errorf("can't delete google user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit unlink: %w", err)
+	}
+	for _, linkID := range linkIDs {
+		google.forget(linkID)
+	}
+	return nil
+}
+
+// googleUserLinks returns the IDs and refresh tokens of the given Google
+// account's links, as long as it belongs to the given user and isn't their
+// last one. Refresh tokens are returned as stored, i.e. encrypted.
+//
+// If the account doesn't belong to the user, sql.ErrNoRows is returned.
+func googleUserLinks(ctx context.Context, tx *sql.Tx, userID, googleUserID int64) ([]int64, []string, error) {
+	var count, owned int
+	if err := tx.QueryRowContext(
+		ctx,
+		`
+		SELECT COUNT(*), COALESCE(SUM(id = $2), 0)
+		FROM google_users
+		WHERE user_id = $1
+		`,
+		userID,
+		googleUserID,
+	).Scan(&count, &owned); err != nil {
+		return nil, nil, fmt.Errorf("can't count linked accounts: %w", err)
+	}
+	if owned == 0 {
+		return nil, nil, sql.ErrNoRows
+	}
+	if count <= 1 {
+		return nil, nil, errLastGoogleUser
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`
+		SELECT google_links.id, google_links.refresh_token
+		FROM google_links
+		INNER JOIN google_users
+			ON google_links.google_user_id = google_users.id
+		WHERE google_users.id = $1
+		AND google_users.user_id = $2
+		`,
+		googleUserID,
+		userID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't query google links: %w", err)
+	}
+	var (
+		linkIDs       []int64
+		refreshTokens []string
+	)
+	for rows.Next() {
+		var (
+			linkID       int64
+			refreshToken string
+		)
+		if err := rows.Scan(&linkID, &refreshToken); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("can't scan google link: %w", err)
+		}
+		linkIDs = append(linkIDs, linkID)
+		refreshTokens = append(refreshTokens, refreshToken)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, fmt.Errorf("can't close google link rows: %w", err)
+	}
+	return linkIDs, refreshTokens, nil
+}
+
+// revokeGoogleToken asks Google to revoke token, and with it every scope
+// granted alongside it. Tokens that are already invalid count as revoked.
+func revokeGoogleToken(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		googleRevokeURL,
+		strings.NewReader(url.Values{"token": {token}}.Encode()),
+	)
+	if err != nil {
+		return fmt.Errorf("can't build revoke request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach Google to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("Google refused to revoke token: %s", resp.Status)
+	}
+	return nil
+}
+
+// handleAccount serves the logged-in user's account page at /account, which
+// lists their linked Google accounts and lets them link more, unlink them, or
+// revoke our access to them. It also lets them create and revoke API tokens.
+func handleAccount(
+	logger *log.Logger,
+	db *sql.DB,
+	mux *http.ServeMux,
+	sessions *auth.Sessions,
+	google *googleClient,
+) {
+	logger = log.New(logger.Writer(), "[account] ", logger.Flags())
+
+	render := func(w http.ResponseWriter, r *http.Request, status int, page accountPage) {
+		userID, _ := auth.UserID(r.Context())
+		var err error
+		if page.Accounts, err = linkedAccounts(r.Context(), db, google, userID); err != nil {
+			logger.Printf("can't get linked accounts: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if page.Tokens, err = sessions.Tokens(r.Context(), userID); err != nil {
+			logger.Printf("can't get API tokens: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		page.Scopes = apiScopes
+		w.WriteHeader(status)
+		if err := web.Render(w, "account.html.tmpl", page); err != nil {
+			logger.Printf("error executing account template: %s", err)
+			return
+		}
+	}
+
+	mux.Handle("/account", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render(w, r, http.StatusOK, accountPage{})
+	})))
+
+	unlink := func(revoke bool) http.Handler {
+		return auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			googleUserID, err := strconv.ParseInt(r.FormValue("google_user_id"), 10, 64)
+			if err != nil {
+				http.Error(w, "google_user_id is required", http.StatusBadRequest)
+				return
+			}
+
+			userID, _ := auth.UserID(r.Context())
+			switch err := unlinkGoogleUser(r.Context(), db, google, userID, googleUserID, revoke); {
+			case errors.Is(err, errLastGoogleUser):
+				render(w, r, http.StatusConflict, accountPage{Error: err.Error()})
+				return
+			case errors.Is(err, sql.ErrNoRows):
+				w.WriteHeader(http.StatusNotFound)
+				return
+			case err != nil:
+				logger.Printf("can't unlink google user %d: %v", googleUserID, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			logger.Printf("User %d unlinked Google user %d (revoked=%t)", userID, googleUserID, revoke)
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+		}))
+	}
+	mux.Handle("/account/google/unlink", unlink(false))
+	mux.Handle("/account/google/revoke", unlink(true))
+
+	mux.Handle("/account/tokens/new", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "can't parse form", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(r.PostFormValue("name"))
+		if name == "" || len(name) > maxAPITokenNameLength {
+			render(w, r, http.StatusBadRequest, accountPage{
+				Error: fmt.Sprintf("API tokens need a name of at most %d characters", maxAPITokenNameLength),
+			})
+			return
+		}
+		scopes := r.PostForm["scope"]
+		for _, scope := range scopes {
+			if !isAPIScope(scope) {
+				render(w, r, http.StatusBadRequest, accountPage{Error: fmt.Sprintf("unknown scope %s", scope)})
+				return
+			}
+		}
+
+		userID, _ := auth.UserID(r.Context())
+		token, err := sessions.CreateToken(r.Context(), userID, name, scopes)
+		if err != nil {
+			logger.Printf("can't create API token: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		logger.Printf("User %d created API token %q with scopes %v", userID, name, scopes)
+		render(w, r, http.StatusCreated, accountPage{NewToken: token})
+	})))
+
+	mux.Handle("/account/tokens/revoke", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tokenID, err := strconv.ParseInt(r.FormValue("token_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "token_id is required", http.StatusBadRequest)
+			return
+		}
+
+		userID, _ := auth.UserID(r.Context())
+		switch err := sessions.RevokeToken(r.Context(), userID, tokenID); {
+		case errors.Is(err, sql.ErrNoRows):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			logger.Printf("can't revoke API token %d: %v", tokenID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		logger.Printf("User %d revoked API token %d", userID, tokenID)
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})))
+}
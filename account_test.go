@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/secrets"
+)
+
+func TestUnlinkGoogleUserRevokes(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAINFRAME_SECRET_KEY", key)
+	keys, err := secrets.FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	google := &googleClient{db: conn, keys: keys, logger: logger}
+
+	exec := func(query string, args ...any) int64 {
+		t.Helper()
+		res, err := conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	userID := exec(`INSERT INTO users DEFAULT VALUES`)
+	var googleUserIDs []int64
+	for _, externalID := range []string{"g1", "g2"} {
+		googleUserIDs = append(googleUserIDs, exec(
+			`
+			INSERT INTO google_users (
+				user_id, external_id, email, verified_email, family_name, given_name,
+				name, picture, gender, hosted_domain, link, locale
+			) VALUES ($1, $2, '', 1, '', '', '', '', '', '', '', '')
+			`,
+			userID,
+			externalID,
+		))
+	}
+	refreshToken, err := keys.Encrypt("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec(
+		`INSERT INTO google_links (access_token, token_type, refresh_token, expires_at, google_user_id) VALUES ('', 'Bearer', $1, '2026-01-01T00:00:00Z', $2)`,
+		refreshToken,
+		googleUserIDs[0],
+	)
+
+	var (
+		status  int
+		revoked []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The database has one connection, so this would wait out the
+		// timeout if unlinking held a transaction open while revoking.
+		dbCtx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		var n int
+		if err := conn.QueryRowContext(dbCtx, `SELECT COUNT(*) FROM google_users`).Scan(&n); err != nil {
+			t.Errorf("can't use the database while revoking: %v", err)
+		}
+		revoked = append(revoked, r.FormValue("token"))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	defer func(url string) { googleRevokeURL = url }(googleRevokeURL)
+	googleRevokeURL = server.URL
+
+	linked := func() int {
+		var n int
+		if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM google_users WHERE id = $1`, googleUserIDs[0]).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := unlinkGoogleUser(ctx, conn, google, userID, googleUserIDs[0], true); err == nil {
+		t.Errorf("unlinked without error when Google couldn't revoke")
+	}
+	if linked() != 1 {
+		t.Errorf("got the account unlinked when Google couldn't revoke, want it kept")
+	}
+
+	status = http.StatusOK
+	if err := unlinkGoogleUser(ctx, conn, google, userID, googleUserIDs[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if linked() != 0 {
+		t.Errorf("got the account still linked after unlinking")
+	}
+	if len(revoked) != 2 || revoked[1] != "refresh" {
+		t.Errorf("got %q revoked, want the decrypted refresh token each time", revoked)
+	}
+
+	if err := unlinkGoogleUser(ctx, conn, google, userID, googleUserIDs[1], true); !errors.Is(err, errLastGoogleUser) {
+		t.Errorf("got error %v unlinking the last account, want errLastGoogleUser", err)
+	}
+	if err := unlinkGoogleUser(ctx, conn, google, userID+1, googleUserIDs[1], false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got error %v unlinking someone else's account, want sql.ErrNoRows", err)
+	}
+	if len(revoked) != 2 {
+		t.Errorf("got %d revocations, want none for accounts that can't be unlinked", len(revoked)-2)
+	}
+}
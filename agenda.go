@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/notify"
+	"twos.dev/mainframe/web"
+)
+
+const (
+	// agendaBackToBackGap is the longest gap between two events for them to be
+	// considered back-to-back.
+	agendaBackToBackGap = 5 * time.Minute
+	// agendaMinFreeBlock is the shortest free block worth pointing out.
+	agendaMinFreeBlock = 30 * time.Minute
+	// defaultWorkingHours is used when WORKING_HOURS is unset.
+	defaultWorkingHours = "09:00-17:00"
+)
+
+// workingHoursSpec is the part of each day free blocks are looked for in, like
+// "09:00-17:00".
+var workingHoursSpec = os.Getenv("WORKING_HOURS")
+
+// timeSpan is a span of time [Start, End).
+type timeSpan struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of s.
+func (s timeSpan) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// agendaItem is a timed event on an agenda.
+type agendaItem struct {
+	calendarEvent
+	// Conflict is true if the event overlaps another busy event.
+	Conflict bool
+	// BackToBack is true if the event starts right as another busy event ends.
+	BackToBack bool
+}
+
+// agenda is everything happening on one day.
+type agenda struct {
+	// Day is midnight at the start of the day.
+	Day time.Time
+	// AllDay are the day's all-day events.
+	AllDay []calendarEvent
+	// Items are the day's timed events, sorted by start time.
+	Items []agendaItem
+	// Free are the free blocks within working hours.
+	Free []timeSpan
+	// Conflicts is how many items conflict with another.
+	Conflicts int
+	// URL is an absolute link to this agenda on the web.
+	URL string
+	// Prev and Next are the previous and next days, as YYYY-MM-DD.
+	Prev, Next string
+	// Email is true when the agenda is being rendered for an email rather than
+	// the web.
+	Email bool
+}
+
+// workingHours returns the working hours on the given day.
+func workingHours(day time.Time) (timeSpan, error) {
+	spec := workingHoursSpec
+	if spec == "" {
+		spec = defaultWorkingHours
+	}
+	startSpec, endSpec, ok := strings.Cut(spec, "-")
+	if !ok {
+		return timeSpan{}, fmt.Errorf("WORKING_HOURS must look like %s, not %s", defaultWorkingHours, spec)
+	}
+	var span timeSpan
+	for _, t := range []struct {
+		spec string
+		dst  *time.Time
+	}{{startSpec, &span.Start}, {endSpec, &span.End}} {
+		clock, err := time.Parse("15:04", strings.TrimSpace(t.spec))
+		if err != nil {
+			return timeSpan{}, fmt.Errorf("WORKING_HOURS must look like %s, not %s", defaultWorkingHours, spec)
+		}
+		*t.dst = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, day.Location())
+	}
+	if !span.End.After(span.Start) {
+		return timeSpan{}, fmt.Errorf("WORKING_HOURS must end after it starts: %s", spec)
+	}
+	return span, nil
+}
+
+// startOfDay returns midnight at the start of the day t is in.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// buildAgenda gathers every stored event on the day t is in, from every source.
+func buildAgenda(ctx context.Context, db *sql.DB, t time.Time) (*agenda, error) {
+	day := startOfDay(t)
+	events, err := calendarEventsBetween(ctx, db, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	a := agenda{
+		Day:  day,
+		URL:  web.URL("/agenda?date=" + day.Format("2006-01-02")),
+		Prev: day.AddDate(0, 0, -1).Format("2006-01-02"),
+		Next: day.AddDate(0, 0, 1).Format("2006-01-02"),
+	}
+	for _, e := range events {
+		if e.AllDay {
+			a.AllDay = append(a.AllDay, e)
+		} else {
+			a.Items = append(a.Items, agendaItem{calendarEvent: e})
+		}
+	}
+
+	for i := range a.Items {
+		if !a.Items[i].Busy {
+			continue
+		}
+		for j := range a.Items {
+			if i == j || !a.Items[j].Busy {
+				continue
+			}
+			if a.Items[i].Start.Before(a.Items[j].End) && a.Items[j].Start.Before(a.Items[i].End) {
+				a.Items[i].Conflict = true
+			}
+			gap := a.Items[i].Start.Sub(a.Items[j].End)
+			if gap >= 0 && gap <= agendaBackToBackGap {
+				a.Items[i].BackToBack = true
+			}
+		}
+		if a.Items[i].Conflict {
+			a.Conflicts++
+		}
+	}
+
+	hours, err := workingHours(day)
+	if err != nil {
+		return nil, err
+	}
+	var busy []timeSpan
+	for _, item := range a.Items {
+		if item.Busy {
+			busy = append(busy, timeSpan{item.Start, item.End})
+		}
+	}
+	a.Free = freeBlocks(hours, busy, agendaMinFreeBlock)
+
+	return &a, nil
+}
+
+// freeBlocks returns the parts of within not covered by any of busy that are
+// at least min long.
+func freeBlocks(within timeSpan, busy []timeSpan, min time.Duration) []timeSpan {
+	sort.Slice(busy, func(i, j int) bool { return busy[i].Start.Before(busy[j].Start) })
+
+	var free []timeSpan
+	cursor := within.Start
+	for _, b := range busy {
+		if !b.End.After(cursor) {
+			continue
+		}
+		if b.Start.After(cursor) {
+			end := b.Start
+			if end.After(within.End) {
+				end = within.End
+			}
+			if end.Sub(cursor) >= min {
+				free = append(free, timeSpan{cursor, end})
+			}
+		}
+		if b.End.After(cursor) {
+			cursor = b.End
+		}
+		if !cursor.Before(within.End) {
+			return free
+		}
+	}
+	if within.End.Sub(cursor) >= min {
+		free = append(free, timeSpan{cursor, within.End})
+	}
+	return free
+}
+
+// Text renders a as plain text.
+func (a *agenda) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Agenda for %s\n", a.Day.Format("Monday, January 2"))
+	if len(a.AllDay) > 0 {
+		fmt.Fprintf(&b, "\nAll day:\n")
+		for _, e := range a.AllDay {
+			fmt.Fprintf(&b, "  %s\n", e.Summary)
+		}
+	}
+	if len(a.Items) > 0 {
+		fmt.Fprintf(&b, "\n")
+		for _, item := range a.Items {
+			var flags []string
+			if item.Conflict {
+				flags = append(flags, "conflict")
+			}
+			if item.BackToBack {
+				flags = append(flags, "back-to-back")
+			}
+			if !item.Busy {
+				flags = append(flags, "free")
+			}
+			fmt.Fprintf(&b, "  %s–%s  %s", item.Start.Format("15:04"), item.End.Format("15:04"), item.Summary)
+			if len(flags) > 0 {
+				fmt.Fprintf(&b, " [%s]", strings.Join(flags, ", "))
+			}
+			fmt.Fprintf(&b, "\n")
+		}
+	}
+	if len(a.AllDay) == 0 && len(a.Items) == 0 {
+		fmt.Fprintf(&b, "\nNothing scheduled.\n")
+	}
+	if len(a.Free) > 0 {
+		fmt.Fprintf(&b, "\nFree:\n")
+		for _, span := range a.Free {
+			fmt.Fprintf(&b, "  %s–%s (%s)\n", span.Start.Format("15:04"), span.End.Format("15:04"), span.Duration())
+		}
+	}
+	fmt.Fprintf(&b, "\n%s\n", a.URL)
+	return b.String()
+}
+
+// Subject returns a one-line summary of a.
+func (a *agenda) Subject() string {
+	s := fmt.Sprintf("%s: %d events", a.Day.Format("Mon Jan 2"), len(a.AllDay)+len(a.Items))
+	if a.Conflicts > 0 {
+		s += fmt.Sprintf(", %d conflicting", a.Conflicts)
+	}
+	return s
+}
+
+// runAgenda sends today's agenda through every configured notifier.
+func runAgenda(logger *log.Logger, _ string, db *sql.DB, _ *http.ServeMux, _ *googleClient) error {
+	logger = log.New(logger.Writer(), "[agenda] ", logger.Flags())
+	ctx := context.Background()
+
+	a, err := buildAgenda(ctx, db, time.Now())
+	if err != nil {
+		return fmt.Errorf("can't build agenda: %w", err)
+	}
+	a.Email = true
+
+	var html bytes.Buffer
+	if err := web.Render(&html, "agenda.html.tmpl", a); err != nil {
+		return fmt.Errorf("can't render agenda: %w", err)
+	}
+
+	if err := notify.FromEnv(logger).Notify(ctx, notify.Message{
+		Subject: a.Subject(),
+		Text:    a.Text(),
+		HTML:    html.String(),
+	}); err != nil {
+		return fmt.Errorf("can't send agenda: %w", err)
+	}
+
+	return nil
+}
+
+// handleAgenda serves the agenda for ?date= (YYYY-MM-DD, today by default) at
+// /agenda, to logged-in users only.
+func handleAgenda(logger *log.Logger, db *sql.DB, mux *http.ServeMux) {
+	logger = log.New(logger.Writer(), "[agenda] ", logger.Flags())
+
+	mux.Handle("/agenda", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		day := time.Now()
+		if v := r.FormValue("date"); v != "" {
+			var err error
+			if day, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+				http.Error(w, "date must look like 2006-01-02", http.StatusBadRequest)
+				return
+			}
+		}
+
+		a, err := buildAgenda(r.Context(), db, day)
+		if err != nil {
+			logger.Printf("can't build agenda: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := web.Render(w, "agenda.html.tmpl", a); err != nil {
+			logger.Printf("error executing agenda template: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	})))
+}
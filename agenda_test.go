@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/db"
+)
+
+func TestAgendaRequiresLogin(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sessions, err := auth.New(logger, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := conn.ExecContext(ctx, `INSERT INTO users DEFAULT VALUES`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := sessions.CreateToken(ctx, userID, "script", []string{"export"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	handleAgenda(logger, conn, mux)
+	server := sessions.Middleware(mux)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agenda?date=2026-01-05", nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("got %d for someone logged out, want 303", rec.Code)
+	}
+	if want := auth.LoginPath + "?next=%2Fagenda%3Fdate%3D2026-01-05"; rec.Header().Get("Location") != want {
+		t.Errorf("got sent to %q, want %q", rec.Header().Get("Location"), want)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/agenda", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got %d for an API token, want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/agenda", nil)
+	req.AddCookie(&http.Cookie{Name: auth.CookieName, Value: "forged"})
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("got %d for a forged session, want 303", rec.Code)
+	}
+}
+
+func TestFreeBlocks(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 1, 5, hour, min, 0, 0, time.UTC)
+	}
+	within := timeSpan{at(9, 0), at(17, 0)}
+
+	for _, c := range []struct {
+		name string
+		busy []timeSpan
+		want []timeSpan
+	}{
+		{
+			name: "nothing busy",
+			want: []timeSpan{within},
+		},
+		{
+			name: "overlapping",
+			busy: []timeSpan{{at(10, 30), at(12, 0)}, {at(10, 0), at(11, 0)}},
+			want: []timeSpan{{at(9, 0), at(10, 0)}, {at(12, 0), at(17, 0)}},
+		},
+		{
+			name: "nested",
+			busy: []timeSpan{{at(10, 0), at(14, 0)}, {at(11, 0), at(12, 0)}},
+			want: []timeSpan{{at(9, 0), at(10, 0)}, {at(14, 0), at(17, 0)}},
+		},
+		{
+			name: "back to back",
+			busy: []timeSpan{{at(10, 0), at(11, 0)}, {at(11, 0), at(12, 0)}},
+			want: []timeSpan{{at(9, 0), at(10, 0)}, {at(12, 0), at(17, 0)}},
+		},
+		{
+			name: "crossing the edges",
+			busy: []timeSpan{{at(8, 0), at(9, 30)}, {at(16, 45), at(18, 0)}},
+			want: []timeSpan{{at(9, 30), at(16, 45)}},
+		},
+		{
+			// 20 and 25 minute gaps are too short, but exactly 30 is enough.
+			name: "minimum length",
+			busy: []timeSpan{{at(9, 20), at(10, 0)}, {at(10, 25), at(11, 0)}, {at(11, 30), at(17, 0)}},
+			want: []timeSpan{{at(11, 0), at(11, 30)}},
+		},
+		{
+			name: "all busy",
+			busy: []timeSpan{{at(0, 0), at(23, 0)}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := freeBlocks(within, c.busy, agendaMinFreeBlock)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBuildAgenda(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.New(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	defer func(spec string) { workingHoursSpec = spec }(workingHoursSpec)
+	workingHoursSpec = ""
+
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	at := func(hour, min int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute)
+	}
+	event := func(summary string, start, end time.Time, busy bool) calendarEvent {
+		return calendarEvent{UID: summary, Summary: summary, Start: start, End: end, Busy: busy}
+	}
+
+	for _, c := range []struct {
+		name      string
+		events    []calendarEvent
+		items     []string
+		allDay    int
+		conflicts int
+		free      []timeSpan
+	}{
+		{
+			// The free reminder overlaps both, but neither conflicts with it.
+			name: "overlapping",
+			events: []calendarEvent{
+				event("a", at(10, 0), at(11, 0), true),
+				event("reminder", at(10, 15), at(10, 45), false),
+				event("b", at(10, 30), at(11, 30), true),
+			},
+			items:     []string{"a conflict", "reminder", "b conflict"},
+			conflicts: 2,
+			free:      []timeSpan{{at(9, 0), at(10, 0)}, {at(11, 30), at(17, 0)}},
+		},
+		{
+			// Five minutes apart is back-to-back, but ten is not.
+			name: "back to back",
+			events: []calendarEvent{
+				event("a", at(10, 0), at(11, 0), true),
+				event("b", at(11, 5), at(12, 0), true),
+				event("c", at(12, 10), at(13, 0), true),
+			},
+			items: []string{"a", "b back-to-back", "c"},
+			free:  []timeSpan{{at(9, 0), at(10, 0)}, {at(13, 0), at(17, 0)}},
+		},
+		{
+			name: "crossing the day boundary",
+			events: []calendarEvent{
+				event("yesterday", at(-3, 0), at(-2, 0), true),
+				event("overnight", at(-2, 0), at(9, 45), true),
+				event("late", at(16, 50), at(26, 0), true),
+				event("tomorrow", at(25, 0), at(26, 0), true),
+				{UID: "holiday", Summary: "holiday", Start: day, End: day.AddDate(0, 0, 1), AllDay: true},
+			},
+			items:  []string{"overnight", "late"},
+			allDay: 1,
+			free:   []timeSpan{{at(9, 45), at(16, 50)}},
+		},
+		{
+			// Only the gap of exactly agendaMinFreeBlock is long enough.
+			name: "minimum free block",
+			events: []calendarEvent{
+				event("a", at(9, 20), at(10, 0), true),
+				event("b", at(10, 30), at(16, 40), true),
+			},
+			items: []string{"a", "b"},
+			free:  []timeSpan{{at(10, 0), at(10, 30)}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if err := replaceCalendarEvents(ctx, conn, "test", c.events); err != nil {
+				t.Fatal(err)
+			}
+			a, err := buildAgenda(ctx, conn, at(12, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var items []string
+			for _, item := range a.Items {
+				s := item.Summary
+				if item.Conflict {
+					s += " conflict"
+				}
+				if item.BackToBack {
+					s += " back-to-back"
+				}
+				items = append(items, s)
+			}
+			if !reflect.DeepEqual(items, c.items) {
+				t.Errorf("got items %q, want %q", items, c.items)
+			}
+			if len(a.AllDay) != c.allDay {
+				t.Errorf("got %d all-day events, want %d", len(a.AllDay), c.allDay)
+			}
+			if a.Conflicts != c.conflicts {
+				t.Errorf("got %d conflicts, want %d", a.Conflicts, c.conflicts)
+			}
+			var free []timeSpan
+			for _, span := range a.Free {
+				free = append(free, timeSpan{span.Start.UTC(), span.End.UTC()})
+			}
+			if !reflect.DeepEqual(free, c.free) {
+				t.Errorf("got free blocks %v, want %v", free, c.free)
+			}
+		})
+	}
+}
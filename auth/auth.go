@@ -0,0 +1,297 @@
+// Package auth keeps track of who is logged in to mainframe's web interface,
+// using signed session cookies backed by the sessions table, and of which
+// scripts may use its JSON endpoints, using API tokens backed by the
+// api_tokens table.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// CookieName is the name of the session cookie.
+	CookieName = "mainframe_session"
+	// LoginPath is where visitors are sent when a page requires them to log in.
+	LoginPath = "/login/google"
+	// SessionLength is how long a session lasts before its user must log in
+	// again.
+	SessionLength = 30 * 24 * time.Hour
+
+	envKeySession = "MAINFRAME_SESSION_KEY"
+)
+
+// ErrNoSession is returned when a request carries no valid session.
+var ErrNoSession = errors.New("no valid session")
+
+type contextKey struct{}
+
+// Sessions creates, looks up, and ends login sessions.
+type Sessions struct {
+	db     *sql.DB
+	logger *log.Logger
+	key    []byte
+	// Secure marks session cookies as HTTPS-only. It should be set whenever
+	// mainframe is served over HTTPS.
+	Secure bool
+}
+
+// New returns a Sessions that signs cookies with the key in
+// MAINFRAME_SESSION_KEY. If that is unset, a random key is used, and sessions
+// won't survive a restart.
+func New(logger *log.Logger, db *sql.DB) (*Sessions, error) {
+	logger = log.New(logger.Writer(), "[auth] ", logger.Flags())
+
+	key := []byte(os.Getenv(envKeySession))
+	if len(key) == 0 {
+		logger.Printf("%s is not set; using a random key, so everyone will be logged out on restart", envKeySession)
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("can't generate session key: %w", err)
+		}
+	} else if len(key) < 32 {
+		return nil, fmt.Errorf("%s must be at least 32 characters", envKeySession)
+	}
+
+	return &Sessions{db: db, logger: logger, key: key}, nil
+}
+
+// Start logs the given user in by creating a session and setting its cookie on
+// w.
+func (s *Sessions) Start(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64) error {
+	token := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return fmt.Errorf("can't generate session token: %w", err)
+	}
+	expiresAt := time.Now().Add(SessionLength)
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		`
+		INSERT INTO sessions (
+			user_id,
+			token_hash,
+			user_agent,
+			expires_at
+		) VALUES (
+			$1, $2, $3, $4
+		)
+		`,
+		userID,
+		hashToken(token),
+		r.UserAgent(),
+		expiresAt.UTC().Format(time.RFC3339),
+	); err != nil {
+		return fmt.Errorf("can't insert session: %w", err)
+	}
+
+	http.SetCookie(w, s.cookie(s.sign(token), expiresAt))
+	return nil
+}
+
+// End logs out whoever is logged in on r, and clears their cookie.
+func (s *Sessions) End(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, s.cookie("", time.Unix(0, 0)))
+
+	token, err := s.token(r)
+	if err != nil {
+		return nil
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`
+		DELETE FROM sessions
+		WHERE token_hash = $1
+		`,
+		hashToken(token),
+	); err != nil {
+		return fmt.Errorf("can't delete session: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the ID of the user logged in on r, or ErrNoSession if nobody
+// is.
+func (s *Sessions) Lookup(ctx context.Context, r *http.Request) (int64, error) {
+	token, err := s.token(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int64
+	if err := s.db.QueryRowContext(
+		ctx,
+		`
+		SELECT user_id
+		FROM sessions
+		WHERE token_hash = $1
+		AND expires_at > $2
+		`,
+		hashToken(token),
+		time.Now().UTC().Format(time.RFC3339),
+	).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoSession
+		}
+		return 0, fmt.Errorf("can't look up session: %w", err)
+	}
+	return userID, nil
+}
+
+// Middleware attaches the logged-in user, if any, to each request's context.
+// Requests carrying an API token get its owner and scopes attached instead.
+// It never rejects a request; see Require and RequireScope for that.
+func (s *Sessions) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			userID, scopes, err := s.LookupToken(r.Context(), r)
+			switch {
+			case err == nil:
+				r = r.WithContext(WithScopes(WithUserID(r.Context(), userID), scopes))
+			case errors.Is(err, ErrNoToken):
+			default:
+				s.logger.Printf("can't look up API token: %v", err)
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := s.Lookup(r.Context(), r)
+		switch {
+		case err == nil:
+			r = r.WithContext(WithUserID(r.Context(), userID))
+		case errors.Is(err, ErrNoSession):
+			if _, cookieErr := r.Cookie(CookieName); cookieErr == nil {
+				// Stale or forged; don't make the browser keep sending it.
+				http.SetCookie(w, s.cookie("", time.Unix(0, 0)))
+			}
+		default:
+			s.logger.Printf("can't look up session: %v", err)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Require only lets requests through if someone is logged in. Others are sent
+// to log in if they're browsing, or told they're unauthorized otherwise. API
+// tokens aren't accepted; see RequireScope for that.
+//
+// Require relies on Middleware having run first.
+func Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserID(r.Context()); !ok {
+			reject(w, r)
+			return
+		}
+		if _, ok := Scopes(r.Context()); ok {
+			http.Error(w, "API tokens can't be used here", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// reject turns away a request that isn't logged in, sending it to log in if
+// it's from someone browsing.
+func reject(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet &&
+		!strings.HasSuffix(r.URL.Path, ".json") &&
+		r.Header.Get("Authorization") == "" {
+		http.Redirect(w, r, LoginPath+"?"+url.Values{"next": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// Logout is an HTTP handler that ends the current session and sends the
+// browser home. It only accepts POST, so other sites can't log people out with
+// a link or image.
+func (s *Sessions) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.End(r.Context(), w, r); err != nil {
+		s.logger.Printf("can't end session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// WithUserID returns a copy of ctx in which the given user is logged in.
+func WithUserID(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// UserID returns the ID of the user logged in for ctx, if there is one.
+func UserID(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(contextKey{}).(int64)
+	return userID, ok
+}
+
+func (s *Sessions) cookie(value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   s.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sign returns a cookie value holding token and its signature.
+func (s *Sessions) sign(token []byte) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(token)
+	return base64.RawURLEncoding.EncodeToString(token) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// token returns the session token in r's cookie, if its signature is valid.
+func (s *Sessions) token(r *http.Request) ([]byte, error) {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	encodedToken, encodedSig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return nil, ErrNoSession
+	}
+	token, err := base64.RawURLEncoding.DecodeString(encodedToken)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(token)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrNoSession
+	}
+	return token, nil
+}
+
+// hashToken returns what is stored in place of token, so that a leaked
+// database can't be used to log in.
+func hashToken(token []byte) string {
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
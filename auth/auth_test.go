@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"twos.dev/mainframe/db"
+)
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s, err := New(logger, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := conn.ExecContext(ctx, `INSERT INTO users DEFAULT VALUES`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	if err := s.Start(ctx, rec, httptest.NewRequest(http.MethodPost, "/login", nil), userID); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/logout", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		s.Logout(rec, req)
+
+		_, err := s.Lookup(ctx, req)
+		switch method {
+		case http.MethodGet:
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("got %d for GET, want 405", rec.Code)
+			}
+			if err != nil {
+				t.Errorf("got error %v after GET, want to still be logged in", err)
+			}
+		case http.MethodPost:
+			if rec.Code != http.StatusSeeOther {
+				t.Errorf("got %d for POST, want 303", rec.Code)
+			}
+			if !errors.Is(err, ErrNoSession) {
+				t.Errorf("got error %v after POST, want ErrNoSession", err)
+			}
+		}
+	}
+}
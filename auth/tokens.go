@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenPrefix starts every API token, so they're easy to recognize in scripts
+// and in leaks.
+const TokenPrefix = "mf_"
+
+// ErrNoToken is returned when a request carries no valid API token.
+var ErrNoToken = errors.New("no valid API token")
+
+type scopesKey struct{}
+
+// Token is an API token as shown to its owner. The token itself is only ever
+// known when it's created.
+type Token struct {
+	ID     int64
+	Name   string
+	Scopes []string
+	// LastUsedAt is zero if the token has never been used.
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
+
+// CreateToken creates an API token for the given user that is good for the
+// given scopes, and returns it. Only its hash is stored, so it can't be shown
+// again.
+func (s *Sessions) CreateToken(ctx context.Context, userID int64, name string, scopes []string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", fmt.Errorf("can't generate API token: %w", err)
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`
+		INSERT INTO api_tokens (
+			user_id,
+			name,
+			token_hash
+		) VALUES (
+			$1, $2, $3
+		)
+		`,
+		userID,
+		name,
+		hashToken([]byte(token)),
+	)
+	if err != nil {
+		return "", fmt.Errorf("can't insert API token: %w", err)
+	}
+	tokenID, err := result.LastInsertId()
+	if err != nil {
+		return "", fmt.Errorf("can't get API token id: %w", err)
+	}
+	for _, scope := range scopes {
+		if _, err := tx.ExecContext(
+			ctx,
+			`
+			INSERT INTO api_token_scopes (
+				api_token_id,
+				scope
+			) VALUES (
+				$1, $2
+			)
+			`,
+			tokenID,
+			scope,
+		); err != nil {
+			return "", fmt.Errorf("can't insert API token scope: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("can't commit API token: %w", err)
+	}
+	return token, nil
+}
+
+// Tokens returns the given user's API tokens, oldest first.
+func (s *Sessions) Tokens(ctx context.Context, userID int64) ([]Token, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`
+		SELECT id, name, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY id
+		`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var (
+			t          Token
+			lastUsedAt sql.NullString
+			createdAt  string
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &lastUsedAt, &createdAt); err != nil {
+			return nil, fmt.Errorf("can't scan API token: %w", err)
+		}
+		if lastUsedAt.Valid {
+			if t.LastUsedAt, err = time.Parse(time.RFC3339, lastUsedAt.String); err != nil {
+				return nil, fmt.Errorf("invalid last_used_at %s: %w", lastUsedAt.String, err)
+			}
+			t.LastUsedAt = t.LastUsedAt.In(time.Local)
+		}
+		if t.CreatedAt, err = time.Parse(time.DateTime, createdAt); err != nil {
+			return nil, fmt.Errorf("invalid created_at %s: %w", createdAt, err)
+		}
+		t.CreatedAt = t.CreatedAt.In(time.Local)
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read API tokens: %w", err)
+	}
+	rows.Close()
+
+	for i := range tokens {
+		if tokens[i].Scopes, err = s.tokenScopes(ctx, tokens[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes the given API token, as long as it belongs to the given
+// user. If it doesn't, sql.ErrNoRows is returned.
+func (s *Sessions) RevokeToken(ctx context.Context, userID, tokenID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`
+		DELETE FROM api_tokens
+		WHERE id = $1
+		AND user_id = $2
+		`,
+		tokenID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("can't delete API token: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("can't count deleted API tokens: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`
+		DELETE FROM api_token_scopes
+		WHERE api_token_id = $1
+		`,
+		tokenID,
+	); err != nil {
+		return fmt.Errorf("can't delete API token scopes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit API token revocation: %w", err)
+	}
+	return nil
+}
+
+// LookupToken returns the ID of the user whose API token r carries as
+// "Authorization: Bearer", along with the scopes the token is good for. If r
+// carries no valid token, ErrNoToken is returned.
+func (s *Sessions) LookupToken(ctx context.Context, r *http.Request) (int64, []string, error) {
+	token, ok := bearer(r)
+	if !ok {
+		return 0, nil, ErrNoToken
+	}
+
+	var tokenID, userID int64
+	if err := s.db.QueryRowContext(
+		ctx,
+		`
+		SELECT id, user_id
+		FROM api_tokens
+		WHERE token_hash = $1
+		`,
+		hashToken([]byte(token)),
+	).Scan(&tokenID, &userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, ErrNoToken
+		}
+		return 0, nil, fmt.Errorf("can't look up API token: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		`
+		UPDATE api_tokens
+		SET last_used_at = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		`,
+		time.Now().UTC().Format(time.RFC3339),
+		tokenID,
+	); err != nil {
+		return 0, nil, fmt.Errorf("can't touch API token: %w", err)
+	}
+
+	scopes, err := s.tokenScopes(ctx, tokenID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return userID, scopes, nil
+}
+
+// tokenScopes returns the scopes the given API token is good for.
+func (s *Sessions) tokenScopes(ctx context.Context, tokenID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`
+		SELECT scope
+		FROM api_token_scopes
+		WHERE api_token_id = $1
+		ORDER BY scope
+		`,
+		tokenID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query API token scopes: %w", err)
+	}
+	defer rows.Close()
+
+	// Non-nil, so a token with no scopes still reads as a token.
+	scopes := []string{}
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, fmt.Errorf("can't scan API token scope: %w", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, rows.Err()
+}
+
+// RequireScope only lets requests through if someone is logged in, or if they
+// carry an API token good for scope. Logged-in users have every scope.
+//
+// Unlike Require, RequireScope accepts API tokens, so it's what JSON endpoints
+// meant for scripts should use. It relies on Middleware having run first.
+func RequireScope(scope string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserID(r.Context()); !ok {
+			reject(w, r)
+			return
+		}
+		if !HasScope(r.Context(), scope) {
+			http.Error(w, fmt.Sprintf("token lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// WithScopes returns a copy of ctx authenticated by an API token good for the
+// given scopes.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// Scopes returns the scopes of the API token ctx was authenticated by, if it
+// was authenticated by one rather than a session.
+func Scopes(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey{}).([]string)
+	return scopes, ok
+}
+
+// HasScope returns true if ctx was authenticated by a session, or by an API
+// token good for scope.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := Scopes(ctx)
+	if !ok {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// bearer returns the token in r's Authorization header, if it has one.
+func bearer(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, strings.HasPrefix(token, TokenPrefix)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"twos.dev/mainframe/web"
+)
+
+const (
+	// defaultAvailabilityDays is how many days of availability are shown when
+	// ?days= is not given.
+	defaultAvailabilityDays = 7
+	// maxAvailabilityDays is the most days of availability that can be asked
+	// for. Google calendars are only mirrored this far ahead.
+	maxAvailabilityDays = 60
+	// defaultAvailabilityBuffer is used when AVAILABILITY_BUFFER is unset.
+	defaultAvailabilityBuffer = 15 * time.Minute
+	// defaultAvailabilityMinSlot is used when AVAILABILITY_MIN_SLOT is unset.
+	defaultAvailabilityMinSlot = 30 * time.Minute
+	// defaultWorkingDays is used when WORKING_DAYS is unset.
+	defaultWorkingDays = "Mon-Fri"
+)
+
+var (
+	// availabilityToken guards the availability page. The page is disabled if
+	// this is unset.
+	availabilityToken = os.Getenv("AVAILABILITY_TOKEN")
+	// availabilityBuffer is kept free before and after every busy event, like
+	// "15m".
+	availabilityBuffer = os.Getenv("AVAILABILITY_BUFFER")
+	// availabilityMinSlot is the shortest free slot worth offering, like "30m".
+	availabilityMinSlot = os.Getenv("AVAILABILITY_MIN_SLOT")
+	// workingDaysSpec is the days of the week we take meetings on, as a range
+	// like "Mon-Fri" or a list like "Mon,Wed,Fri".
+	workingDaysSpec = os.Getenv("WORKING_DAYS")
+)
+
+// availabilityDay is our free and busy time on one day, without any details of
+// what we're busy with.
+type availabilityDay struct {
+	// Date is the day, as YYYY-MM-DD in our own time zone.
+	Date string `json:"date"`
+	// Free are the slots within working hours anyone may book.
+	Free []timeSpan `json:"free"`
+	// Busy are the merged spans within working hours we're busy for.
+	Busy []timeSpan `json:"busy"`
+}
+
+// availability is our free and busy time over several days.
+type availability struct {
+	// TimeZone is the time zone every time is given in.
+	TimeZone string `json:"time_zone"`
+	// Days are the working days covered, in order.
+	Days []availabilityDay `json:"days"`
+	// Token and NumDays echo the request, so the HTML page can link to other
+	// time zones.
+	Token   string `json:"-"`
+	NumDays int    `json:"-"`
+}
+
+// workingDays returns the days of the week in WORKING_DAYS.
+func workingDays() (map[time.Weekday]struct{}, error) {
+	spec := workingDaysSpec
+	if spec == "" {
+		spec = defaultWorkingDays
+	}
+	parse := func(s string) (time.Weekday, error) {
+		s = strings.ToLower(strings.TrimSpace(s))
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if len(s) >= 3 && strings.HasPrefix(strings.ToLower(d.String()), s) {
+				return d, nil
+			}
+		}
+		return 0, fmt.Errorf("WORKING_DAYS must look like %s or Mon,Wed,Fri, not %s", defaultWorkingDays, spec)
+	}
+
+	days := map[time.Weekday]struct{}{}
+	for _, part := range strings.Split(spec, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := parse(first)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = parse(last); err != nil {
+				return nil, err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = struct{}{}
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// durationFromEnv parses value as a duration, or returns fallback if value is
+// empty.
+func durationFromEnv(key, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 15m: %w", key, err)
+	}
+	return d, nil
+}
+
+// mergeSpans returns the union of spans as a sorted list of disjoint spans.
+func mergeSpans(spans []timeSpan) []timeSpan {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	var merged []timeSpan
+	for _, s := range spans {
+		if n := len(merged); n > 0 && !s.Start.After(merged[n-1].End) {
+			if s.End.After(merged[n-1].End) {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// clipSpans returns the parts of spans within bounds.
+func clipSpans(spans []timeSpan, bounds timeSpan) []timeSpan {
+	var clipped []timeSpan
+	for _, s := range spans {
+		if s.Start.Before(bounds.Start) {
+			s.Start = bounds.Start
+		}
+		if s.End.After(bounds.End) {
+			s.End = bounds.End
+		}
+		if s.End.After(s.Start) {
+			clipped = append(clipped, s)
+		}
+	}
+	return clipped
+}
+
+// localZoneName returns the IANA name of our own time zone, from TZ or else the
+// /etc/localtime link, or "" if it can't be told. An empty TZ means UTC.
+func localZoneName() string {
+	name, ok := os.LookupEnv("TZ")
+	if ok && name == "" {
+		return "UTC"
+	}
+	if !ok {
+		var err error
+		if name, err = os.Readlink("/etc/localtime"); err != nil {
+			return ""
+		}
+	}
+	name = strings.TrimPrefix(name, ":")
+	if _, after, ok := strings.Cut(name, "zoneinfo/"); ok {
+		name = after
+	}
+	if name == "" || strings.HasPrefix(name, "/") {
+		return ""
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// buildAvailability computes our free and busy time for the given number of
+// days starting on the day of now, with every time given in loc. Working hours
+// and days are in the time zone of now, which is our own.
+func buildAvailability(ctx context.Context, db *sql.DB, now time.Time, days int, loc *time.Location) (*availability, error) {
+	buffer, err := durationFromEnv("AVAILABILITY_BUFFER", availabilityBuffer, defaultAvailabilityBuffer)
+	if err != nil {
+		return nil, err
+	}
+	minSlot, err := durationFromEnv("AVAILABILITY_MIN_SLOT", availabilityMinSlot, defaultAvailabilityMinSlot)
+	if err != nil {
+		return nil, err
+	}
+	weekdays, err := workingDays()
+	if err != nil {
+		return nil, err
+	}
+
+	today := startOfDay(now)
+	events, err := calendarEventsBetween(ctx, db, today, today.AddDate(0, 0, days))
+	if err != nil {
+		return nil, err
+	}
+	var busy, buffered []timeSpan
+	for _, e := range events {
+		// All-day events are usually holidays, birthdays or reminders rather
+		// than time we can't meet, so they don't count.
+		if !e.Busy || e.AllDay {
+			continue
+		}
+		busy = append(busy, timeSpan{e.Start, e.End})
+		buffered = append(buffered, timeSpan{e.Start.Add(-buffer), e.End.Add(buffer)})
+	}
+	busy = mergeSpans(busy)
+	buffered = mergeSpans(buffered)
+
+	a := availability{TimeZone: loc.String()}
+	for i := 0; i < days; i++ {
+		day := today.AddDate(0, 0, i)
+		if _, ok := weekdays[day.Weekday()]; !ok {
+			continue
+		}
+		hours, err := workingHours(day)
+		if err != nil {
+			return nil, err
+		}
+		// Don't offer time that has already passed, or is about to.
+		if earliest := now.Add(buffer).Truncate(15 * time.Minute).Add(15 * time.Minute); hours.Start.Before(earliest) {
+			hours.Start = earliest
+		}
+		d := availabilityDay{Date: day.Format("2006-01-02"), Free: []timeSpan{}, Busy: []timeSpan{}}
+		if hours.End.After(hours.Start) {
+			for _, s := range freeBlocks(hours, append([]timeSpan(nil), buffered...), minSlot) {
+				d.Free = append(d.Free, timeSpan{s.Start.In(loc), s.End.In(loc)})
+			}
+			for _, s := range clipSpans(busy, hours) {
+				d.Busy = append(d.Busy, timeSpan{s.Start.In(loc), s.End.In(loc)})
+			}
+		}
+		a.Days = append(a.Days, d)
+	}
+
+	return &a, nil
+}
+
+// handleAvailability serves our free/busy time, without any event details, at
+// /availability (HTML) and /availability.json.
+//
+// Both require ?token= to match AVAILABILITY_TOKEN. They take ?days= (7 by
+// default) and ?tz= (an IANA time zone like America/New_York, ours from TZ by
+// default).
+func handleAvailability(logger *log.Logger, db *sql.DB, mux *http.ServeMux) {
+	logger = log.New(logger.Writer(), "[availability] ", logger.Flags())
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if availabilityToken == "" {
+			http.Error(w, "availability is disabled; set AVAILABILITY_TOKEN to enable it", http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(availabilityToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		days := defaultAvailabilityDays
+		if v := r.FormValue("days"); v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 1 || days > maxAvailabilityDays {
+				http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxAvailabilityDays), http.StatusBadRequest)
+				return
+			}
+		}
+		tz := r.FormValue("tz")
+		if tz == "" {
+			tz = localZoneName()
+		}
+		if tz == "" {
+			http.Error(w, "tz must be an IANA time zone like America/New_York", http.StatusBadRequest)
+			return
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unknown time zone %s", tz), http.StatusBadRequest)
+			return
+		}
+
+		a, err := buildAvailability(r.Context(), db, time.Now(), days, loc)
+		if err != nil {
+			logger.Printf("can't build availability: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		a.Token = r.FormValue("token")
+		a.NumDays = days
+
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(a); err != nil {
+				logger.Printf("can't write availability: %v", err)
+			}
+			return
+		}
+		if err := web.Render(w, "availability.html.tmpl", a); err != nil {
+			logger.Printf("error executing availability template: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	mux.HandleFunc("/availability", handler)
+	mux.HandleFunc("/availability.json", handler)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+)
+
+func TestBuildAvailability(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.New(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	defer func(buffer, minSlot, days, hours string) {
+		availabilityBuffer, availabilityMinSlot, workingDaysSpec, workingHoursSpec = buffer, minSlot, days, hours
+	}(availabilityBuffer, availabilityMinSlot, workingDaysSpec, workingHoursSpec)
+	availabilityBuffer, availabilityMinSlot, workingDaysSpec, workingHoursSpec = "", "", "", ""
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 3, day, hour, min, 0, 0, newYork)
+	}
+	if err := replaceCalendarEvents(ctx, conn, "test", []calendarEvent{
+		{UID: "meeting", Start: at(9, 11, 0), End: at(9, 12, 0), Busy: true},
+		{UID: "weekend", Start: at(7, 11, 0), End: at(7, 12, 0), Busy: true},
+		{UID: "reminder", Start: at(9, 14, 0), End: at(9, 15, 0)},
+		{UID: "holiday", Start: at(6, 0, 0), End: at(7, 0, 0), AllDay: true, Busy: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Daylight saving time starts on Sunday the 8th, between the two working
+	// days, so working hours move an hour earlier in UTC.
+	a, err := buildAvailability(ctx, conn, at(6, 7, 0), 4, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.TimeZone != "UTC" {
+		t.Errorf("got time zone %q, want UTC", a.TimeZone)
+	}
+	utc := func(day, hour, min int) time.Time {
+		return time.Date(2026, 3, day, hour, min, 0, 0, time.UTC)
+	}
+	want := []availabilityDay{
+		{
+			Date: "2026-03-06",
+			Free: []timeSpan{{utc(6, 14, 0), utc(6, 22, 0)}},
+			Busy: []timeSpan{},
+		},
+		{
+			Date: "2026-03-09",
+			Free: []timeSpan{{utc(9, 13, 0), utc(9, 14, 45)}, {utc(9, 16, 15), utc(9, 21, 0)}},
+			Busy: []timeSpan{{utc(9, 15, 0), utc(9, 16, 0)}},
+		},
+	}
+	if !reflect.DeepEqual(a.Days, want) {
+		t.Errorf("got %+v, want %+v", a.Days, want)
+	}
+
+	// Time that has passed, or is within the buffer, isn't offered.
+	a, err = buildAvailability(ctx, conn, at(9, 10, 50), 1, newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []availabilityDay{
+		{
+			Date: "2026-03-09",
+			Free: []timeSpan{{at(9, 12, 15), at(9, 17, 0)}},
+			Busy: []timeSpan{{at(9, 11, 15), at(9, 12, 0)}},
+		},
+	}
+	if !reflect.DeepEqual(a.Days, want) {
+		t.Errorf("got %+v partway through the day, want %+v", a.Days, want)
+	}
+}
+
+func TestAvailabilityTimeZone(t *testing.T) {
+	conn, err := db.New(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	defer func(token string) { availabilityToken = token }(availabilityToken)
+	availabilityToken = "secret"
+
+	mux := http.NewServeMux()
+	handleAvailability(log.New(io.Discard, "", 0), conn, mux)
+
+	for _, c := range []struct {
+		tz, query, want string
+	}{
+		{tz: "America/Chicago", want: "America/Chicago"},
+		{tz: ":Europe/Paris", want: "Europe/Paris"},
+		{tz: "", want: "UTC"},
+		{tz: "America/Chicago", query: "&tz=Asia/Tokyo", want: "Asia/Tokyo"},
+	} {
+		t.Setenv("TZ", c.tz)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/availability.json?token=secret"+c.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("got %d with TZ=%s%s, want 200", rec.Code, c.tz, c.query)
+			continue
+		}
+		var a availability
+		if err := json.NewDecoder(rec.Body).Decode(&a); err != nil {
+			t.Fatal(err)
+		}
+		if a.TimeZone != c.want {
+			t.Errorf("got time zone %q with TZ=%s%s, want %q", a.TimeZone, c.tz, c.query, c.want)
+		}
+	}
+
+	t.Setenv("TZ", "Not/A_Zone")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/availability.json?token=secret", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d with no usable time zone, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"twos.dev/mainframe/db"
+)
+
+const (
+	// dbName names the database's snapshots.
+	dbName = "mainframe"
+	// legacyDBPath is where mainframe kept its database before it could be
+	// configured.
+	legacyDBPath = "mainframe.db"
+)
+
+var (
+	// backupDir is where the backup cron writes snapshots of the database. It's
+	// a backups directory next to the database by default.
+	backupDir = os.Getenv("MAINFRAME_BACKUP_DIR")
+	// backupGzip gzips snapshots if set to true.
+	backupGzip = os.Getenv("MAINFRAME_BACKUP_GZIP")
+	// backupKeepDaily, backupKeepWeekly and backupKeepMonthly are how many
+	// days, weeks and months to keep a snapshot from.
+	backupKeepDaily   = os.Getenv("MAINFRAME_BACKUP_KEEP_DAILY")
+	backupKeepWeekly  = os.Getenv("MAINFRAME_BACKUP_KEEP_WEEKLY")
+	backupKeepMonthly = os.Getenv("MAINFRAME_BACKUP_KEEP_MONTHLY")
+)
+
+// backupConfig returns where to write snapshots, whether to gzip them, and
+// which to keep, from the environment.
+func backupConfig() (string, bool, db.Retention, error) {
+	dir := backupDir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(dbPath), "backups")
+	}
+
+	var compress bool
+	if backupGzip != "" {
+		var err error
+		if compress, err = strconv.ParseBool(backupGzip); err != nil {
+			return "", false, db.Retention{}, fmt.Errorf("MAINFRAME_BACKUP_GZIP must be true or false: %w", err)
+		}
+	}
+
+	keep := db.Retention{Daily: 7, Weekly: 4, Monthly: 12}
+	for _, v := range []struct {
+		env   string
+		value string
+		dst   *int
+	}{
+		{"MAINFRAME_BACKUP_KEEP_DAILY", backupKeepDaily, &keep.Daily},
+		{"MAINFRAME_BACKUP_KEEP_WEEKLY", backupKeepWeekly, &keep.Weekly},
+		{"MAINFRAME_BACKUP_KEEP_MONTHLY", backupKeepMonthly, &keep.Monthly},
+	} {
+		if v.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v.value)
+		if err != nil || n < 0 {
+			return "", false, db.Retention{}, fmt.Errorf("%s must be a non-negative number", v.env)
+		}
+		*v.dst = n
+	}
+
+	return dir, compress, keep, nil
+}
+
+// runBackup snapshots the database into MAINFRAME_BACKUP_DIR, then deletes
+// snapshots that have aged out of the retention policy.
+func runBackup(
+	logger *log.Logger,
+	_ string,
+	conn *sql.DB,
+	_ *http.ServeMux,
+	_ *googleClient,
+) error {
+	logger = log.New(logger.Writer(), "[backup] ", logger.Flags())
+
+	dir, compress, keep, err := backupConfig()
+	if err != nil {
+		return err
+	}
+
+	path, err := db.Backup(context.Background(), conn, dbName, dir, compress)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Backed up database to %s", path)
+
+	deleted, err := db.Prune(dbName, dir, keep)
+	if err != nil {
+		return err
+	}
+	for _, path := range deleted {
+		logger.Printf("Deleted old backup %s", path)
+	}
+	return nil
+}
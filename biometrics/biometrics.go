@@ -30,6 +30,7 @@ func Run(logger *log.Logger, _ string, db *sql.DB, mux *http.ServeMux, gcpClient
 	if err != nil {
 		return fmt.Errorf("can't annotate image: %w", err)
 	}
+	logger.Printf("Annotated image with %d labels", len(resp.LabelAnnotations))
 
 	return nil
 }
@@ -3,52 +3,551 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
-// runCalendar fetches calendar events from Google calendar and outputs a few
-// upcoming ones.
+const (
+	// calendarBlockerWindow is how far into the future source events are
+	// mirrored onto the target calendar.
+	calendarBlockerWindow = 14 * 24 * time.Hour
+	// calendarBlockerSummary is the title given to every placeholder event. It
+	// intentionally says nothing about the event it stands in for.
+	calendarBlockerSummary = "Busy"
+	// calendarBlockerProperty is the private extended property set on every
+	// placeholder event. Its value is the source calendar ID. It lets us
+	// recognize our own placeholders so they are never mirrored back.
+	calendarBlockerProperty = "mainframeBlockerSource"
+	// calendarMirrorPast is how far into the past Google calendar events are
+	// mirrored into the calendar_events table.
+	calendarMirrorPast = 24 * time.Hour
+	// calendarMirrorFuture is how far into the future Google calendar events
+	// are mirrored into the calendar_events table.
+	calendarMirrorFuture = 60 * 24 * time.Hour
+	// googleSourcePrefix is prepended to Google calendar IDs to make them event
+	// sources.
+	googleSourcePrefix = "google:"
+)
+
+var (
+	googleCalendars        = os.Getenv("GOOGLE_CALENDARS")
+	calendarBlockerSources = os.Getenv("CALENDAR_BLOCKER_SOURCES")
+	calendarBlockerTarget  = os.Getenv("CALENDAR_BLOCKER_TARGET")
+	// calendarBlockerDevTarget replaces CALENDAR_BLOCKER_TARGET in development,
+	// so a development build only ever writes placeholders to a test calendar.
+	calendarBlockerDevTarget = os.Getenv("CALENDAR_BLOCKER_DEV_TARGET")
+)
+
+// runCalendar keeps mainframe's view of our Google calendars up to date, using
+// every Google account linked to mainframe.
 //
-// This was originally going to be a calendar blocker-outer to allow personal
-// events to block out work calendars, but my need for that feature was removed
-// by other means. This is how far I'd gotten at the time, so I figured I'd keep
-// the progress in case my other solution goes away.
-func runCalendar(logger *log.Logger, _ string, db *sql.DB, mux *http.ServeMux, google *googleClient) error {
+// First, it mirrors the events of every calendar in GOOGLE_CALENDARS into the
+// calendar_events table, where the agenda and other features read them.
+// GOOGLE_CALENDARS is a comma-separated list of calendar IDs, "primary" by
+// default. A bare calendar ID is mirrored from every linked account; one
+// written as account:calendarID (e.g. me@example.com:primary) only from the
+// account with that email address.
+//
+// Then, if CALENDAR_BLOCKER_SOURCES and CALENDAR_BLOCKER_TARGET are set, it
+// blocks out time on a target calendar (e.g. a work calendar) for every busy
+// event on one or more source calendars (e.g. personal calendars). Each of
+// these is written as account:calendarID, or as a bare calendar ID on the
+// oldest linked account, so sources and target may live on different accounts.
+// Each source event gets an opaque placeholder on the target calendar titled
+// "Busy", with no description, location, attendees or reminders. Placeholders
+// are tracked in the calendar_blocks table so that moved source events move
+// their placeholder and deleted source events delete it.
+//
+// In development, placeholders go to CALENDAR_BLOCKER_DEV_TARGET instead, and
+// nothing is blocked out if it isn't set.
+func runCalendar(logger *log.Logger, version string, db *sql.DB, _ *http.ServeMux, google *googleClient) error {
 	logger = log.New(logger.Writer(), "[calendar] ", logger.Flags())
 	ctx := context.Background()
 
-	srv, err := calendar.NewService(ctx, option.WithHTTPClient(google.http))
+	allLinks, err := google.links(ctx)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve calendar client: %v", err)
+		return err
+	}
+	var links []googleLink
+	for _, link := range allLinks {
+		if err := link.requireScope(calendar.CalendarEventsScope); err != nil {
+			logger.Printf("Skipping %s: %v", link.Email, err)
+			continue
+		}
+		links = append(links, link)
+	}
+	if len(links) == 0 {
+		return &missingScopeError{Scope: calendar.CalendarEventsScope}
+	}
+	services := map[int64]*calendar.Service{}
+	for _, link := range links {
+		srv, err := calendar.NewService(ctx, option.WithHTTPClient(google.client(ctx, link.ID)))
+		if err != nil {
+			return fmt.Errorf("unable to retrieve calendar client for %s: %v", link.Email, err)
+		}
+		services[link.ID] = srv
 	}
 
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List("primary").ShowDeleted(false).
-		SingleEvents(true).TimeMin(t).MaxResults(10).OrderBy("startTime").Do()
+	now := time.Now()
+	calendars := googleCalendars
+	if calendars == "" {
+		calendars = "primary"
+	}
+	var sources []string
+	var failed int
+	for _, link := range links {
+		for _, spec := range strings.Split(calendars, ",") {
+			account, calendarID, ok := strings.Cut(strings.TrimSpace(spec), ":")
+			if !ok {
+				account, calendarID = link.Email, account
+			}
+			if calendarID == "" || !strings.EqualFold(account, link.Email) {
+				continue
+			}
+			source := googleSourcePrefix + link.Email + ":" + calendarID
+			sources = append(sources, source)
+			if err := mirrorGoogleCalendar(
+				ctx,
+				db,
+				services[link.ID],
+				source,
+				calendarID,
+				now.Add(-calendarMirrorPast),
+				now.Add(calendarMirrorFuture),
+			); err != nil {
+				// Keep going, so one dead account doesn't hold up the rest.
+				logger.Printf("can't mirror %s: %v", source, err)
+				failed++
+			}
+		}
+	}
+	if err := pruneCalendarEvents(ctx, db, googleSourcePrefix, sources); err != nil {
+		return fmt.Errorf("can't prune unmirrored calendars: %w", err)
+	}
+
+	blockerTarget := calendarBlockerTarget
+	if environmentFor(version) == development {
+		blockerTarget = calendarBlockerDevTarget
+		if calendarBlockerSources != "" && blockerTarget == "" {
+			logger.Println("Not blocking out time in development; set CALENDAR_BLOCKER_DEV_TARGET to a test calendar to try it")
+		}
+	}
+	if calendarBlockerSources != "" && blockerTarget != "" {
+		target, targetID, err := googleCalendarFor(links, blockerTarget)
+		if err != nil {
+			return fmt.Errorf("invalid blocker target %s: %w", blockerTarget, err)
+		}
+		for _, spec := range strings.Split(calendarBlockerSources, ",") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+			source, sourceID, err := googleCalendarFor(links, spec)
+			if err != nil {
+				return fmt.Errorf("invalid CALENDAR_BLOCKER_SOURCES: %w", err)
+			}
+			if err := syncCalendarBlocks(
+				ctx,
+				logger,
+				db,
+				services[source.ID],
+				services[target.ID],
+				spec,
+				blockerTarget,
+				sourceID,
+				targetID,
+				now,
+				now.Add(calendarBlockerWindow),
+			); err != nil {
+				return fmt.Errorf("can't block out %s from %s: %w", blockerTarget, spec, err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d calendars couldn't be mirrored", failed, len(sources))
+	}
+	return nil
+}
+
+// googleCalendarFor returns the linked account and calendar ID that spec
+// refers to. spec is a calendar ID, optionally preceded by the account's email
+// address and a colon. A bare calendar ID refers to the oldest linked account.
+func googleCalendarFor(links []googleLink, spec string) (googleLink, string, error) {
+	account, calendarID, ok := strings.Cut(spec, ":")
+	if !ok {
+		return links[0], spec, nil
+	}
+	for _, link := range links {
+		if strings.EqualFold(link.Email, account) {
+			return link, calendarID, nil
+		}
+	}
+	return googleLink{}, "", fmt.Errorf("no live Google link for %s", account)
+}
+
+// mirrorGoogleCalendar replaces the stored events of source with the events
+// on the Google calendar with the given ID between from and to. Placeholders
+// made by the calendar blocker are left out, since they duplicate the events
+// they were made for.
+func mirrorGoogleCalendar(
+	ctx context.Context,
+	db *sql.DB,
+	srv *calendar.Service,
+	source, calendarID string,
+	from, to time.Time,
+) error {
+	var events []calendarEvent
+	if err := srv.Events.List(calendarID).
+		ShowDeleted(false).
+		SingleEvents(true).
+		TimeMin(from.Format(time.RFC3339)).
+		TimeMax(to.Format(time.RFC3339)).
+		Pages(ctx, func(page *calendar.Events) error {
+			for _, event := range page.Items {
+				if event.Status == "cancelled" {
+					continue
+				}
+				if event.ExtendedProperties != nil {
+					if _, ok := event.ExtendedProperties.Private[calendarBlockerProperty]; ok {
+						continue
+					}
+				}
+				start, err := eventTime(event.Start)
+				if err != nil {
+					return fmt.Errorf("can't parse start of %s: %w", event.Id, err)
+				}
+				end, err := eventTime(event.End)
+				if err != nil {
+					return fmt.Errorf("can't parse end of %s: %w", event.Id, err)
+				}
+				events = append(events, calendarEvent{
+					UID:         event.Id,
+					Summary:     event.Summary,
+					Description: event.Description,
+					Location:    event.Location,
+					Start:       start,
+					End:         end,
+					AllDay:      event.Start.Date != "",
+					Busy:        blocksTime(event),
+				})
+			}
+			return nil
+		}); err != nil {
+		return fmt.Errorf("can't list events: %w", err)
+	}
+
+	return replaceCalendarEvents(ctx, db, source, events)
+}
+
+// syncCalendarBlocks makes the placeholders on the target calendar match the
+// busy events on the source calendar between from and until. source and target
+// name the calendars in calendar_blocks; sourceID and targetID are their Google
+// calendar IDs, read with sourceSrv and written with targetSrv.
+func syncCalendarBlocks(
+	ctx context.Context,
+	logger *log.Logger,
+	db *sql.DB,
+	sourceSrv, targetSrv *calendar.Service,
+	source, target string,
+	sourceID, targetID string,
+	from, until time.Time,
+) error {
+	seen := map[string]struct{}{}
+	var created, moved int
+	if err := sourceSrv.Events.List(sourceID).
+		ShowDeleted(false).
+		SingleEvents(true).
+		TimeMin(from.Format(time.RFC3339)).
+		TimeMax(until.Format(time.RFC3339)).
+		Pages(ctx, func(events *calendar.Events) error {
+			for _, event := range events.Items {
+				if !blocksTime(event) {
+					continue
+				}
+				seen[event.Id] = struct{}{}
+				c, m, err := upsertCalendarBlock(ctx, db, targetSrv, source, target, targetID, event)
+				if err != nil {
+					return err
+				}
+				created += c
+				moved += m
+			}
+			return nil
+		}); err != nil {
+		return fmt.Errorf("can't list events: %w", err)
+	}
+
+	rows, err := db.QueryContext(
+		ctx,
+		`
+		SELECT id, source_event_id, target_event_id
+		FROM calendar_blocks
+		WHERE source_calendar_id = $1
+		AND target_calendar_id = $2
+		AND ends_at > $3
+		`,
+		source,
+		target,
+		from.UTC().Format(time.RFC3339),
+	)
 	if err != nil {
-		return fmt.Errorf(
-			"unable to retrieve next ten of the user's events: %v",
-			err,
+		return fmt.Errorf("can't look up existing blocks: %w", err)
+	}
+	type block struct {
+		id            int64
+		targetEventID string
+	}
+	var stale []block
+	for rows.Next() {
+		var (
+			b             block
+			sourceEventID string
 		)
+		if err := rows.Scan(&b.id, &sourceEventID, &b.targetEventID); err != nil {
+			rows.Close()
+			return fmt.Errorf("can't scan block: %w", err)
+		}
+		if _, ok := seen[sourceEventID]; !ok {
+			stale = append(stale, b)
+		}
 	}
-	logger.Println("Upcoming events:")
-	if len(events.Items) == 0 {
-		return fmt.Errorf("  (none)")
-	} else {
-		for _, item := range events.Items {
-			date := item.Start.DateTime
-			if date == "" {
-				date = item.Start.Date
-			}
-			logger.Printf("  %v (%v)\n", item.Summary, date)
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("can't close block rows: %w", err)
+	}
+
+	for _, b := range stale {
+		if err := targetSrv.Events.Delete(targetID, b.targetEventID).Context(ctx).Do(); err != nil && !isGone(err) {
+			return fmt.Errorf("can't delete placeholder %s: %w", b.targetEventID, err)
 		}
+		if _, err := db.ExecContext(
+			ctx,
+			`
+			DELETE FROM calendar_blocks
+			WHERE id = $1
+			`,
+			b.id,
+		); err != nil {
+			return fmt.Errorf("can't delete block %d: %w", b.id, err)
+		}
+	}
+
+	// Placeholders for events that have already ended stay on the calendar, but
+	// we no longer need to track them.
+	if _, err := db.ExecContext(
+		ctx,
+		`
+		DELETE FROM calendar_blocks
+		WHERE source_calendar_id = $1
+		AND target_calendar_id = $2
+		AND ends_at <= $3
+		`,
+		source,
+		target,
+		from.UTC().Format(time.RFC3339),
+	); err != nil {
+		return fmt.Errorf("can't forget past blocks: %w", err)
+	}
+
+	if created+moved+len(stale) > 0 {
+		logger.Printf(
+			"Blocked out %s from %s: %d created, %d moved, %d deleted",
+			target,
+			source,
+			created,
+			moved,
+			len(stale),
+		)
 	}
 
 	return nil
 }
+
+// upsertCalendarBlock makes sure a placeholder for event exists on target at
+// the same time as event. target is written to through srv, as the Google
+// calendar with ID targetID. It returns 1 in the first return value if a
+// placeholder was created, or 1 in the second if one was moved.
+func upsertCalendarBlock(
+	ctx context.Context,
+	db *sql.DB,
+	srv *calendar.Service,
+	source, target, targetID string,
+	event *calendar.Event,
+) (int, int, error) {
+	startsAt, err := eventTime(event.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse start of %s: %w", event.Id, err)
+	}
+	endsAt, err := eventTime(event.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse end of %s: %w", event.Id, err)
+	}
+	starts := startsAt.UTC().Format(time.RFC3339)
+	ends := endsAt.UTC().Format(time.RFC3339)
+
+	row := db.QueryRowContext(
+		ctx,
+		`
+		SELECT id, target_event_id, starts_at, ends_at
+		FROM calendar_blocks
+		WHERE source_calendar_id = $1
+		AND source_event_id = $2
+		AND target_calendar_id = $3
+		`,
+		source,
+		event.Id,
+		target,
+	)
+	var (
+		id                          int64
+		targetEventID               string
+		existingStarts, existingEnd string
+	)
+	err = row.Scan(&id, &targetEventID, &existingStarts, &existingEnd)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, fmt.Errorf("can't look up block for %s: %w", event.Id, err)
+	}
+
+	if err == nil {
+		if existingStarts == starts && existingEnd == ends {
+			return 0, 0, nil
+		}
+		_, err := srv.Events.Patch(targetID, targetEventID, &calendar.Event{
+			Start: blockTime(event.Start),
+			End:   blockTime(event.End),
+		}).Context(ctx).Do()
+		if err == nil {
+			if _, err := db.ExecContext(
+				ctx,
+				`
+				UPDATE calendar_blocks
+				SET starts_at = $1, ends_at = $2, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $3
+				`,
+				starts,
+				ends,
+				id,
+			); err != nil {
+				return 0, 0, fmt.Errorf("can't update block %d: %w", id, err)
+			}
+			return 0, 1, nil
+		}
+		if !isGone(err) {
+			return 0, 0, fmt.Errorf("can't move placeholder %s: %w", targetEventID, err)
+		}
+		// Someone deleted the placeholder by hand. Forget it and make a new one.
+		if _, err := db.ExecContext(
+			ctx,
+			`
+			DELETE FROM calendar_blocks
+			WHERE id = $1
+			`,
+			id,
+		); err != nil {
+			return 0, 0, fmt.Errorf("can't delete block %d: %w", id, err)
+		}
+	}
+
+	placeholder, err := srv.Events.Insert(targetID, &calendar.Event{
+		Summary:      calendarBlockerSummary,
+		Start:        blockTime(event.Start),
+		End:          blockTime(event.End),
+		Transparency: "opaque",
+		Visibility:   "private",
+		Reminders: &calendar.EventReminders{
+			UseDefault:      false,
+			ForceSendFields: []string{"UseDefault"},
+		},
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{calendarBlockerProperty: source},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't create placeholder for %s: %w", event.Id, err)
+	}
+
+	if _, err := db.ExecContext(
+		ctx,
+		`
+		INSERT INTO calendar_blocks (
+			source_calendar_id,
+			source_event_id,
+			target_calendar_id,
+			target_event_id,
+			starts_at,
+			ends_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6
+		)
+		`,
+		source,
+		event.Id,
+		target,
+		placeholder.Id,
+		starts,
+		ends,
+	); err != nil {
+		return 0, 0, fmt.Errorf("can't record block for %s: %w", event.Id, err)
+	}
+
+	return 1, 0, nil
+}
+
+// blocksTime returns true if event should make its owner look busy.
+func blocksTime(event *calendar.Event) bool {
+	if event.Status == "cancelled" || event.Transparency == "transparent" {
+		return false
+	}
+	if event.ExtendedProperties != nil {
+		if _, ok := event.ExtendedProperties.Private[calendarBlockerProperty]; ok {
+			return false
+		}
+	}
+	for _, attendee := range event.Attendees {
+		if attendee.Self && attendee.ResponseStatus == "declined" {
+			return false
+		}
+	}
+	return true
+}
+
+// blockTime returns a copy of t that carries over only the time itself, not
+// any other details of the event it came from.
+func blockTime(t *calendar.EventDateTime) *calendar.EventDateTime {
+	return &calendar.EventDateTime{
+		Date:     t.Date,
+		DateTime: t.DateTime,
+		TimeZone: t.TimeZone,
+	}
+}
+
+// eventTime returns the instant described by t. All-day events start at
+// midnight local time.
+func eventTime(t *calendar.EventDateTime) (time.Time, error) {
+	if t == nil {
+		return time.Time{}, errors.New("no time given")
+	}
+	if t.DateTime != "" {
+		return time.Parse(time.RFC3339, t.DateTime)
+	}
+	return time.ParseInLocation("2006-01-02", t.Date, time.Local)
+}
+
+// isGone returns true if err is a Google API error saying the requested
+// resource does not exist (anymore).
+func isGone(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/pottytrainer"
+	"twos.dev/mainframe/secrets"
+)
+
+// command is a subcommand mainframe runs instead of booting, like `mainframe
+// secrets rotate`. It's given the arguments after its name.
+type command func(logger *log.Logger, args []string) error
+
+var commands = map[string]command{
+	"db":      runDBCommand,
+	"export":  runExportCommand,
+	"import":  runImportCommand,
+	"secrets": runSecretsCommand,
+}
+
+// secretColumns are the columns that hold values encrypted by the secrets
+// package. Any new column holding credentials belongs here, so that rotating
+// the key re-encrypts it.
+var secretColumns = []struct {
+	table, column string
+}{
+	{"google_links", "access_token"},
+	{"google_links", "refresh_token"},
+}
+
+// runCommand runs the subcommand named by args[0].
+func runCommand(logger *log.Logger, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+	return cmd(logger, args[1:])
+}
+
+// runDBCommand manages the database.
+//
+//	mainframe db status          prints the migration version and whether it's dirty
+//	mainframe db up              runs every pending migration
+//	mainframe db down N          undoes the last N migrations
+//	mainframe db force V         marks the database clean at version V
+//	mainframe db create NAME     writes empty migrations into db/migrations
+//	mainframe db backup          writes a snapshot like the backup cron does
+//	mainframe db restore <file>  replaces the database with a snapshot
+//
+// If a migration fails halfway, the database is left dirty. Undo whatever it
+// got through by hand, then force the version before it.
+//
+// Stop mainframe before restoring. The database being replaced is kept next to
+// it with a .pre-restore suffix.
+func runDBCommand(logger *log.Logger, args []string) error {
+	const usage = "usage: mainframe db status|up|down N|force V|create NAME|backup|restore <file>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: mainframe db status")
+		}
+		return withMigrator(func(m *migrate.Migrate) error {
+			latest, err := db.LatestVersion()
+			if err != nil {
+				return err
+			}
+			version, dirty, err := m.Version()
+			switch {
+			case errors.Is(err, migrate.ErrNilVersion):
+				fmt.Printf("%s has no migrations applied; latest is %d\n", dbPath, latest)
+				return nil
+			case err != nil:
+				return fmt.Errorf("can't get migration version: %w", err)
+			}
+			fmt.Printf("%s is at migration %d; latest is %d\n", dbPath, version, latest)
+			if dirty {
+				fmt.Println(&db.DirtyError{Path: dbPath, Version: version})
+			}
+			return nil
+		})
+	case "up":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: mainframe db up")
+		}
+		return withMigrator(func(m *migrate.Migrate) error {
+			if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+				return fmt.Errorf("can't migrate up: %w", err)
+			}
+			return logVersion(logger, m)
+		})
+	case "down":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: mainframe db down N")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("N must be a positive number of migrations")
+		}
+		return withMigrator(func(m *migrate.Migrate) error {
+			if err := m.Steps(-n); err != nil {
+				return fmt.Errorf("can't migrate down %d: %w", n, err)
+			}
+			return logVersion(logger, m)
+		})
+	case "force":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: mainframe db force V")
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < -1 {
+			return fmt.Errorf("V must be a migration version, or -1 for none")
+		}
+		return withMigrator(func(m *migrate.Migrate) error {
+			if err := m.Force(v); err != nil {
+				return fmt.Errorf("can't force version %d: %w", v, err)
+			}
+			return logVersion(logger, m)
+		})
+	case "create":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: mainframe db create NAME")
+		}
+		up, down, err := db.CreateMigration(db.MigrationsDir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(up)
+		fmt.Println(down)
+		return nil
+	case "backup":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: mainframe db backup")
+		}
+		conn, err := db.New(logger, dbPath)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return runBackup(logger, version, conn, nil, nil)
+	case "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: mainframe db restore <file>")
+		}
+		migration, err := db.Restore(dbPath, args[1])
+		if err != nil {
+			return err
+		}
+		logger.Printf("Restored %s from %s at migration %d", dbPath, args[1], migration)
+		return nil
+	default:
+		return fmt.Errorf("unknown db command %s; %s", args[0], usage)
+	}
+}
+
+// withMigrator calls f with the migration engine for mainframe's database,
+// without migrating it first.
+func withMigrator(f func(*migrate.Migrate) error) error {
+	conn, err := db.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	m, err := db.Migrator(conn, dbPath)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer m.Close()
+	return f(m)
+}
+
+// logVersion logs the migration version m's database is at.
+func logVersion(logger *log.Logger, m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		logger.Printf("%s has no migrations applied", dbPath)
+		return nil
+	case err != nil:
+		return fmt.Errorf("can't get migration version: %w", err)
+	}
+	logger.Printf("%s is at migration %d (dirty=%t)", dbPath, version, dirty)
+	return nil
+}
+
+// runExportCommand writes a zip of every dataset, including every user's, to
+// the given file or to a timestamped one in the working directory.
+//
+//	mainframe export [file]
+func runExportCommand(logger *log.Logger, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: mainframe export [file]")
+	}
+	conn, err := db.New(logger, dbPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	potty, err := pottytrainer.NewStore(logger, conn)
+	if err != nil {
+		return err
+	}
+
+	path := exportFilename(time.Now())
+	if len(args) == 1 {
+		path = args[0]
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("can't create export: %w", err)
+	}
+	manifest, err := writeExport(context.Background(), f, conn, potty, 0)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("can't write export: %w", err)
+	}
+
+	for _, file := range manifest.Files {
+		logger.Printf("Exported %d rows to %s", file.Rows, file.Name)
+	}
+	fmt.Println(path)
+	return nil
+}
+
+// runImportCommand reconstructs a fresh instance from an export. The database
+// is created and migrated if needed, but must not hold any data yet.
+//
+//	mainframe import <file>
+func runImportCommand(logger *log.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mainframe import <file>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("can't open export: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("can't stat export: %w", err)
+	}
+
+	conn, err := db.New(logger, dbPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	potty, err := pottytrainer.NewStore(logger, conn)
+	if err != nil {
+		return err
+	}
+
+	if err := readImport(context.Background(), logger, f, info.Size(), conn, potty); err != nil {
+		return err
+	}
+	logger.Printf("Imported %s into %s; link Google accounts again from /account", args[0], dbPath)
+	return nil
+}
+
+// runSecretsCommand manages the key that encrypts secrets at rest.
+//
+//	mainframe secrets generate  prints a new random key
+//	mainframe secrets rotate    re-encrypts every secret with the current key
+//
+// To rotate keys, generate a new one, move the old one to
+// MAINFRAME_SECRET_KEY_PREVIOUS, set the new one as MAINFRAME_SECRET_KEY, then
+// run rotate. Once it's done, the old key can be thrown away.
+func runSecretsCommand(logger *log.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: mainframe secrets generate|rotate")
+	}
+
+	switch args[0] {
+	case "generate":
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	case "rotate":
+		keys, err := secrets.FromEnv()
+		if err != nil {
+			return err
+		}
+		if !keys.Enabled() {
+			return fmt.Errorf("set MAINFRAME_SECRET_KEY to the key to encrypt with; generate one with `mainframe secrets generate`")
+		}
+		db, err := db.New(logger, dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return rotateSecrets(context.Background(), logger, db, keys)
+	default:
+		return fmt.Errorf("unknown secrets command %s", args[0])
+	}
+}
+
+// rotateSecrets re-encrypts every value in secretColumns that isn't already
+// encrypted with the current key, including any stored before encryption was
+// turned on.
+func rotateSecrets(ctx context.Context, logger *log.Logger, db *sql.DB, keys *secrets.Keyring) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, c := range secretColumns {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT id, %s FROM %s`, c.column, c.table))
+		if err != nil {
+			return fmt.Errorf("can't query %s.%s: %w", c.table, c.column, err)
+		}
+		rewrapped := map[int64]string{}
+		for rows.Next() {
+			var (
+				id    int64
+				value string
+			)
+			if err := rows.Scan(&id, &value); err != nil {
+				rows.Close()
+				return fmt.Errorf("can't scan %s.%s: %w", c.table, c.column, err)
+			}
+			if value == "" || !keys.NeedsRewrap(value) {
+				continue
+			}
+			if rewrapped[id], err = keys.Rewrap(value); err != nil {
+				rows.Close()
+				return fmt.Errorf("can't re-encrypt %s.%s of row %d: %w", c.table, c.column, id, err)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return fmt.Errorf("can't read %s.%s: %w", c.table, c.column, err)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("can't close %s rows: %w", c.table, err)
+		}
+
+		for id, value := range rewrapped {
+			if _, err := tx.ExecContext(
+				ctx,
+				fmt.Sprintf(`UPDATE %s SET %s = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, c.table, c.column),
+				value,
+				id,
+			); err != nil {
+				return fmt.Errorf("can't update %s.%s of row %d: %w", c.table, c.column, id, err)
+			}
+		}
+		logger.Printf("Re-encrypted %d values in %s.%s", len(rewrapped), c.table, c.column)
+	}
+
+	return tx.Commit()
+}
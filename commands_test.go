@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/secrets"
+)
+
+func TestRotateSecrets(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	newKeyring := func(keys ...string) *secrets.Keyring {
+		t.Helper()
+		var decoded [][]byte
+		for _, k := range keys {
+			b, err := base64.StdEncoding.DecodeString(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, b)
+		}
+		k, err := secrets.New(decoded[0], decoded[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	oldKey, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := newKeyring(oldKey).Encrypt("old refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One link from before encryption, one encrypted with the old key, and
+	// one without a refresh token.
+	for _, tokens := range [][2]string{{"plain access", "plain refresh"}, {"plain access", old}, {"plain access", ""}} {
+		if _, err := conn.ExecContext(
+			ctx,
+			`INSERT INTO google_links (access_token, token_type, refresh_token, expires_at) VALUES ($1, 'Bearer', $2, '2026-01-01T00:00:00Z')`,
+			tokens[0],
+			tokens[1],
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := newKeyring(newKey, oldKey)
+	if err := rotateSecrets(ctx, logger, conn, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	current := newKeyring(newKey)
+	rows, err := conn.QueryContext(ctx, `SELECT access_token, refresh_token FROM google_links ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][2]string
+	for rows.Next() {
+		var access, refresh string
+		if err := rows.Scan(&access, &refresh); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{access, refresh} {
+			if v != "" && keys.NeedsRewrap(v) {
+				t.Errorf("got %q after rotating, want it encrypted with the new key", v)
+			}
+		}
+		if access, err = current.Decrypt(access); err != nil {
+			t.Fatal(err)
+		}
+		if refresh, err = current.Decrypt(refresh); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, [2]string{access, refresh})
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{{"plain access", "plain refresh"}, {"plain access", "old refresh"}, {"plain access", ""}}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %q, want %q", got, want)
+			break
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base64"
 	"errors"
@@ -10,7 +11,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -18,26 +21,81 @@ import (
 	"google.golang.org/api/calendar/v3"
 	googleoauth "google.golang.org/api/oauth2/v2"
 	"google.golang.org/api/option"
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/secrets"
+	"twos.dev/mainframe/web"
 )
 
 const (
-	baseURL   = "http://localhost:9000"
 	envKeyGCP = "GCP_CREDENTIALS_FILE"
-)
 
-type googleClient struct {
-	http *http.Client
-}
+	// nextCookieName holds where to send the browser after it logs in.
+	nextCookieName = "mainframe_next"
+	// stateCookieName holds the OAuth state the browser is expected to come
+	// back with.
+	stateCookieName = "mainframe_oauth_state"
+	// stateMaxAge is how long an OAuth state is good for.
+	stateMaxAge = 5 * time.Minute
+)
 
 type userID int
-type googleUserInternalID int
 
 var (
 	gcpCredsFile = os.Getenv(envKeyGCP)
-	errNoToken   = fmt.Errorf("no Google token, or at least none that contains the scopes we need")
+
+	// identityScopes are the scopes every Google link has, which let us tell
+	// whose account it is.
+	identityScopes = []string{
+		googleoauth.OpenIDScope,
+		googleoauth.UserinfoEmailScope,
+		googleoauth.UserinfoProfileScope,
+	}
+	// includeGrantedScopes asks Google to give back every scope the account has
+	// already granted us, along with any new ones, so asking for more scopes
+	// never loses the old ones.
+	includeGrantedScopes = oauth2.SetAuthURLParam("include_granted_scopes", "true")
 )
 
-func newGoogleClient(logger *log.Logger, db *sql.DB, mux *http.ServeMux) (*googleClient, error) {
+// errInvalidState is returned when an OAuth callback's state is missing,
+// expired, already used, or meant for a different browser.
+var errInvalidState = errors.New("invalid OAuth state")
+
+// errGoogleUserTaken is returned when linking a Google account that is already
+// linked to another user.
+var errGoogleUserTaken = errors.New("that Google account is linked to another user")
+
+// googleScopePrefix is what every Google API scope we may ask for starts with.
+const googleScopePrefix = "https://www.googleapis.com/auth/"
+
+// missingScopeError is returned when something needs a Google scope that no
+// linked account has granted.
+type missingScopeError struct {
+	// Account is the email address of the Google account expected to grant
+	// the scope, or empty if any account will do.
+	Account string
+	Scope   string
+}
+
+func (e *missingScopeError) Error() string {
+	return fmt.Sprintf("Google scope %s has not been granted; grant it at %s", e.Scope, e.GrantURL())
+}
+
+// GrantURL returns a link that asks Google for the missing scope.
+func (e *missingScopeError) GrantURL() string {
+	q := url.Values{"scope": {e.Scope}}
+	if e.Account != "" {
+		q.Set("account", e.Account)
+	}
+	return web.URL("/grant/google?" + q.Encode())
+}
+
+func newGoogleClient(
+	logger *log.Logger,
+	db *sql.DB,
+	mux *http.ServeMux,
+	sessions *auth.Sessions,
+	keys *secrets.Keyring,
+) (*googleClient, error) {
 	if gcpCredsFile == "" {
 		return nil, fmt.Errorf("%s is not set", envKeyGCP)
 	}
@@ -47,202 +105,432 @@ func newGoogleClient(logger *log.Logger, db *sql.DB, mux *http.ServeMux) (*googl
 		return nil, fmt.Errorf("unable to read client secret file: %v", err)
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	loginConfig, err := google.ConfigFromJSON(b, calendar.CalendarEventsScope)
+	// Logging in only needs to know who you are. Registering also asks for the
+	// scopes the core integrations need; anything else is asked for later,
+	// through /grant/google, by whatever needs it.
+	loginConfig, err := google.ConfigFromJSON(b, identityScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	registerConfig, err := google.ConfigFromJSON(b, append(identityScopes, calendar.CalendarEventsScope)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	grantConfig, err := google.ConfigFromJSON(b, identityScopes...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
-	registerConfig, err := google.ConfigFromJSON(b, calendar.CalendarEventsScope)
+	linkConfig, err := google.ConfigFromJSON(b, append(identityScopes, calendar.CalendarEventsScope)...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
 
-	loginConfig.RedirectURL = baseURL + "/login/google/callback"
-	registerConfig.RedirectURL = baseURL + "/register/google/callback"
+	loginConfig.RedirectURL = web.URL("/login/google/callback")
+	registerConfig.RedirectURL = web.URL("/register/google/callback")
+	grantConfig.RedirectURL = web.URL("/grant/google/callback")
+	linkConfig.RedirectURL = web.URL("/link/google/callback")
 
 	mux.HandleFunc("/login/google", func(w http.ResponseWriter, r *http.Request) {
-		state, err := generateState(r.Context(), db)
+		state, err := generateState(r.Context(), db, w)
 		if err != nil {
 			logger.Printf("unable to generate random state: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Location", loginConfig.AuthCodeURL(state, oauth2.AccessTypeOffline))
-		w.WriteHeader(http.StatusMovedPermanently)
+		rememberNext(w, r)
+		http.Redirect(w, r, loginConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, includeGrantedScopes), http.StatusFound)
 	})
 
 	mux.HandleFunc("/register/google", func(w http.ResponseWriter, r *http.Request) {
-		state, err := generateState(r.Context(), db)
+		state, err := generateState(r.Context(), db, w)
 		if err != nil {
 			logger.Printf("unable to generate random state: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Location", registerConfig.AuthCodeURL(state, oauth2.AccessTypeOffline))
-		w.WriteHeader(http.StatusMovedPermanently)
+		// Google only hands out a refresh token on first consent unless we ask
+		// again, and a link without one dies within the hour.
+		rememberNext(w, r)
+		http.Redirect(w, r, registerConfig.AuthCodeURL(
+			state,
+			oauth2.AccessTypeOffline,
+			oauth2.SetAuthURLParam("prompt", "consent"),
+			includeGrantedScopes,
+		), http.StatusFound)
 	})
 
-	mux.HandleFunc("/login/google/callback", func(w http.ResponseWriter, r *http.Request) {
-		if err := consumeState(r.Context(), db, r.FormValue("state")); err != nil {
+	mux.Handle("/link/google", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, err := generateState(r.Context(), db, w)
+		if err != nil {
+			logger.Printf("unable to generate random state: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Let the user pick which of their Google accounts to link, rather than
+		// silently reusing the one they're signed in to.
+		rememberNext(w, r)
+		http.Redirect(w, r, linkConfig.AuthCodeURL(
+			state,
+			oauth2.AccessTypeOffline,
+			oauth2.SetAuthURLParam("prompt", "select_account consent"),
+			includeGrantedScopes,
+		), http.StatusFound)
+	})))
+
+	mux.Handle("/link/google/callback", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := consumeState(r.Context(), db, w, r); err != nil {
 			logger.Printf("unable to consume state: %v", err)
+			if errors.Is(err, errInvalidState) {
+				http.Error(w, "this login link is invalid or has expired; please try again", http.StatusBadRequest)
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		token, err := loginConfig.Exchange(r.Context(), r.FormValue("code"))
+		token, err := linkConfig.Exchange(r.Context(), r.FormValue("code"))
 		if err != nil {
-			logger.Printf("unable to retrieve register token from web: %v", err)
+			logger.Printf("unable to retrieve link token from web: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		userinfo, err := googleUserinfo(r.Context(), linkConfig, token)
+		if err != nil {
+			logger.Printf("unable to get userinfo: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		currentUserID, _ := auth.UserID(r.Context())
+		if _, err := linkGoogleUser(r.Context(), db, keys, currentUserID, userinfo, token); err != nil {
+			if errors.Is(err, errGoogleUserTaken) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("that Google account is already linked to another Mainframe account; unlink it there first"))
+				return
+			}
+			logger.Printf("unable to link google user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		logger.Printf("User %d linked Google user %s", currentUserID, userinfo.Id)
+		redirectToNext(w, r)
+	})))
+
+	mux.Handle("/grant/google", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes := strings.Fields(r.FormValue("scope"))
+		if len(scopes) == 0 {
+			http.Error(w, "scope is required", http.StatusBadRequest)
+			return
+		}
+		for _, scope := range scopes {
+			if !strings.HasPrefix(scope, googleScopePrefix) {
+				http.Error(w, fmt.Sprintf("%s is not a Google API scope", scope), http.StatusBadRequest)
+				return
+			}
+		}
+
+		state, err := generateState(r.Context(), db, w)
+		if err != nil {
+			logger.Printf("unable to generate random state: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		config := *grantConfig
+		config.Scopes = append(append([]string(nil), identityScopes...), scopes...)
+		opts := []oauth2.AuthCodeOption{
+			oauth2.AccessTypeOffline,
+			oauth2.SetAuthURLParam("prompt", "consent"),
+			includeGrantedScopes,
+		}
+		if account := r.FormValue("account"); account != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("login_hint", account))
+		}
+
+		rememberNext(w, r)
+		http.Redirect(w, r, config.AuthCodeURL(state, opts...), http.StatusFound)
+	})))
+
+	mux.Handle("/grant/google/callback", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		service, err := googleoauth.NewService(r.Context(), option.WithAPIKey(token.AccessToken))
+		if err := consumeState(r.Context(), db, w, r); err != nil {
+			logger.Printf("unable to consume state: %v", err)
+			if errors.Is(err, errInvalidState) {
+				http.Error(w, "this login link is invalid or has expired; please try again", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		token, err := grantConfig.Exchange(r.Context(), r.FormValue("code"))
 		if err != nil {
-			logger.Printf("unable to create oauth2 service: %v", err)
+			logger.Printf("unable to retrieve grant token from web: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		userinfo, err := googleoauth.NewUserinfoV2Service(service).Me.Get().Do()
+		userinfo, err := googleUserinfo(r.Context(), grantConfig, token)
 		if err != nil {
 			logger.Printf("unable to get userinfo: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		googleUserRow := db.QueryRowContext(
+		currentUserID, _ := auth.UserID(r.Context())
+		var googleUserID int64
+		if err := db.QueryRowContext(
 			r.Context(),
 			`
-	    SELECT id
-      FROM google_users
-      WHERE google_id = $1
-	    `,
+			SELECT id
+			FROM google_users
+			WHERE external_id = $1
+			AND user_id = $2
+			`,
 			userinfo.Id,
-		)
-		var id googleUserInternalID
-		if googleUserRow.Scan(id) != nil {
-			logger.Printf("unable to get google user id: %v", err)
+			currentUserID,
+		).Scan(&googleUserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("that Google account isn't linked to you; grant access with the account you registered with"))
+				return
+			}
+			logger.Printf("unable to get google user: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		row := db.QueryRowContext(
-			r.Context(),
-			`
-			SELECT
-				id
-			FROM users
-			INNER JOIN google_users ON google_users.user_id = users.id
-			WHERE google_users.google_id = $1
-			`,
-			id,
-		)
-		var userID int
-		if err := row.Scan(userID); err != nil {
-			if err == sql.ErrNoRows {
-				logger.Printf("tried to log in as Google user %d, but no attached user exists", id)
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("no user for that Google account exists; <a href='/register/google'>register</a>?"))
-				return
+		if err := func() error {
+			tx, err := db.BeginTx(r.Context(), nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			if _, err := saveGoogleLink(r.Context(), tx, keys, googleUserID, token); err != nil {
+				return err
 			}
+			return tx.Commit()
+		}(); err != nil {
+			logger.Printf("unable to save google link: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-	})
 
-	mux.HandleFunc("/register/google/callback", func(w http.ResponseWriter, r *http.Request) {
+		logger.Printf("Google user %s granted %s", userinfo.Id, token.Extra("scope"))
+		redirectToNext(w, r)
+	})))
+
+	mux.HandleFunc("/login/google/callback", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		if err := consumeState(r.Context(), db, r.FormValue("state")); err != nil {
+		if err := consumeState(r.Context(), db, w, r); err != nil {
 			logger.Printf("unable to consume state: %v", err)
+			if errors.Is(err, errInvalidState) {
+				http.Error(w, "this login link is invalid or has expired; please try again", http.StatusBadRequest)
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		token, err := registerConfig.Exchange(r.Context(), r.FormValue("code"))
+		token, err := loginConfig.Exchange(r.Context(), r.FormValue("code"))
 		if err != nil {
 			logger.Printf("unable to retrieve login token from web: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		service, err := googleoauth.NewService(r.Context(), option.WithAPIKey(token.AccessToken))
-		if err != nil {
-			logger.Printf("unable to create oauth2 service: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		userinfo, err := googleoauth.NewUserinfoV2Service(service).Me.Get().Do()
+		userinfo, err := googleUserinfo(r.Context(), loginConfig, token)
 		if err != nil {
 			logger.Printf("unable to get userinfo: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		googleUserRow := db.QueryRowContext(
+		var (
+			googleUserID int64
+			userID       sql.NullInt64
+		)
+		if err := db.QueryRowContext(
 			r.Context(),
 			`
 			SELECT id, user_id
 			FROM google_users
-			WHERE external_id = $2
-	    `,
+			WHERE external_id = $1
+			`,
 			userinfo.Id,
-		)
-
-		var (
-			googleUserID int
-			userID       int
-		)
-		if googleUserRow.Scan(&googleUserID, &userID) != sql.ErrNoRows {
+		).Scan(&googleUserID, &userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Printf("unable to get google user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !userID.Valid {
+			logger.Printf("tried to log in as Google user %s, but no attached user exists", userinfo.Id)
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(
-				`
-			  This Google account is already bound to a Mainframe account. <a href='/login/google'>Log in</a>?
-			  `,
-			))
+			w.Write([]byte("no user for that Google account exists; <a href='/register/google'>register</a>?"))
 			return
 		}
-		if googleUserID != 0 && userID == 0 {
-			// Row in google_user_ids exists, row in users doesn't: Previous registration left
-			// off partway through. Continue.
+
+		// Google only sends a refresh token when it asked for consent, e.g.
+		// after the user revoked access. Keep it, so a dead link comes back.
+		if token.RefreshToken != "" {
+			if err := func() error {
+				tx, err := db.BeginTx(r.Context(), nil)
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				if _, err := saveGoogleLink(r.Context(), tx, keys, googleUserID, token); err != nil {
+					return err
+				}
+				return tx.Commit()
+			}(); err != nil {
+				logger.Printf("unable to save google link: %v", err)
+			}
 		}
 
-		if _, err := db.ExecContext(
-			r.Context(),
-			`
-			INSERT INTO google_links (
-				access_token,
-				token_type,
-				refresh_token,
-				expires_at
-			) VALUES (
-				$1, $2, $3, $4, $5
-			)
-			`,
-			token.AccessToken,
-			token.TokenType,
-			token.RefreshToken,
-			token.Expiry,
-			userinfo.Id,
-		); err != nil {
-			logger.Printf("unable to insert google link: %v", err)
+		if err := sessions.Start(r.Context(), w, r, userID.Int64); err != nil {
+			logger.Printf("unable to start session: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		redirectToNext(w, r)
+	})
 
-		googleUserResult, err := db.ExecContext(
-			r.Context(),
+	mux.HandleFunc("/register/google/callback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := consumeState(r.Context(), db, w, r); err != nil {
+			logger.Printf("unable to consume state: %v", err)
+			if errors.Is(err, errInvalidState) {
+				http.Error(w, "this login link is invalid or has expired; please try again", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		token, err := registerConfig.Exchange(r.Context(), r.FormValue("code"))
+		if err != nil {
+			logger.Printf("unable to retrieve register token from web: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		userinfo, err := googleUserinfo(r.Context(), registerConfig, token)
+		if err != nil {
+			logger.Printf("unable to get userinfo: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		userID, err := linkGoogleUser(r.Context(), db, keys, 0, userinfo, token)
+		if err != nil {
+			logger.Printf("unable to register google user: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := sessions.Start(r.Context(), w, r, userID); err != nil {
+			logger.Printf("unable to start session: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		redirectToNext(w, r)
+	})
+
+	return &googleClient{
+		config: loginConfig,
+		db:     db,
+		keys:   keys,
+		logger: logger,
+	}, nil
+}
+
+// googleUserinfo returns the Google profile of whoever token belongs to.
+func googleUserinfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (*googleoauth.Userinfo, error) {
+	service, err := googleoauth.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+	if err != nil {
+		return nil, fmt.Errorf("can't create oauth2 service: %w", err)
+	}
+	return googleoauth.NewUserinfoV2Service(service).Me.Get().Context(ctx).Do()
+}
+
+// linkGoogleUser links the given Google account, and token, to the user with
+// the given ID, and returns that ID. If userID is 0, a new user is created for
+// the account instead.
+//
+// If the account is already linked to the user, or userID is 0 and the
+// account is linked to anyone, its link is replaced with token and the ID of
+// the user it's linked to is returned. If it's linked to some other user,
+// errGoogleUserTaken is returned.
+//
+// A previous registration that left off partway through is picked back up.
+func linkGoogleUser(
+	ctx context.Context,
+	db *sql.DB,
+	keys *secrets.Keyring,
+	userID int64,
+	userinfo *googleoauth.Userinfo,
+	token *oauth2.Token,
+) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		googleUserID int64
+		linkedUserID sql.NullInt64
+	)
+	err = tx.QueryRowContext(
+		ctx,
+		`
+		SELECT id, user_id
+		FROM google_users
+		WHERE external_id = $1
+		`,
+		userinfo.Id,
+	).Scan(&googleUserID, &linkedUserID)
+	switch {
+	case err == nil && linkedUserID.Valid:
+		if userID != 0 && linkedUserID.Int64 != userID {
+			return 0, errGoogleUserTaken
+		}
+		// Already linked. Take the chance to refresh (or revive) the link.
+		if _, err := saveGoogleLink(ctx, tx, keys, googleUserID, token); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, fmt.Errorf("can't commit google link: %w", err)
+		}
+		return linkedUserID.Int64, nil
+	case errors.Is(err, sql.ErrNoRows):
+		result, err := tx.ExecContext(
+			ctx,
 			`
-	 		INSERT INTO google_users (
+			INSERT INTO google_users (
 				external_id,
 				email,
 				verified_email,
@@ -255,12 +543,12 @@ func newGoogleClient(logger *log.Logger, db *sql.DB, mux *http.ServeMux) (*googl
 				link,
 				locale
 			) VALUES (
-				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
 			)
-		`,
+			`,
 			userinfo.Id,
 			userinfo.Email,
-			userinfo.VerifiedEmail,
+			userinfo.VerifiedEmail != nil && *userinfo.VerifiedEmail,
 			userinfo.FamilyName,
 			userinfo.GivenName,
 			userinfo.Name,
@@ -271,34 +559,18 @@ func newGoogleClient(logger *log.Logger, db *sql.DB, mux *http.ServeMux) (*googl
 			userinfo.Locale,
 		)
 		if err != nil {
-			logger.Printf("unable to insert google user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return 0, fmt.Errorf("can't insert google user: %w", err)
 		}
-
-		googleUserID, err := googleUserResult.LastInsertId()
-		if err != nil {
-			logger.Printf("unable to get google user id: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if _, err := db.ExecContext(
-			r.Context(),
-			`
-			UPDATE google_links
-			SET google_user_id = $1
-			WHERE id = $2
-			`,
-			googleUserID,
-		); err != nil {
-			logger.Printf("unable to insert google user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if googleUserID, err = result.LastInsertId(); err != nil {
+			return 0, fmt.Errorf("can't get google user id: %w", err)
 		}
+	case err != nil:
+		return 0, fmt.Errorf("can't look up google user: %w", err)
+	}
 
-		userResult, err := db.ExecContext(
-			r.Context(),
+	if userID == 0 {
+		result, err := tx.ExecContext(
+			ctx,
 			`
 			INSERT INTO users (
 			) VALUES (
@@ -306,97 +578,83 @@ func newGoogleClient(logger *log.Logger, db *sql.DB, mux *http.ServeMux) (*googl
 			`,
 		)
 		if err != nil {
-			logger.Printf("unable to insert user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return 0, fmt.Errorf("can't insert user: %w", err)
 		}
-
-		userID, err := userResult.LastInsertId()
-		if err != nil {
-			logger.Printf("unable to get user id: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if userID, err = result.LastInsertId(); err != nil {
+			return 0, fmt.Errorf("can't get user id: %w", err)
 		}
+	}
 
-		if _, err := db.ExecContext(
-			r.Context(),
-			`
-			UPDATE google_users
-			SET user_id = $1
-			WHERE id = $2
-			`,
-			userID,
-			googleUserID,
-		); err != nil {
-			logger.Printf("unable to insert google user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	})
-
-	return &googleClient{
-		http: &http.Client{},
-	}, nil
-}
-
-// tokenForUser returns a Google API token that fulfills the given scope for the
-// given Google user internal ID. If there is none, errNoToken is returned.
-func tokenForUser(
-	ctx context.Context,
-	logger *log.Logger,
-	mux *http.ServeMux,
-	db *sql.DB,
-	googleUserInternalID googleUserInternalID,
-	scope string,
-) (*oauth2.Token, error) {
-	row := db.QueryRowContext(
+	if _, err := tx.ExecContext(
 		ctx,
 		`
-		SELECT
-			access_token,
-			token_type,
-			refresh_token,
-			expires_at
-		FROM google_links
-		INNER JOIN google_link_scopes
-			ON google_links.id = google_link_scopes.google_link_id
-		INNER JOIN google_users
-			ON google_links.google_user_id = google_users.id
-		WHERE
-			google_user.id = $1
-		AND
-			google_link_scopes.scope = $2
-		ORDER BY expires_at DESC
-		LIMIT 1
-	  `,
-		googleUserInternalID,
-		scope,
-	)
-	token := oauth2.Token{}
-	var expiry string
-	if err := row.Scan(
-		&token.AccessToken,
-		&token.TokenType,
-		&token.RefreshToken,
-		&expiry,
+		UPDATE google_users
+		SET user_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		`,
+		userID,
+		googleUserID,
 	); err != nil {
-		return nil, fmt.Errorf("can't look up Google link token: %w", err)
+		return 0, fmt.Errorf("can't attach google user to user: %w", err)
 	}
-	var err error
-	token.Expiry, err = time.Parse(time.RFC3339, expiry)
-	if err != nil {
-		return nil, fmt.Errorf("invalid datetime in Google link expires_at column `%s`: %w", expiry, err)
+
+	if _, err := saveGoogleLink(ctx, tx, keys, googleUserID, token); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("can't commit registration: %w", err)
+	}
+	return userID, nil
+}
+
+// rememberNext holds on to r's ?next= through the OAuth round trip, so the
+// callback can send the browser back where it was going.
+func rememberNext(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !isLocalPath(next) {
+		return
 	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     nextCookieName,
+		Value:    url.QueryEscape(next),
+		Path:     "/",
+		MaxAge:   int(stateMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   web.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectToNext sends the browser wherever it was going before it logged in,
+// or home.
+func redirectToNext(w http.ResponseWriter, r *http.Request) {
+	next := "/"
+	if c, err := r.Cookie(nextCookieName); err == nil {
+		if v, err := url.QueryUnescape(c.Value); err == nil && isLocalPath(v) {
+			next = v
+		}
+		http.SetCookie(w, &http.Cookie{Name: nextCookieName, Path: "/", MaxAge: -1})
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
 
-	return &token, nil
+// isLocalPath returns true if p is a path on this site, as opposed to a URL
+// that could send the browser elsewhere.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
 }
 
-func generateState(ctx context.Context, db *sql.DB) (string, error) {
-	data := make([]byte, 1024)
+// generateState creates and stores a new OAuth state, and binds it to the
+// browser by setting it as a cookie on w. Only that browser can then complete
+// the OAuth flow, which stops an attacker from logging a victim in to the
+// attacker's account.
+func generateState(ctx context.Context, db *sql.DB, w http.ResponseWriter) (string, error) {
+	data := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, data); err != nil {
 		return "", err
 	}
-	s := base64.StdEncoding.EncodeToString(data)
+	s := base64.RawURLEncoding.EncodeToString(data)
 	if _, err := db.ExecContext(
 		ctx,
 		`
@@ -410,37 +668,43 @@ func generateState(ctx context.Context, db *sql.DB) (string, error) {
 	); err != nil {
 		return "", err
 	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    s,
+		Path:     "/",
+		MaxAge:   int(stateMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   web.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
 	return s, nil
 }
 
-func consumeState(ctx context.Context, db *sql.DB, state string) error {
-	row := db.QueryRowContext(
-		ctx,
-		`
-		SELECT id, created_at
-		FROM google_oauth_states
-		WHERE state = $1
-		`,
-		state,
-	)
-	var (
-		id           int
-		createdAtStr string
-	)
-	if err := row.Scan(&id, &createdAtStr); err != nil {
-		return fmt.Errorf("cannot scan row: %w", err)
-	}
-	if id == 0 {
-		return errors.New("state not found")
+// consumeState checks that the state Google sent back to r was generated for
+// this browser less than stateMaxAge ago, and makes sure it can't be used
+// again. If it wasn't, errInvalidState is returned.
+func consumeState(ctx context.Context, db *sql.DB, w http.ResponseWriter, r *http.Request) error {
+	state := r.FormValue("state")
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: "/", MaxAge: -1})
+
+	c, err := r.Cookie(stateCookieName)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		return fmt.Errorf("%w: state doesn't match this browser's", errInvalidState)
 	}
-	if _, err := db.ExecContext(
+
+	var createdAtStr string
+	if err := db.QueryRowContext(
 		ctx,
 		`
 		DELETE FROM google_oauth_states
-		WHERE id = $1
+		WHERE state = $1
+		RETURNING created_at
 		`,
-		id,
-	); err != nil {
+		state,
+	).Scan(&createdAtStr); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: state not found or already used", errInvalidState)
+		}
 		return fmt.Errorf("cannot delete state: %w", err)
 	}
 
@@ -449,8 +713,8 @@ func consumeState(ctx context.Context, db *sql.DB, state string) error {
 		return fmt.Errorf("cannot parse created_at: %w", err)
 	}
 
-	if time.Since(createdAt) > 5*time.Minute {
-		return errors.New("state expired")
+	if time.Since(createdAt) > stateMaxAge {
+		return fmt.Errorf("%w: state expired", errInvalidState)
 	}
 
 	return nil
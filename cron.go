@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/robfig/cron/v3"
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/web"
 )
 
 const (
 	production  environment = "production"
 	development environment = "development"
 
+	// scopeCronsRead lets API tokens list cron jobs and their runs.
+	scopeCronsRead = "crons:read"
+	// scopeCronsRun lets API tokens run cron jobs on demand.
+	scopeCronsRun = "crons:run"
+
 	minutely = "@every 1m"
 	hourly   = "@every 1h"
 	never    = "0 0 5 31 2 ?" // Feb 31 ;)
@@ -26,18 +37,42 @@ type cronSpec struct {
 }
 
 // Cron fields are, in order:
-// second minute hour day-of-month month day-of-week
+// [second] minute hour day-of-month month day-of-week
+//
+// The seconds field is optional; five-field specs start at the minute.
+//
+// dyndns, selfupdate and speedtest predate scheduling and have never run on a
+// timer in production, so they stay disabled until that's turned on on
+// purpose.
 var (
 	// 3am reserved for supervisor.sh to boot me back up if I updated
 	crons = []cronSpec{
+		{
+			name: "agenda",
+			f:    runAgenda,
+			intervals: map[environment]string{
+				development: never,
+				production:  "0 7 * * *",
+			},
+			enabled: true,
+		},
+		{
+			name: "backup",
+			f:    runBackup,
+			intervals: map[environment]string{
+				development: never,
+				production:  "30 4 * * *",
+			},
+			enabled: true,
+		},
 		{
 			name: "calendar",
 			f:    runCalendar,
 			intervals: map[environment]string{
 				development: minutely,
-				production:  "0 0 * * *",
+				production:  "*/15 * * * *",
 			},
-			enabled: false,
+			enabled: true,
 		},
 		{
 			name: "dyndns",
@@ -46,6 +81,33 @@ var (
 				development: never,
 				production:  "0 * * * *",
 			},
+			enabled: false,
+		},
+		{
+			name: "housekeeping",
+			f:    runHousekeeping,
+			intervals: map[environment]string{
+				development: hourly,
+				production:  "15 4 * * *",
+			},
+			enabled: true,
+		},
+		{
+			name: "ics",
+			f:    runICSFeeds,
+			intervals: map[environment]string{
+				development: hourly,
+				production:  "30 * * * *",
+			},
+			enabled: true,
+		},
+		{
+			name: "retention",
+			f:    runRetention,
+			intervals: map[environment]string{
+				development: hourly,
+				production:  "45 4 * * *",
+			},
 			enabled: true,
 		},
 		{
@@ -55,7 +117,7 @@ var (
 				development: minutely,
 				production:  "0 2 * * *",
 			},
-			enabled: true,
+			enabled: false,
 		},
 		{
 			name: "speedtest",
@@ -64,7 +126,7 @@ var (
 				development: never,
 				production:  "0 5 * * *",
 			},
-			enabled: true,
+			enabled: false,
 		},
 	}
 )
@@ -78,25 +140,33 @@ func startCron(
 	google *googleClient,
 ) error {
 	logger = log.New(logger.Writer(), "[cron] ", logger.Flags())
-	environment := development
-	if version != "development" {
-		environment = production
-
-	}
-	c := cron.New()
+	environment := environmentFor(version)
+	c := cron.New(cron.WithParser(cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+	)))
 
 	if environment == development {
 		logger.Println(
 			"In development mode; running crons more often & immediately",
 		)
 		for _, cronDef := range crons {
-			f := cronDef.f
-			name := cronDef.name
-			go func() {
-				if err := f(logger, version, db, mux, google); err != nil {
-					logger.Printf("%s failed: %v", name, err)
-				}
-			}()
+			if !cronDef.enabled {
+				continue
+			}
+			cronDef := cronDef
+			go runCron(logger, version, db, mux, google, cronDef)
+		}
+	}
+
+	for _, cronDef := range crons {
+		if !cronDef.enabled {
+			continue
+		}
+		cronDef := cronDef
+		if _, err := c.AddFunc(cronDef.intervals[environment], func() {
+			runCron(logger, version, db, mux, google, cronDef)
+		}); err != nil {
+			return fmt.Errorf("can't schedule %s: %w", cronDef.name, err)
 		}
 	}
 
@@ -105,3 +175,243 @@ func startCron(
 
 	return nil
 }
+
+// environmentFor returns the environment the given version of mainframe runs
+// in.
+func environmentFor(version string) environment {
+	if version != "development" {
+		return production
+	}
+	return development
+}
+
+// runCron runs one cron job and records the run in cron_runs.
+func runCron(
+	logger *log.Logger,
+	version string,
+	db *sql.DB,
+	mux *http.ServeMux,
+	google *googleClient,
+	cronDef cronSpec,
+) {
+	ctx := context.Background()
+	result, err := db.ExecContext(
+		ctx,
+		`
+		INSERT INTO cron_runs (
+			name,
+			version,
+			started_at
+		) VALUES (
+			$1, $2, $3
+		)
+		`,
+		cronDef.name,
+		version,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		logger.Printf("can't record start of %s: %v", cronDef.name, err)
+	}
+
+	var runErr sql.NullString
+	if err := cronDef.f(logger, version, db, mux, google); err != nil {
+		logger.Printf("%s failed: %v", cronDef.name, err)
+		runErr = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	if result == nil {
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Printf("can't get cron run id for %s: %v", cronDef.name, err)
+		return
+	}
+	if _, err := db.ExecContext(
+		ctx,
+		`
+		UPDATE cron_runs
+		SET ended_at = $1, error = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		`,
+		time.Now().UTC().Format(time.RFC3339),
+		runErr,
+		id,
+	); err != nil {
+		logger.Printf("can't record end of %s: %v", cronDef.name, err)
+	}
+}
+
+// cronRun is one past run of a cron job.
+type cronRun struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	// EndedAt is zero if the run hasn't finished, or mainframe stopped during
+	// it.
+	EndedAt time.Time `json:"ended_at"`
+	// Error is empty if the run succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Duration returns how long the run took, or zero if it hasn't finished.
+func (r cronRun) Duration() time.Duration {
+	if r.EndedAt.IsZero() {
+		return 0
+	}
+	return r.EndedAt.Sub(r.StartedAt)
+}
+
+// cronStatus is a cron job along with how it's been doing.
+type cronStatus struct {
+	Name     string   `json:"name"`
+	Interval string   `json:"interval"`
+	Enabled  bool     `json:"enabled"`
+	Last     *cronRun `json:"last"`
+}
+
+// cronsPage is what the crons template renders.
+type cronsPage struct {
+	Environment environment
+	Crons       []cronStatus
+	Runs        []cronRun
+}
+
+// recentCronRuns returns the most recent cron runs, newest first.
+func recentCronRuns(ctx context.Context, db *sql.DB, limit int) ([]cronRun, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`
+		SELECT name, version, started_at, ended_at, error
+		FROM cron_runs
+		ORDER BY started_at DESC, id DESC
+		LIMIT $1
+		`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query cron runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []cronRun
+	for rows.Next() {
+		var (
+			run                cronRun
+			startedAt          string
+			endedAt, errString sql.NullString
+		)
+		if err := rows.Scan(&run.Name, &run.Version, &startedAt, &endedAt, &errString); err != nil {
+			return nil, fmt.Errorf("can't scan cron run: %w", err)
+		}
+		if run.StartedAt, err = time.Parse(time.RFC3339, startedAt); err != nil {
+			return nil, fmt.Errorf("invalid started_at %s: %w", startedAt, err)
+		}
+		run.StartedAt = run.StartedAt.In(time.Local)
+		if endedAt.Valid {
+			if run.EndedAt, err = time.Parse(time.RFC3339, endedAt.String); err != nil {
+				return nil, fmt.Errorf("invalid ended_at %s: %w", endedAt.String, err)
+			}
+			run.EndedAt = run.EndedAt.In(time.Local)
+		}
+		run.Error = errString.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// cronByName returns the cron job with the given name, if there is one.
+func cronByName(name string) (cronSpec, bool) {
+	for _, cronDef := range crons {
+		if cronDef.name == name {
+			return cronDef, true
+		}
+	}
+	return cronSpec{}, false
+}
+
+// handleCrons serves the list of cron jobs and their recent runs at /crons, to
+// logged-in users only. The same is served as JSON at /crons.json, and
+// POST /crons/run.json?name= runs a job right away; both also accept API
+// tokens.
+func handleCrons(
+	logger *log.Logger,
+	db *sql.DB,
+	mux *http.ServeMux,
+	version string,
+	google *googleClient,
+) {
+	logger = log.New(logger.Writer(), "[cron] ", logger.Flags())
+	environment := environmentFor(version)
+
+	page := func(ctx context.Context) (cronsPage, error) {
+		runs, err := recentCronRuns(ctx, db, 100)
+		if err != nil {
+			return cronsPage{}, err
+		}
+
+		page := cronsPage{Environment: environment, Runs: runs}
+		for _, cronDef := range crons {
+			status := cronStatus{
+				Name:     cronDef.name,
+				Interval: cronDef.intervals[environment],
+				Enabled:  cronDef.enabled,
+			}
+			for i := range runs {
+				if runs[i].Name == cronDef.name {
+					status.Last = &runs[i]
+					break
+				}
+			}
+			page.Crons = append(page.Crons, status)
+		}
+		return page, nil
+	}
+
+	mux.Handle("/crons", auth.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := page(r.Context())
+		if err != nil {
+			logger.Printf("can't get cron runs: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := web.Render(w, "crons.html.tmpl", page); err != nil {
+			logger.Printf("error executing crons template: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	})))
+
+	mux.Handle("/crons.json", auth.RequireScope(scopeCronsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := page(r.Context())
+		if err != nil {
+			logger.Printf("can't get cron runs: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page.Crons); err != nil {
+			logger.Printf("can't write crons: %v", err)
+		}
+	})))
+
+	mux.Handle("/crons/run.json", auth.RequireScope(scopeCronsRun, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cronDef, ok := cronByName(r.FormValue("name"))
+		if !ok {
+			http.Error(w, "name must be the name of a cron job", http.StatusNotFound)
+			return
+		}
+
+		userID, _ := auth.UserID(r.Context())
+		logger.Printf("User %d ran %s on demand", userID, cronDef.name)
+		go runCron(logger, version, db, mux, google, cronDef)
+		w.WriteHeader(http.StatusAccepted)
+	})))
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotTimeFormat is how a snapshot's file name records when it was taken.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// Retention is how many snapshots Prune keeps. The newest snapshot of each of
+// the last Daily days, Weekly weeks and Monthly months is kept; a snapshot
+// that covers several of those only counts once.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Snapshot is a backup of a database, as written by Backup.
+type Snapshot struct {
+	Path    string
+	TakenAt time.Time
+}
+
+// Backup writes a consistent snapshot of the named database, which conn is
+// open to, into dir, and returns its path. The snapshot is named after the
+// database and the time it was taken, and is gzipped if compress is true.
+//
+// The database stays usable while the snapshot is taken.
+func Backup(ctx context.Context, conn *sql.DB, name, dir string, compress bool) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("can't create backup directory %s: %w", dir, err)
+	}
+
+	takenAt := time.Now().UTC()
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.db", name, takenAt.Format(snapshotTimeFormat)))
+	tmp := path + ".tmp"
+	// VACUUM INTO refuses to overwrite anything, even an empty file.
+	_ = os.Remove(tmp)
+	if _, err := conn.ExecContext(ctx, `VACUUM INTO $1`, tmp); err != nil {
+		return "", fmt.Errorf("can't snapshot database: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	if compress {
+		path += ".gz"
+		if err := gzipFile(tmp, path+".tmp"); err != nil {
+			os.Remove(path + ".tmp")
+			return "", err
+		}
+		tmp = path + ".tmp"
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("can't move snapshot into place: %w", err)
+	}
+	return path, nil
+}
+
+// Snapshots returns every snapshot of the named database in dir, newest
+// first.
+func Snapshots(name, dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("can't list backup directory %s: %w", dir, err)
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		stamp := strings.TrimPrefix(entry.Name(), name+"-")
+		if stamp == entry.Name() {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ".db")
+		takenAt, err := time.Parse(snapshotTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Path: filepath.Join(dir, entry.Name()), TakenAt: takenAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].TakenAt.After(snapshots[j].TakenAt) })
+	return snapshots, nil
+}
+
+// Prune deletes the snapshots of the named database in dir that keep doesn't
+// cover, and returns the paths it deleted. The newest snapshot is never
+// deleted.
+func Prune(name, dir string, keep Retention) ([]string, error) {
+	snapshots, err := Snapshots(name, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := map[string]bool{}
+	keepNewest := func(limit int, period func(time.Time) string) {
+		seen := map[string]bool{}
+		for _, s := range snapshots {
+			p := period(s.TakenAt)
+			if seen[p] {
+				continue
+			}
+			if len(seen) == limit {
+				return
+			}
+			seen[p] = true
+			kept[s.Path] = true
+		}
+	}
+	keepNewest(keep.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepNewest(keep.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepNewest(keep.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+	if len(snapshots) > 0 {
+		kept[snapshots[0].Path] = true
+	}
+
+	var deleted []string
+	for _, s := range snapshots {
+		if kept[s.Path] {
+			continue
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return deleted, fmt.Errorf("can't delete snapshot %s: %w", s.Path, err)
+		}
+		deleted = append(deleted, s.Path)
+	}
+	return deleted, nil
+}
+
+// Restore replaces the database at path with the given snapshot, which may be
+// gzipped. The current database is kept alongside it with a .pre-restore
+// suffix. It returns the snapshot's migration version. Nothing else may have
+// the database open.
+//
+// The snapshot must pass an integrity check, and must not be dirty or at a
+// migration version newer than this binary knows about. Older snapshots are
+// fine; they're migrated up the next time the database is opened.
+func Restore(path, snapshot string) (uint, error) {
+	tmp := path + ".restore"
+	if err := copySnapshot(snapshot, tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	version, err := checkSnapshot(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("won't restore %s: %w", snapshot, err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// Fold the write-ahead log into the database, so the copy kept aside is
+		// whole and the log can't be replayed onto the snapshot.
+		if err := checkpoint(path); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(path, path+".pre-restore"); err != nil {
+			return 0, fmt.Errorf("can't move current database aside: %w", err)
+		}
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("can't remove %s%s: %w", path, suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("can't move snapshot into place: %w", err)
+	}
+	return version, nil
+}
+
+// checkpoint writes everything in the database at path's write-ahead log into
+// the database itself, and empties the log.
+func checkpoint(path string) error {
+	conn, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("can't checkpoint %s: %w", path, err)
+	}
+	return conn.Close()
+}
+
+// checkSnapshot returns the migration version of the database at path, or an
+// error if it isn't safe to restore.
+func checkSnapshot(path string) (uint, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, fmt.Errorf("can't open snapshot: %w", err)
+	}
+	defer conn.Close()
+
+	var integrity string
+	if err := conn.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return 0, fmt.Errorf("can't check snapshot integrity: %w", err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("snapshot is corrupt: %s", integrity)
+	}
+
+	var (
+		version int
+		dirty   bool
+	)
+	if err := conn.QueryRow(`SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty); err != nil {
+		return 0, fmt.Errorf("can't read snapshot's migration version: %w", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("snapshot is dirty at migration %d", version)
+	}
+	latest, err := LatestVersion()
+	if err != nil {
+		return 0, err
+	}
+	if uint(version) > latest {
+		return 0, fmt.Errorf("snapshot is at migration %d, but this mainframe only knows up to %d", version, latest)
+	}
+	return uint(version), nil
+}
+
+// copySnapshot copies the snapshot at src to dst, decompressing it if it's
+// gzipped.
+func copySnapshot(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("can't open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if strings.HasSuffix(src, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("can't decompress snapshot: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("can't create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("can't copy snapshot: %w", err)
+	}
+	return out.Close()
+}
+
+// gzipFile writes a gzipped copy of the file at src to dst.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("can't open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("can't create %s: %w", dst, err)
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return fmt.Errorf("can't compress snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("can't compress snapshot: %w", err)
+	}
+	return out.Close()
+}
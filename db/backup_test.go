@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		keep    Retention
+		deleted []string
+	}{
+		{
+			// Daily keeps the 10th and 9th, weekly adds the 8th (the Sunday
+			// before), and monthly adds February and January.
+			name: "retention",
+			keep: Retention{Daily: 2, Weekly: 2, Monthly: 3},
+			deleted: []string{
+				"test-20251231T000000Z.db.gz",
+				"test-20260301T000000Z.db",
+				"test-20260310T060000Z.db",
+			},
+		},
+		{
+			name: "newest only",
+			deleted: []string{
+				"test-20251231T000000Z.db.gz",
+				"test-20260120T000000Z.db",
+				"test-20260215T000000Z.db",
+				"test-20260301T000000Z.db",
+				"test-20260308T000000Z.db",
+				"test-20260309T000000Z.db",
+				"test-20260310T060000Z.db",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range []string{
+				"test-20260310T120000Z.db",
+				"test-20260310T060000Z.db",
+				"test-20260309T000000Z.db",
+				"test-20260308T000000Z.db",
+				"test-20260301T000000Z.db",
+				"test-20260215T000000Z.db",
+				"test-20260120T000000Z.db",
+				"test-20251231T000000Z.db.gz",
+				// Neither of these is a snapshot of test.
+				"other-20200101T000000Z.db",
+				"test-latest.db",
+			} {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deleted, err := Prune("test", dir, c.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, path := range deleted {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s still exists after being pruned", path)
+				}
+				names = append(names, filepath.Base(path))
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, c.deleted) {
+				t.Errorf("deleted %q, want %q", names, c.deleted)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := 10 - len(c.deleted); len(entries) != want {
+				t.Errorf("got %d files left, want %d", len(entries), want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := New(log.New(io.Discard, "", 0), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO users DEFAULT VALUES`); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := Backup(ctx, conn, "test", filepath.Join(dir, "backups"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(snapshot, ".db.gz") {
+		t.Errorf("got snapshot %s, want it gzipped", snapshot)
+	}
+	if _, err := conn.Exec(`INSERT INTO users DEFAULT VALUES`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	version, err := Restore(path, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Errorf("restored migration %d, want %d", version, latest)
+	}
+	if n := countUsers(t, path); n != 1 {
+		t.Errorf("got %d users after restoring, want 1", n)
+	}
+	if n := countUsers(t, path+".pre-restore"); n != 2 {
+		t.Errorf("got %d users kept aside before restoring, want 2", n)
+	}
+
+	// A snapshot from a newer mainframe, or one left dirty, is refused and
+	// the database is left alone.
+	for name, query := range map[string]string{
+		"newer": `UPDATE schema_migrations SET version = version + 1`,
+		"dirty": `UPDATE schema_migrations SET dirty = true`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			bad := filepath.Join(dir, name+".db")
+			if err := copySnapshot(snapshot, bad); err != nil {
+				t.Fatal(err)
+			}
+			conn, err := sql.Open("sqlite", bad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Exec(query); err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			if _, err := Restore(path, bad); err == nil {
+				t.Fatalf("restored a %s snapshot", name)
+			}
+			if n := countUsers(t, path); n != 1 {
+				t.Errorf("got %d users after refusing to restore, want 1", n)
+			}
+			if _, err := os.Stat(path + ".restore"); !os.IsNotExist(err) {
+				t.Errorf("left %s.restore behind", path)
+			}
+		})
+	}
+}
+
+// countUsers returns how many users the database at path has.
+func countUsers(t *testing.T, path string) int {
+	t.Helper()
+	conn, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
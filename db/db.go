@@ -4,28 +4,88 @@ package db
 
 import (
 	"database/sql"
+	"embed"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/golang-migrate/migrate/v4/source/pkger"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "modernc.org/sqlite"
 )
 
-// New creates a database connection to the SQLite database at the given path,
-// migrates the database if necessary, and returns the connection.
+// migrations are compiled into the binary, so it always carries the ones it
+// was built with.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationSource returns a migration source reading from migrations.
+func migrationSource() (source.Driver, error) {
+	src, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("can't read embedded migrations: %w", err)
+	}
+	return src, nil
+}
+
+// busyTimeout is how long a connection waits for another to finish writing
+// before giving up with SQLITE_BUSY.
+const busyTimeout = 5 * time.Second
+
+// DefaultPath returns where the database lives if nobody says otherwise:
+// mainframe/mainframe.db under $XDG_DATA_HOME, or under ~/.local/share if
+// that's unset.
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("can't find home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "mainframe", "mainframe.db"), nil
+}
+
+// Open opens the SQLite database at path without migrating it, creating its
+// directory if needed.
+//
+// The database is put in WAL mode with foreign keys enforced. Only one
+// connection is ever open, so writers queue up instead of failing with
+// SQLITE_BUSY; don't start a query while another's rows are still open.
 //
 // It is the caller's responsibility to call db.Close().
-func New(logger *log.Logger, name string) (*sql.DB, error) {
-	path := fmt.Sprintf("%s.db", name)
+func Open(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("can't create database directory: %w", err)
+	}
 
-	db, err := sql.Open("sqlite", path)
+	pragmas := url.Values{"_pragma": {
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()),
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"foreign_keys(ON)",
+	}}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
 	if err != nil {
 		return nil, fmt.Errorf("can't open database: %v", err)
 	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
 
+// Migrator returns the migration engine for the database at path, which db is
+// open to. Closing it closes db.
+func Migrator(db *sql.DB, path string) (*migrate.Migrate, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	driver, err := sqlite.WithInstance(db, &sqlite.Config{DatabaseName: name})
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -35,26 +95,78 @@ func New(logger *log.Logger, name string) (*sql.DB, error) {
 		)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"pkger:///db/migrations",
-		name,
-		driver,
-	)
+	src, err := migrationSource()
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, name, driver)
 	if err != nil {
 		return nil, fmt.Errorf("can't set up migrations: %w", err)
 	}
+	return m, nil
+}
+
+// New creates a database connection to the SQLite database at the given path,
+// migrates the database if necessary, and returns the connection.
+//
+// If a migration failed partway on a previous boot, the database is dirty and
+// New refuses to touch it until someone fixes it by hand.
+//
+// It is the caller's responsibility to call db.Close().
+func New(logger *log.Logger, path string) (*sql.DB, error) {
+	db, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := Migrator(db, path)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Closing the migrator closes db too, so it's only closed on failure; its
+	// migration source is embedded and holds nothing open.
+	fail := func(err error) (*sql.DB, error) {
+		m.Close()
+		return nil, err
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fail(fmt.Errorf("can't get migration version: %w", err))
+	}
+	if dirty {
+		return fail(&DirtyError{Path: path, Version: version})
+	}
 
 	err = m.Up()
 	if err == migrate.ErrNoChange {
 		return db, nil
 	} else if err != nil {
-		return nil, fmt.Errorf("can't migrate database: %v", err)
+		return fail(fmt.Errorf("can't migrate database: %v", err))
 	}
 
-	version, dirty, err := m.Version()
-	if err != nil {
-		return nil, fmt.Errorf("can't get migration version: %w", err)
+	if version, _, err = m.Version(); err != nil {
+		return fail(fmt.Errorf("can't get migration version: %w", err))
 	}
-	logger.Printf("Database migrated to %d (dirty=%t)", version, dirty)
+	logger.Printf("Database migrated to %d", version)
 	return db, nil
 }
+
+// DirtyError is returned when a database is dirty, meaning a migration failed
+// partway through and may have left it half-changed.
+type DirtyError struct {
+	Path    string
+	Version uint
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf(
+		"%s is dirty at migration %d, which failed partway through; "+
+			"undo whatever it managed to do by hand, "+
+			"then run `mainframe db force %d` and start mainframe again",
+		e.Path,
+		e.Version,
+		int(e.Version)-1,
+	)
+}
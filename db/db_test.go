@@ -0,0 +1,106 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	conn, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Migrator(conn, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("can't migrate up: %v", err)
+	}
+	version, dirty, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest || dirty {
+		t.Fatalf("migrated up to %d (dirty=%t), want %d", version, dirty, latest)
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatalf("can't migrate down: %v", err)
+	}
+	if _, _, err := m.Version(); !errors.Is(err, migrate.ErrNilVersion) {
+		t.Fatalf("got version error %v after migrating down, want %v", err, migrate.ErrNilVersion)
+	}
+
+	var tables int
+	if err := conn.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`,
+	).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables left after migrating down", tables)
+	}
+}
+
+func TestNewRefusesDirtyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	conn, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Migrator(conn, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Force(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	var dirtyErr *DirtyError
+	if _, err := New(log.New(io.Discard, "", 0), path); !errors.As(err, &dirtyErr) {
+		t.Fatalf("got error %v, want a *DirtyError", err)
+	}
+	if dirtyErr.Version != 3 {
+		t.Errorf("got dirty version %d, want 3", dirtyErr.Version)
+	}
+	if n := openFiles(t, path); n != 0 {
+		t.Errorf("got %d files of the database still open after refusing it, want 0", n)
+	}
+}
+
+// openFiles returns how many files this process has open whose paths start
+// with prefix. It skips the test where that can't be told.
+func openFiles(t *testing.T, prefix string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("can't list open files: %v", err)
+	}
+	var n int
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && strings.HasPrefix(target, prefix) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// MigrationsDir is where migrations live in the source tree.
+const MigrationsDir = "db/migrations"
+
+// migrationNameRE is what a name given to CreateMigration must look like.
+var migrationNameRE = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// LatestVersion returns the newest migration version this binary has.
+func LatestVersion() (uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := lastVersion(src)
+	if err != nil {
+		return 0, fmt.Errorf("can't find latest migration: %w", err)
+	}
+	return version, nil
+}
+
+// CreateMigration writes empty up and down migrations with the given name into
+// dir, numbered after the newest one already there, and returns their paths.
+func CreateMigration(dir, name string) (string, string, error) {
+	if !migrationNameRE.MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q must be lowercase letters, digits and underscores", name)
+	}
+
+	src, err := source.Open("file://" + dir)
+	if err != nil {
+		return "", "", fmt.Errorf("can't open migrations in %s: %w", dir, err)
+	}
+	defer src.Close()
+
+	version, err := lastVersion(src)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("can't find latest migration: %w", err)
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%06d_%s", version+1, name))
+	up, down := base+".up.sql", base+".down.sql"
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return "", "", fmt.Errorf("can't create %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return "", "", fmt.Errorf("can't create %s: %w", path, err)
+		}
+	}
+	return up, down, nil
+}
+
+// lastVersion returns the newest migration version in src. If src has no
+// migrations, os.ErrNotExist is returned.
+func lastVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
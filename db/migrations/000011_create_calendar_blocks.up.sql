@@ -0,0 +1,12 @@
+CREATE TABLE calendar_blocks (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  source_calendar_id TEXT NOT NULL,
+  source_event_id TEXT NOT NULL,
+  target_calendar_id TEXT NOT NULL,
+  target_event_id TEXT NOT NULL,
+  starts_at TEXT NOT NULL CHECK (DATETIME(starts_at) IS NOT NULL),
+  ends_at TEXT NOT NULL CHECK (DATETIME(ends_at) IS NOT NULL),
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL),
+  UNIQUE (source_calendar_id, source_event_id, target_calendar_id)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE calendar_events (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  source TEXT NOT NULL,
+  uid TEXT NOT NULL,
+  recurrence_id TEXT NOT NULL DEFAULT '',
+  summary TEXT NOT NULL DEFAULT '',
+  description TEXT NOT NULL DEFAULT '',
+  location TEXT NOT NULL DEFAULT '',
+  starts_at TEXT NOT NULL CHECK (DATETIME(starts_at) IS NOT NULL),
+  ends_at TEXT NOT NULL CHECK (DATETIME(ends_at) IS NOT NULL),
+  all_day INTEGER NOT NULL DEFAULT 0,
+  busy INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL),
+  UNIQUE (source, uid, recurrence_id)
+);
+
+CREATE INDEX calendar_events_starts_at ON calendar_events (starts_at);
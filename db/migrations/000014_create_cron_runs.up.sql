@@ -0,0 +1,12 @@
+CREATE TABLE cron_runs (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  name TEXT NOT NULL,
+  version TEXT NOT NULL,
+  started_at TEXT NOT NULL CHECK (DATETIME(started_at) IS NOT NULL),
+  ended_at TEXT CHECK (ended_at IS NULL OR DATETIME(ended_at) IS NOT NULL),
+  error TEXT,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX cron_runs_name_started_at ON cron_runs (name, started_at);
@@ -0,0 +1,2 @@
+ALTER TABLE
+  google_links DROP dead_at;
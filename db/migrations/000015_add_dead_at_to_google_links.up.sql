@@ -0,0 +1,4 @@
+ALTER TABLE
+  google_links
+ADD
+  COLUMN dead_at TEXT CHECK (dead_at IS NULL OR DATETIME(dead_at) IS NOT NULL);
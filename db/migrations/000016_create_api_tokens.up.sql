@@ -0,0 +1,16 @@
+CREATE TABLE api_tokens (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  last_used_at TEXT CHECK (last_used_at IS NULL OR DATETIME(last_used_at) IS NOT NULL),
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE TABLE api_token_scopes (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  api_token_id INTEGER NOT NULL REFERENCES api_tokens(id) ON DELETE CASCADE,
+  scope TEXT NOT NULL,
+  UNIQUE (api_token_id, scope)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cron_run_rollups;
+DROP TABLE IF EXISTS ip_address_rollups;
+DROP TABLE IF EXISTS speedtest_rollups;
@@ -0,0 +1,34 @@
+CREATE TABLE speedtest_rollups (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  period_start TEXT NOT NULL CHECK (DATETIME(period_start) IS NOT NULL),
+  hostname TEXT NOT NULL,
+  tests INTEGER NOT NULL,
+  kbps_down_min REAL NOT NULL,
+  kbps_down_max REAL NOT NULL,
+  kbps_down_sum REAL NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL),
+  UNIQUE (period_start, hostname)
+);
+
+CREATE TABLE ip_address_rollups (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  period_start TEXT NOT NULL CHECK (DATETIME(period_start) IS NOT NULL),
+  ip_address TEXT NOT NULL,
+  sightings INTEGER NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL),
+  UNIQUE (period_start, ip_address)
+);
+
+CREATE TABLE cron_run_rollups (
+  id INTEGER PRIMARY KEY ASC AUTOINCREMENT,
+  period_start TEXT NOT NULL CHECK (DATETIME(period_start) IS NOT NULL),
+  name TEXT NOT NULL,
+  runs INTEGER NOT NULL,
+  failures INTEGER NOT NULL,
+  seconds REAL NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP CHECK (DATETIME(updated_at) IS NOT NULL),
+  UNIQUE (period_start, name)
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS potty_ingredients;
+DROP TABLE IF EXISTS potty_food_names;
+DROP TABLE IF EXISTS potty_foods;
+DROP TABLE IF EXISTS potty_poops;
+DROP TABLE IF EXISTS potty_eats;
+DROP TABLE IF EXISTS potty_tokens;
+DROP TABLE IF EXISTS potty_apple_users;
+DROP TABLE IF EXISTS potty_users;
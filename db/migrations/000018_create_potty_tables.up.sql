@@ -0,0 +1,77 @@
+CREATE TABLE potty_users (
+  id TEXT PRIMARY KEY,
+  email TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE TABLE potty_apple_users (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  identity_token TEXT NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE TABLE potty_tokens (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  token TEXT NOT NULL UNIQUE,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE TABLE potty_eats (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  food_id TEXT NOT NULL,
+  food_text TEXT NOT NULL,
+  ate_at TEXT NOT NULL CHECK (DATETIME(ate_at) IS NOT NULL),
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX potty_eats_user_id ON potty_eats (user_id, ate_at);
+
+CREATE TABLE potty_poops (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  pooped_at TEXT NOT NULL CHECK (DATETIME(pooped_at) IS NOT NULL),
+  quality INTEGER NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX potty_poops_user_id ON potty_poops (user_id, pooped_at);
+
+CREATE TABLE potty_foods (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX potty_foods_user_id ON potty_foods (user_id);
+
+CREATE TABLE potty_food_names (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  food_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX potty_food_names_user_id ON potty_food_names (user_id);
+
+CREATE TABLE potty_ingredients (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  resulting_food_id TEXT NOT NULL,
+  component_food_id TEXT NOT NULL,
+  created_at TEXT NOT NULL CHECK (DATETIME(created_at) IS NOT NULL),
+  updated_at TEXT NOT NULL CHECK (DATETIME(updated_at) IS NOT NULL)
+);
+
+CREATE INDEX potty_ingredients_user_id ON potty_ingredients (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE potty_poops DROP COLUMN notes;
+ALTER TABLE potty_poops DROP COLUMN bristol;
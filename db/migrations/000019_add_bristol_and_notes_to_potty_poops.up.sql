@@ -0,0 +1,4 @@
+ALTER TABLE potty_poops
+ADD COLUMN bristol INTEGER NOT NULL DEFAULT 0 CHECK (bristol BETWEEN 0 AND 7);
+ALTER TABLE potty_poops
+ADD COLUMN notes TEXT NOT NULL DEFAULT '';
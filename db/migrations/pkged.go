@@ -1,12 +0,0 @@
-// Code generated by pkger; DO NOT EDIT.
-
-// +build !skippkger
-
-package coldbrewcrew
-
-import (
-	"github.com/markbates/pkger"
-	"github.com/markbates/pkger/pkging/mem"
-)
-
-var _ = pkger.Apply(mem.UnmarshalEmbed([]byte(`1f8b08000000000000ffec595d7322abd3ff2aa7b8dd4918474dd4bbacd918b349ca35d998786a6b8b019c411998038c2f3995effe14cca8a331d17daefe75ca4a45e8e607340ddd30ddff0226465283d6bf206226cec2532c1318718419e21a268889914209b5804ba6400bc09f9a2abd86a4e312ca03dd2495caf49089416bcf901eb8772303cb021eb89418b400f0c0235211353be68a240c99d818a22fe52ee4965477c8e018b4fe06a7e097071e0ce214b48cca6841f429d2528016d096fa8bd0940a42055eb4fefa740d104b4e42456758d1196433a9263233c0031d79c538d5764a0b3e8d24f0403a8928b1d55f4b3d39c088a308786094d88effbfe9f6f45252ec0591702f6446b7300952931019aaa15d9afab4d1fe3211c18426c0035cda254b6d55714953a707a470cca6141a64470ab311b34a0b17866ae0012c935451ad61f8cad2a0cc187164689911bdb2b44cbf72163a5a18c4045590336d0a069dbb9a5aa446ae2a10e553e6046669ec1657d0a4dc48345a13146f9224a8d72bcd770cc884a14a200e29992145f49fc0e088514e4a60ce596a187ecf59f71f31b45e258c135442af400a099219c67734e92c349cae1b12525f13b65f89c2b51251d6868e5165830aea671b74bd1294e8ad290d2f297d5ef79b9bd45ad60461b90585e984cd8107083228449a42fd0fdf2221516cea36992621b5eba1024bc24454aa42a445a54cdbde67b50d0e13482dca9c98cecbe4583b5b5cd1a93307aa945456ea8f7c41384b90524c40c234968a387f526a2744c2489e8c9036ef1b52862727c5c45b4dea9f5d3c8839a362c748ab263846ea7fdc5b91852042ef8569ca47594a7217b2079a524a0cdd56f1219e329261361a212e614cd5f64c9223119d242c52c850b82ca7b50361707990ff146f0f3e33b47a68bf958d658a1fda47cb4ce18325cbd170c4f89f76898d4947dbbbbdaf13937fdc65c7451749c5384776d3b5c413ba753c62a46386a54a21556aa650fa5173244f928c1be69cc12688092c858e159d5214726aa1bbceec87b0f5d6e50e8ab0ed437c4057a9f3cbb2d46b8c161ac73364e1bbeced8f1e08ef2d631b5172f2e9e73315af8d835e244b8f7fe8db653f4e1b22dfc9678cb06ada69734a862316ad5c9f3ccd42aa4ea58a20323271977b7e321d6f0eb72fe854f245a5ead7f7e156b7ea2e944098434db1a22694f30f401a718d023f2fb731821a189b847fc477ab718de9243ab5b7597e6d112ea3d369b0dd62efadd3a9bd71ad911405448453550d962456d8112c41115d96f6ea71369453abf53bb278e4e44de394462b227527668546212b93f6258af80602a759991c25464b65caac48d2794a154bf2fb742d8991a84c0b6a8c42ce53960dae7005e58de6657abb8ba2234eb1b147acccd64c449c8e388be20d29f44263c439a4738aa998ee6aca847b3badf8f6fecb5fefebeb406834a23145c499b87bb633099d6f65123259683cef96d86fc3bc80218bd655a397f5e2499930f7f1660be83c638a9c721de39f4c1a4a52c584b10e0b784038cf9b9f359396aa104b3961347fb2ac98f667a9f21573b9a65d3c883466ac68b11f0ea992eea3c1d2f995e8ac5e6aa7cfbc9669a793345fb42ddced56d0c576b95a44e7e9aa02f542186415af3261723d143588dde36f49ada55b7a8a772d85c6dff1f5c28a5b9c586d1496ee0868a398885cd342e0a2580f5f1c0ce001ab03686892161f601b344c91724f9242fa4c302c49a9063333aa9c6dd20d47dad3043c30a58248057779211c231ca3c03f0c55f28e9fa15d618dfc50dcf27be03370a6a674f979f7092e9e90d1e788f73efc13f09e15db034b84b6ff09d53a779c1f0157c61265ce42f7e25225e78b3dc000c629c2934f508c08f449b392d9871be5cea1a6385314868c30957da8affcc82a24f448aae433d0f294da010fc1093bde2f0f3c526d56d12091719eb356f19f9c75278915b2f52f3830747687985886b00e0acc75e49d247b603092a789240efd4495662e265639ad9c81b7b7370f8cf255ec8915b6ec4b38bfef9920747eea9e04ad3cd4684b420d62dc0d24f2086009e701cd5e29689df94d0f24d663b46a81efaabf9d2b6981c00f2a2715ffa4ea3f0641abde6805fe69a35aaf37cf8366f5c43f6ff9d63130fd9b58c58c10d7d4b92d17c4a453d0aa9c9d9f9f0741d3e99d8256b55a69343c70cf99988056c5035d2141abead7cf1afeb9077e32025a75bfe2818ead5969fac48ee37ba087c86f1cc9df3e68fded7beeef97072eaca43aa5d84ef9608bca59b57e566f3683c003f7da72eab546c3affb81ffe681bb0ff1bebfc4af56f8e681f661f8b346c5f72b6f1ef8ca94890f9ca356ab56eb6f1e78586fc3572ef1448356c35627f9fed4fce69907ae388ab45344870a57deea1429eaaa3f8aaa55cc2f778062aa0e8b246fc40eb6c3caebc8f116ea7d04791d215e4781738b2b82c0c5e9d88c029703b8397acb5cf390e5da9aff13065ebc52ac8893e8f0e8fd4e6b7fcbc36ba0057aedab4918dcfbf4f92bef76623f1cccbeb4c773493a15ddedcce330c1cd6ef29475c7b5efbd4ecc5f063fbeb4d945d4eb5438e97c8b5e8238c6e29e934b9f91e77e72fb18b15e24a3eee5dc0c9ffbb11d935c5f98e1f355053dbf34bb3c324f3fbf991f41d3e0ce958f06377af8d08d5e8266463a4f991de7e1e7cfe670d017c387eec65c617265868f3e2309e7a4fa758ac50fd6eddc4f43d1e7a1f8d1ec0a920eaffbb237e87392f0f1f0a1524583be8fdaf3344cb88f0657faf6f97efc3298f3dee3b7ecaebd7bfc61e7e9f5a57a93e2eb7e1a06b5cd392695180dea09b6d8957c767c1de1e449f71e6e52bb962efb2a71f294f41ebad3617215a0c1fd3464f5f425682cf5e3a3e7be1e3ed6db68305cccce87afa4fdf5fe65c0b3a1b889c3c1cfc66db59f92ce9c17788d0675d5bdbee1e1a5cf70b55f0f3b4fafa8f3c449bb1ba1eb1b3e1c3b7e4c3a7c7c5bbdf7e960ce6f93fb57bce846b7afb5efbd76530e0757935e24bff73a37d3e1f5a490ff9edbf5e171ed7bb77d61e792779fc91544732753fb22ea5d5f7c791c5ca561325cbc0c2abcdbe1afdd4e330b3b3c1b3ed41ab7d50b3747b77dd1c097c5f8b65fe7db4ae6db8057c3ea8d0aab4f7e775c1ba36732c5899992e71f915bcff55de336f8f6a5db6eecee5fbd9f0d074f13d2797a25d777acc73768d77729c3529e5ebb391e0eeafef079b987d1a2379e4dd165f74b7b3c9bbe24cd097dac7db77574dd37e165edbbfb4250f64b7165846b3b034b0f7a4cc61d9371c764dc3119774cc61d9371c764dc3119774cc61d9371c764dc3119774cc61d9371c764dc3119774cc61d9371c764dc7f3c19f77f000000ffff010000ffffbd5a3003b92f0000`)))
@@ -2,11 +2,18 @@ package ddb
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/guregu/dynamo"
@@ -34,8 +41,26 @@ const (
 	// ingredientsTableBaseName is the base name of the ingredients table. The
 	// base name is prepended with the table prefix to get the full table name.
 	ingredientsTableBaseName = "ingredients"
+
+	// tokenIndex looks API tokens up by the token itself.
+	tokenIndex = "token-index"
+	// eatsByUserIndex lists a user's eats by when they were eaten.
+	eatsByUserIndex = "user_id-ate_at-index"
+	// poopsByUserIndex lists a user's poops by when they happened.
+	poopsByUserIndex = "user_id-pooped_at-index"
+	// byUserIndex lists a user's foods, food names or ingredients by when they
+	// were created.
+	byUserIndex = "user_id-created_at-index"
+
+	// tableTimeout is how long New waits for each table to be created and
+	// become ACTIVE.
+	tableTimeout = 2 * time.Minute
+	// indexPollInterval is how often New checks whether a GSI it added is
+	// ACTIVE yet.
+	indexPollInterval = 2 * time.Second
 )
 
+// Client is a Store backed by DynamoDB.
 type Client struct {
 	// db is the underlying DynamoDB client.
 	db *dynamo.DB
@@ -47,8 +72,8 @@ type Client struct {
 	tokens dynamo.Table
 	// eats is the DynamoDB table that holds eat records.
 	eats dynamo.Table
-	// Poops is the DynamoDB table that holds poop records.
-	Poops dynamo.Table
+	// poops is the DynamoDB table that holds poop records.
+	poops dynamo.Table
 	// foods is the DynamoDB table that holds food records.
 	foods dynamo.Table
 	// foodNames is the DynamoDB table that holds food name records.
@@ -64,9 +89,9 @@ type AppleUser struct {
 	ID string `dynamo:"id,hash"`
 	// UserID is the unique identifier for the user associated with this
 	// association.
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id"`
 	// IdentityToken is the identity token from the "Sign in with Apple" request.
-	IdentityToken string `dynamo:"identity_token,range"`
+	IdentityToken string `dynamo:"identity_token"`
 	// CreatedAt is the time this association record was created.
 	CreatedAt time.Time `dynamo:"created_at"`
 	// UpdatedAt is the time this association record was last updated.
@@ -81,7 +106,7 @@ type APIToken struct {
 	// UserID is the ID of the user this token belongs to.
 	UserID string `dynamo:"user_id"`
 	// Token is the actual token. It is an opaque string.
-	Token string `dynamo:"token,range"`
+	Token string `dynamo:"token" index:"token-index,hash"`
 	// CreatedAt is the time this token record was created.
 	CreatedAt time.Time `dynamo:"created_at"`
 	// UpdatedAt is the time this token record was last updated.
@@ -95,7 +120,7 @@ type Eat struct {
 	// ID is the unique identifier for this eat. It is an opaque string.
 	ID string `dynamo:"id,hash"`
 	// UserID is the ID of the user who ate the food.
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id" index:"user_id-ate_at-index,hash"`
 	// FoodID is the ID of the food item that was eaten.
 	FoodID string `dynamo:"food_id"`
 	// FoodText is the exact text of the food item that was eaten. This is saved
@@ -103,24 +128,37 @@ type Eat struct {
 	// introduction of new aliases or ingredient relationships.
 	FoodText string `dynamo:"food_text"`
 	// AteAt is the time at which the food was eaten.
-	AteAt time.Time `dynamo:"ate_at"`
+	AteAt time.Time `dynamo:"ate_at" index:"user_id-ate_at-index,range"`
 	// CreatedAt is the time at which the Eat record was created.
 	CreatedAt time.Time `dynamo:"created_at"`
 	// UpdatedAt is the time at which the Eat was record last updated.
 	UpdatedAt time.Time `dynamo:"updated_at"`
 }
 
+const (
+	// BadQuality is the Quality of a bad poop.
+	BadQuality = -1
+	// GoodQuality is the Quality of a good poop.
+	GoodQuality = 1
+)
+
 // Poop is a single occurrence of pooping.
 type Poop struct {
 	// ID is the unique identifier for this poop. It is an opaque string.
 	ID string `dynamo:"id,hash"`
 	// UserID is the ID of the user who pooped.
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id" index:"user_id-pooped_at-index,hash"`
 	// PoopedAt is the time at which the poop occurred.
-	PoopedAt time.Time `dynamo:"pooped_at"`
-	// Quality is the user-reported quality of the poop. This is either -1 or 1;
-	// it is an integer for forwards compatibility reasons.
+	PoopedAt time.Time `dynamo:"pooped_at" index:"user_id-pooped_at-index,range"`
+	// Quality is the user-reported quality of the poop: BadQuality or
+	// GoodQuality. It is an integer for forwards compatibility reasons. Poops
+	// logged before quality was recorded have 0.
 	Quality int `dynamo:"quality"`
+	// Bristol is the poop's type on the Bristol stool scale, from 1 to 7, or 0
+	// if the user didn't say.
+	Bristol int `dynamo:"bristol"`
+	// Notes is anything else the user had to say about the poop.
+	Notes string `dynamo:"notes"`
 	// CreatedAt is the time at which the Poop record was created.
 	CreatedAt time.Time `dynamo:"created_at"`
 	// UpdatedAt is the time at which the Poop record was last updated.
@@ -140,9 +178,9 @@ type Food struct {
 	// UserID is the ID of the user who owns this food item. Food items are not
 	// shared between users. For example, if two users each eat "yogurt", each Eat
 	// record will be associated with its own Food record called "yogurt".
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id" index:"user_id-created_at-index,hash"`
 	// CreatedAt is the time at which the Food record was created.
-	CreatedAt time.Time `dynamo:"created_at"`
+	CreatedAt time.Time `dynamo:"created_at" index:"user_id-created_at-index,range"`
 	// UpdatedAt is the time at which the Food record was last updated.
 	UpdatedAt time.Time `dynamo:"updated_at"`
 }
@@ -157,14 +195,14 @@ type FoodName struct {
 	ID string `dynamo:"id,hash"`
 	// UserID is the ID of the user who owns this food name. Food names are not
 	// shared between users.
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id" index:"user_id-created_at-index,hash"`
 	// FoodID is the ID of the food item that this name represents.
 	FoodID string `dynamo:"food_id"`
 	// Name is the name of the food item pointed to by FoodID, as represented by
 	// this record.
 	Name string `dynamo:"name"`
 	// CreatedAt is the time at which the FoodName record was created.
-	CreatedAt time.Time `dynamo:"created_at"`
+	CreatedAt time.Time `dynamo:"created_at" index:"user_id-created_at-index,range"`
 	// UpdatedAt is the time at which the FoodName record was last updated.
 	UpdatedAt time.Time `dynamo:"updated_at"`
 }
@@ -187,17 +225,27 @@ type Ingredient struct {
 	ID string `dynamo:"id,hash"`
 	// UserID is the ID of the user who owns this ingredient relationship.
 	// Ingredient relationships are not shared between users.
-	UserID string `dynamo:"user_id,range"`
+	UserID string `dynamo:"user_id" index:"user_id-created_at-index,hash"`
 	// ResultingFoodID is the ID of the food item that contains the ingredient.
 	ResultingFoodID string `dynamo:"resulting_food_id"`
 	// ComponentFoodID is the ID of the food item that is the ingredient.
 	ComponentFoodID string `dynamo:"component_food_id"`
 	// CreatedAt is the time at which the Ingredient record was created.
-	CreatedAt time.Time `dynamo:"created_at"`
+	CreatedAt time.Time `dynamo:"created_at" index:"user_id-created_at-index,range"`
 	// UpdatedAt is the time at which the Ingredient record was last updated.
 	UpdatedAt time.Time `dynamo:"updated_at"`
 }
 
+// HasCredentials reports whether AWS credentials are set in the environment or
+// the shared credentials file, without asking any remote service.
+func HasCredentials() bool {
+	_, err := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvProvider{},
+		&credentials.SharedCredentialsProvider{},
+	}).Get()
+	return err == nil
+}
+
 func New(cfg *Config) (*Client, error) {
 	if cfg == nil {
 		cfg = &Config{}
@@ -205,7 +253,7 @@ func New(cfg *Config) (*Client, error) {
 	if cfg.Region == "" {
 		cfg.Region = "us-east-1"
 	}
-	if cfg.Endpoint == "" {
+	if cfg.TableNamePrefix == "" {
 		cfg.TableNamePrefix = "pottytrainer"
 	}
 
@@ -214,13 +262,17 @@ func New(cfg *Config) (*Client, error) {
 		cfg.TableNamePrefix += "-"
 	}
 
-	sess := session.Must(session.NewSession())
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("can't create aws session: %w", err)
+	}
+	awsCfg := &aws.Config{Region: aws.String(cfg.Region)}
+	if cfg.Endpoint != "" {
+		awsCfg.Endpoint = aws.String(cfg.Endpoint)
+	}
 	var db Client
-	db.db = dynamo.New(sess, &aws.Config{
-		Region: aws.String(cfg.Region),
-	})
+	db.db = dynamo.New(sess, awsCfg)
 
-	var err error
 	if db.users, err = db.table(cfg, usersTableBaseName, User{}); err != nil {
 		return nil, err
 	}
@@ -230,7 +282,7 @@ func New(cfg *Config) (*Client, error) {
 	if db.eats, err = db.table(cfg, eatsTableBaseName, Eat{}); err != nil {
 		return nil, err
 	}
-	if db.Poops, err = db.table(cfg, poopsTableBaseName, Poop{}); err != nil {
+	if db.poops, err = db.table(cfg, poopsTableBaseName, Poop{}); err != nil {
 		return nil, err
 	}
 	if db.foods, err = db.table(cfg, foodsTableBaseName, Food{}); err != nil {
@@ -249,8 +301,319 @@ func New(cfg *Config) (*Client, error) {
 	return &db, nil
 }
 
-func (db *Client) userFromAppleSignIn(ctx context.Context, identityToken string) (*User, error) {
-	return nil, nil
+// get runs q and puts its one result in out, or returns ErrNotFound.
+func get(ctx context.Context, q *dynamo.Query, out any) error {
+	err := q.OneWithContext(ctx, out)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// queryByUser puts every record of userID in t into out, using index.
+func queryByUser(ctx context.Context, t dynamo.Table, index, userID string, out any) error {
+	if err := t.Get("user_id", userID).Index(index).AllWithContext(ctx, out); err != nil {
+		return fmt.Errorf("can't query %s: %w", t.Name(), err)
+	}
+	return nil
+}
+
+// put puts item into t.
+func put(ctx context.Context, t dynamo.Table, item any) error {
+	if err := t.Put(item).RunWithContext(ctx); err != nil {
+		return fmt.Errorf("can't put into %s: %w", t.Name(), err)
+	}
+	return nil
+}
+
+// del deletes the record with the given ID from t, if there is one.
+func del(ctx context.Context, t dynamo.Table, id string) error {
+	if err := t.Delete("id", id).RunWithContext(ctx); err != nil {
+		return fmt.Errorf("can't delete from %s: %w", t.Name(), err)
+	}
+	return nil
+}
+
+func (db *Client) PutToken(ctx context.Context, t *APIToken) error {
+	return put(ctx, db.tokens, t)
+}
+
+func (db *Client) AppleUser(ctx context.Context, id string) (*AppleUser, error) {
+	var a AppleUser
+	if err := get(ctx, db.appleUsers.Get("id", id), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (db *Client) PutAppleUser(ctx context.Context, a *AppleUser) error {
+	return put(ctx, db.appleUsers, a)
+}
+
+func (db *Client) Eat(ctx context.Context, id string) (*Eat, error) {
+	var e Eat
+	if err := get(ctx, db.eats.Get("id", id), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (db *Client) Eats(ctx context.Context, userID string) ([]Eat, error) {
+	var eats []Eat
+	if err := queryByUser(ctx, db.eats, eatsByUserIndex, userID, &eats); err != nil {
+		return nil, err
+	}
+	sortBy(eats, func(e Eat) (time.Time, string) { return e.AteAt, e.ID })
+	return eats, nil
+}
+
+// queryTimes puts the page of userID's records in t that q asks for into out,
+// newest first, using index, whose range key is timeColumn. It returns the
+// cursor of the page after.
+func queryTimes(
+	ctx context.Context,
+	t dynamo.Table,
+	index, timeColumn, userID string,
+	q TimeQuery,
+	out any,
+) (string, error) {
+	if err := q.checkLimit(); err != nil {
+		return "", err
+	}
+
+	query := t.Get("user_id", userID).Index(index).Order(dynamo.Descending)
+	switch {
+	case !q.From.IsZero() && !q.To.IsZero():
+		query.Range(timeColumn, dynamo.Between, formatTime(q.From), formatTime(q.To.Add(-time.Nanosecond)))
+	case !q.From.IsZero():
+		query.Range(timeColumn, dynamo.GreaterOrEqual, formatTime(q.From))
+	case !q.To.IsZero():
+		query.Range(timeColumn, dynamo.Less, formatTime(q.To))
+	}
+	if q.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return "", ErrBadCursor
+		}
+		var key dynamo.PagingKey
+		if err := json.Unmarshal(b, &key); err != nil {
+			return "", ErrBadCursor
+		}
+		query.StartFrom(key)
+	}
+
+	if q.Limit == 0 {
+		if err := query.AllWithContext(ctx, out); err != nil {
+			return "", fmt.Errorf("can't query %s: %w", t.Name(), err)
+		}
+		return "", nil
+	}
+	key, err := query.Limit(int64(q.Limit)).AllWithLastEvaluatedKeyContext(ctx, out)
+	if err != nil {
+		return "", fmt.Errorf("can't query %s: %w", t.Name(), err)
+	}
+	if key == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("can't encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (db *Client) QueryEats(ctx context.Context, userID string, q TimeQuery) (Page[Eat], error) {
+	var page Page[Eat]
+	next, err := queryTimes(ctx, db.eats, eatsByUserIndex, "ate_at", userID, q, &page.Items)
+	if err != nil {
+		return Page[Eat]{}, err
+	}
+	page.Next = next
+	return page, nil
+}
+
+// PutEat puts e with its times in UTC. AteAt is a range key, so it's stored in
+// timeFormat rather than dynamo's RFC 3339, whose width varies with how many
+// fractional digits a time has, so that it compares correctly as a string.
+func (db *Client) PutEat(ctx context.Context, e *Eat) error {
+	utc := *e
+	utc.CreatedAt = e.CreatedAt.UTC()
+	utc.UpdatedAt = e.UpdatedAt.UTC()
+	return putTimeKeyed(ctx, db.eats, &utc, "ate_at", e.AteAt)
+}
+
+func (db *Client) PutEats(ctx context.Context, eats []Eat) error {
+	if err := checkPutEats(eats); err != nil {
+		return err
+	}
+	if len(eats) == 0 {
+		return nil
+	}
+	tx := db.db.WriteTx()
+	for _, e := range eats {
+		e.CreatedAt = e.CreatedAt.UTC()
+		e.UpdatedAt = e.UpdatedAt.UTC()
+		av, err := timeKeyedItem(&e, "ate_at", e.AteAt)
+		if err != nil {
+			return fmt.Errorf("can't marshal eat: %w", err)
+		}
+		tx.Put(db.eats.Put(av))
+	}
+	if err := tx.RunWithContext(ctx); err != nil {
+		return fmt.Errorf("can't put into %s: %w", db.eats.Name(), err)
+	}
+	return nil
+}
+
+// putTimeKeyed puts item into t with its timeColumn set to at in timeFormat.
+func putTimeKeyed(ctx context.Context, t dynamo.Table, item any, timeColumn string, at time.Time) error {
+	av, err := timeKeyedItem(item, timeColumn, at)
+	if err != nil {
+		return fmt.Errorf("can't marshal item for %s: %w", t.Name(), err)
+	}
+	return put(ctx, t, av)
+}
+
+// timeKeyedItem marshals item with its timeColumn set to at in timeFormat.
+// Either format reads back as a time.Time.
+//
+// Records put before this was done have variable-width times, so range
+// queries may misplace them relative to records within the same second.
+func timeKeyedItem(item any, timeColumn string, at time.Time) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamo.MarshalItem(item)
+	if err != nil {
+		return nil, err
+	}
+	av[timeColumn] = &dynamodb.AttributeValue{S: aws.String(formatTime(at))}
+	return av, nil
+}
+
+func (db *Client) DeleteEat(ctx context.Context, id string) error {
+	return del(ctx, db.eats, id)
+}
+
+func (db *Client) Poop(ctx context.Context, id string) (*Poop, error) {
+	var p Poop
+	if err := get(ctx, db.poops.Get("id", id), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (db *Client) Poops(ctx context.Context, userID string) ([]Poop, error) {
+	var poops []Poop
+	if err := queryByUser(ctx, db.poops, poopsByUserIndex, userID, &poops); err != nil {
+		return nil, err
+	}
+	sortBy(poops, func(p Poop) (time.Time, string) { return p.PoopedAt, p.ID })
+	return poops, nil
+}
+
+func (db *Client) QueryPoops(ctx context.Context, userID string, q TimeQuery) (Page[Poop], error) {
+	var page Page[Poop]
+	next, err := queryTimes(ctx, db.poops, poopsByUserIndex, "pooped_at", userID, q, &page.Items)
+	if err != nil {
+		return Page[Poop]{}, err
+	}
+	page.Next = next
+	return page, nil
+}
+
+// PutPoop puts p with its times in UTC, and PoopedAt in timeFormat, like
+// PutEat.
+func (db *Client) PutPoop(ctx context.Context, p *Poop) error {
+	utc := *p
+	utc.CreatedAt = p.CreatedAt.UTC()
+	utc.UpdatedAt = p.UpdatedAt.UTC()
+	return putTimeKeyed(ctx, db.poops, &utc, "pooped_at", p.PoopedAt)
+}
+
+func (db *Client) DeletePoop(ctx context.Context, id string) error {
+	return del(ctx, db.poops, id)
+}
+
+func (db *Client) Foods(ctx context.Context, userID string) ([]Food, error) {
+	var foods []Food
+	if err := queryByUser(ctx, db.foods, byUserIndex, userID, &foods); err != nil {
+		return nil, err
+	}
+	sortBy(foods, func(f Food) (time.Time, string) { return f.CreatedAt, f.ID })
+	return foods, nil
+}
+
+func (db *Client) PutFood(ctx context.Context, f *Food) error {
+	return put(ctx, db.foods, f)
+}
+
+func (db *Client) FoodName(ctx context.Context, id string) (*FoodName, error) {
+	var n FoodName
+	if err := get(ctx, db.foodNames.Get("id", id), &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (db *Client) FoodNames(ctx context.Context, userID string) ([]FoodName, error) {
+	var names []FoodName
+	if err := queryByUser(ctx, db.foodNames, byUserIndex, userID, &names); err != nil {
+		return nil, err
+	}
+	sortBy(names, func(n FoodName) (time.Time, string) { return n.CreatedAt, n.ID })
+	return names, nil
+}
+
+func (db *Client) PutFoodName(ctx context.Context, n *FoodName) error {
+	return put(ctx, db.foodNames, n)
+}
+
+func (db *Client) DeleteFoodName(ctx context.Context, id string) error {
+	return del(ctx, db.foodNames, id)
+}
+
+func (db *Client) Ingredient(ctx context.Context, id string) (*Ingredient, error) {
+	var i Ingredient
+	if err := get(ctx, db.ingredients.Get("id", id), &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (db *Client) Ingredients(ctx context.Context, userID string) ([]Ingredient, error) {
+	var ingredients []Ingredient
+	if err := queryByUser(ctx, db.ingredients, byUserIndex, userID, &ingredients); err != nil {
+		return nil, err
+	}
+	sortBy(ingredients, func(i Ingredient) (time.Time, string) { return i.CreatedAt, i.ID })
+	return ingredients, nil
+}
+
+func (db *Client) PutIngredient(ctx context.Context, i *Ingredient) error {
+	return put(ctx, db.ingredients, i)
+}
+
+func (db *Client) DeleteIngredient(ctx context.Context, id string) error {
+	return del(ctx, db.ingredients, id)
+}
+
+func (db *Client) Dump(ctx context.Context) (*Dump, error) {
+	var d Dump
+	for _, t := range []struct {
+		table dynamo.Table
+		out   any
+	}{
+		{db.users, &d.Users},
+		{db.eats, &d.Eats},
+		{db.poops, &d.Poops},
+		{db.foods, &d.Foods},
+		{db.foodNames, &d.FoodNames},
+		{db.ingredients, &d.Ingredients},
+	} {
+		if err := t.table.Scan().AllWithContext(ctx, t.out); err != nil {
+			return nil, fmt.Errorf("can't scan %s: %w", t.table.Name(), err)
+		}
+	}
+	sortDump(&d)
+	return &d, nil
 }
 
 type Config struct {
@@ -260,27 +623,125 @@ type Config struct {
 	// useful for testing against a local DynamoDB instance.
 	Endpoint string
 	// TableNamePrefix is the prefix to use for all table names. If this does not
-	// end in "-" or "_", a hyphen will added.
+	// end in "-" or "_", a hyphen will added. It defaults to "pottytrainer".
 	TableNamePrefix string
 }
 
 // table returns the DynamoDB table for the given name and configuration. If the
 // table does not exist, it is created according to the given schema, which
-// should be
+// should be the struct the table holds, including its GSIs. If it exists but
+// is missing any of those GSIs, they're added. Either way, table waits for it
+// to be ACTIVE before returning.
 func (db *Client) table(cfg *Config, name string, schema any) (dynamo.Table, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tableTimeout)
+	defer cancel()
+
 	tableName := cfg.TableNamePrefix + name
 	t := db.db.Table(tableName)
-	_, err := t.Describe().Run()
-	if err == nil {
+	desc, err := t.Describe().RunWithContext(ctx)
+	var awsErr awserr.Error
+	switch {
+	case err == nil:
+		if !desc.Active() {
+			if err := t.WaitWithContext(ctx); err != nil {
+				return dynamo.Table{}, fmt.Errorf("can't wait for %s: %w", tableName, err)
+			}
+		}
+		if err := addIndexes(ctx, t, desc, schema); err != nil {
+			return dynamo.Table{}, err
+		}
 		return t, nil
+	case !errors.As(err, &awsErr) || awsErr.Code() != dynamodb.ErrCodeResourceNotFoundException:
+		return dynamo.Table{}, fmt.Errorf("can't describe %s: %w", tableName, err)
+	}
+
+	if err := db.db.CreateTable(tableName, schema).OnDemand(true).WaitWithContext(ctx); err != nil {
+		return dynamo.Table{}, fmt.Errorf("can't create %s: %w", tableName, err)
+	}
+	return t, nil
+}
+
+// addIndexes adds the GSIs in schema's index tags that t, described by desc,
+// doesn't have yet, one at a time since DynamoDB can only add one at once, and
+// waits for each to be ACTIVE.
+func addIndexes(ctx context.Context, t dynamo.Table, desc dynamo.Description, schema any) error {
+	have := map[string]bool{}
+	for _, gsi := range desc.GSI {
+		have[gsi.Name] = true
 	}
 
-	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() != dynamodb.ErrCodeResourceNotFoundException {
-		return dynamo.Table{}, err
+	for _, index := range schemaIndexes(schema) {
+		if have[index.Name] {
+			continue
+		}
+		if !desc.OnDemand {
+			index.Throughput = desc.Throughput
+		}
+		if _, err := t.UpdateTable().CreateIndex(index).RunWithContext(ctx); err != nil {
+			return fmt.Errorf("can't add %s to %s: %w", index.Name, t.Name(), err)
+		}
+
+		for active := false; !active; {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("can't wait for %s on %s: %w", index.Name, t.Name(), ctx.Err())
+			case <-time.After(indexPollInterval):
+			}
+			desc, err := t.Describe().RunWithContext(ctx)
+			if err != nil {
+				return fmt.Errorf("can't describe %s: %w", t.Name(), err)
+			}
+			for _, gsi := range desc.GSI {
+				active = active || gsi.Name == index.Name && gsi.Status == dynamo.ActiveStatus
+			}
+		}
 	}
-	if err := db.db.CreateTable(tableName, User{}).Run(); err != nil {
-		return dynamo.Table{}, err
+	return nil
+}
+
+// schemaIndexes returns the GSIs declared by the index tags of schema, a
+// struct, projecting every attribute like CreateTable does.
+func schemaIndexes(schema any) []dynamo.Index {
+	indexes := map[string]*dynamo.Index{}
+	var names []string
+
+	rt := reflect.TypeOf(schema)
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("index")
+		if !ok {
+			continue
+		}
+		attr, _, _ := strings.Cut(field.Tag.Get("dynamo"), ",")
+		if attr == "" {
+			attr = field.Name
+		}
+		keyType := dynamo.StringType
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			keyType = dynamo.NumberType
+		}
+
+		name, role, _ := strings.Cut(tag, ",")
+		index, ok := indexes[name]
+		if !ok {
+			index = &dynamo.Index{Name: name, ProjectionType: dynamo.AllProjection}
+			indexes[name] = index
+			names = append(names, name)
+		}
+		if role == "range" {
+			index.RangeKey, index.RangeKeyType = attr, keyType
+		} else {
+			index.HashKey, index.HashKeyType = attr, keyType
+		}
 	}
-	return db.db.Table(tableName), nil
 
+	sort.Strings(names)
+	var list []dynamo.Index
+	for _, name := range names {
+		list = append(list, *indexes[name])
+	}
+	return list
 }
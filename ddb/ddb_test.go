@@ -0,0 +1,387 @@
+package ddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guregu/dynamo"
+)
+
+// fakeDynamoDB answers just enough of the DynamoDB API for New: tables don't
+// exist until they're created, and are CREATING the first time they're
+// described after that. Indexes added to them are ACTIVE straight away.
+//
+// Items can be put by ID and queried with string key conditions, which are
+// compared byte by byte like DynamoDB does. Queries return every match at once,
+// in no particular order.
+type fakeDynamoDB struct {
+	mu      sync.Mutex
+	created map[string]map[string]any
+	checked map[string]bool
+	// added holds the names of the indexes added to each table after it was
+	// created.
+	added map[string][]string
+	// items holds each table's items by ID, as attribute values.
+	items map[string]map[string]map[string]any
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{
+		created: map[string]map[string]any{},
+		checked: map[string]bool{},
+		added:   map[string][]string{},
+		items:   map[string]map[string]map[string]any{},
+	}
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var input map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name, _ := input["TableName"].(string)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+	case "CreateTable":
+		f.created[name] = input
+		json.NewEncoder(w).Encode(map[string]any{"TableDescription": f.describe(name, "CREATING")})
+	case "DescribeTable":
+		if _, ok := f.created[name]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException",
+				"message": "Requested resource not found",
+			})
+			return
+		}
+		status := "ACTIVE"
+		if !f.checked[name] {
+			status = "CREATING"
+			f.checked[name] = true
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Table": f.describe(name, status)})
+	case "UpdateTable":
+		table := f.created[name]
+		gsis, _ := table["GlobalSecondaryIndexes"].([]any)
+		for _, update := range input["GlobalSecondaryIndexUpdates"].([]any) {
+			create := update.(map[string]any)["Create"].(map[string]any)
+			gsis = append(gsis, create)
+			f.added[name] = append(f.added[name], create["IndexName"].(string))
+		}
+		table["GlobalSecondaryIndexes"] = gsis
+		json.NewEncoder(w).Encode(map[string]any{"TableDescription": f.describe(name, "UPDATING")})
+	case "PutItem":
+		item := input["Item"].(map[string]any)
+		if f.items[name] == nil {
+			f.items[name] = map[string]map[string]any{}
+		}
+		f.items[name][attrString(item["id"])] = item
+		json.NewEncoder(w).Encode(map[string]any{})
+	case "Query":
+		items := []map[string]any{}
+		for _, item := range f.items[name] {
+			if matches(item, input["KeyConditions"].(map[string]any)) {
+				items = append(items, item)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Items": items, "Count": len(items)})
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+func (f *fakeDynamoDB) describe(name, status string) map[string]any {
+	input := f.created[name]
+	var gsis []map[string]any
+	if created, ok := input["GlobalSecondaryIndexes"].([]any); ok {
+		for _, gsi := range created {
+			gsi := gsi.(map[string]any)
+			gsis = append(gsis, map[string]any{
+				"IndexName":   gsi["IndexName"],
+				"IndexArn":    name + "/index/" + gsi["IndexName"].(string),
+				"IndexStatus": "ACTIVE",
+				"KeySchema":   gsi["KeySchema"],
+				"Projection":  gsi["Projection"],
+			})
+		}
+	}
+	return map[string]any{
+		"TableName":              name,
+		"TableStatus":            status,
+		"KeySchema":              input["KeySchema"],
+		"AttributeDefinitions":   input["AttributeDefinitions"],
+		"BillingModeSummary":     map[string]any{"BillingMode": input["BillingMode"]},
+		"GlobalSecondaryIndexes": gsis,
+	}
+}
+
+// attrString returns the value of a string attribute.
+func attrString(v any) string {
+	s, _ := v.(map[string]any)["S"].(string)
+	return s
+}
+
+// matches reports whether item meets every key condition.
+func matches(item, conditions map[string]any) bool {
+	for attr, c := range conditions {
+		c := c.(map[string]any)
+		var values []string
+		for _, v := range c["AttributeValueList"].([]any) {
+			values = append(values, attrString(v))
+		}
+		v, ok := item[attr]
+		if !ok {
+			return false
+		}
+		got := attrString(v)
+		var match bool
+		switch c["ComparisonOperator"] {
+		case "EQ":
+			match = got == values[0]
+		case "LT":
+			match = got < values[0]
+		case "GE":
+			match = got >= values[0]
+		case "BETWEEN":
+			match = got >= values[0] && got <= values[1]
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// keySchema returns a table's or index's key schema as "hash" or
+// "hash/range".
+func keySchema(v any) string {
+	var keys []string
+	for _, k := range v.([]any) {
+		keys = append(keys, k.(map[string]any)["AttributeName"].(string))
+	}
+	return strings.Join(keys, "/")
+}
+
+func TestHasCredentials(t *testing.T) {
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_PROFILE", "")
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	if HasCredentials() {
+		t.Error("found credentials with none set")
+	}
+
+	if err := os.WriteFile(
+		filepath.Join(dir, "credentials"),
+		[]byte("[default]\naws_access_key_id = test\naws_secret_access_key = test\n"),
+		0o600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if !HasCredentials() {
+		t.Error("didn't find credentials in the shared credentials file")
+	}
+
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "missing"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	if !HasCredentials() {
+		t.Error("didn't find credentials in the environment")
+	}
+}
+
+func TestNewCreatesTables(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := newFakeDynamoDB()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	if _, err := New(&Config{Endpoint: server.URL, TableNamePrefix: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for table, want := range map[string]struct {
+		key     string
+		indexes map[string]string
+	}{
+		"test-users":       {key: "id"},
+		"test-apple_users": {key: "id"},
+		"test-tokens":      {key: "id", indexes: map[string]string{tokenIndex: "token"}},
+		"test-eats": {
+			key:     "id",
+			indexes: map[string]string{eatsByUserIndex: "user_id/ate_at"},
+		},
+		"test-poops": {
+			key:     "id",
+			indexes: map[string]string{poopsByUserIndex: "user_id/pooped_at"},
+		},
+		"test-foods":       {key: "id", indexes: map[string]string{byUserIndex: "user_id/created_at"}},
+		"test-food_names":  {key: "id", indexes: map[string]string{byUserIndex: "user_id/created_at"}},
+		"test-ingredients": {key: "id", indexes: map[string]string{byUserIndex: "user_id/created_at"}},
+	} {
+		input, ok := fake.created[table]
+		if !ok {
+			t.Errorf("%s wasn't created", table)
+			continue
+		}
+		if input["BillingMode"] != "PAY_PER_REQUEST" {
+			t.Errorf("%s has billing mode %v, want on demand", table, input["BillingMode"])
+		}
+		if got := keySchema(input["KeySchema"]); got != want.key {
+			t.Errorf("%s has key %s, want %s", table, got, want.key)
+		}
+		indexes := map[string]string{}
+		if gsis, ok := input["GlobalSecondaryIndexes"].([]any); ok {
+			for _, gsi := range gsis {
+				gsi := gsi.(map[string]any)
+				indexes[gsi["IndexName"].(string)] = keySchema(gsi["KeySchema"])
+			}
+		}
+		if fmt.Sprint(indexes) != fmt.Sprint(want.indexes) {
+			t.Errorf("%s has indexes %v, want %v", table, indexes, want.indexes)
+		}
+		if !fake.checked[table] {
+			t.Errorf("%s wasn't waited on", table)
+		}
+	}
+}
+
+func TestNewAddsMissingIndexes(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := newFakeDynamoDB()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// An eats table from before it had any indexes.
+	fake.created["test-eats"] = map[string]any{
+		"KeySchema":   []any{map[string]any{"AttributeName": "id", "KeyType": "HASH"}},
+		"BillingMode": "PAY_PER_REQUEST",
+	}
+	fake.checked["test-eats"] = true
+
+	if _, err := New(&Config{Endpoint: server.URL, TableNamePrefix: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fake.added["test-eats"], []string{eatsByUserIndex}; !reflect.DeepEqual(got, want) {
+		t.Errorf("added indexes %v to test-eats, want %v", got, want)
+	}
+	for table, added := range fake.added {
+		if table != "test-eats" {
+			t.Errorf("added indexes %v to %s, which was created with them", added, table)
+		}
+	}
+}
+
+// dynamoDBStore returns a Client against the DynamoDB-compatible server at
+// DYNAMODB_ENDPOINT, like DynamoDB Local, with tables of its own that are
+// deleted afterwards. It returns nil if DYNAMODB_ENDPOINT isn't set.
+func dynamoDBStore(t *testing.T) Store {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		t.Setenv("AWS_ACCESS_KEY_ID", "test")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	}
+
+	c, err := New(&Config{
+		Endpoint:        endpoint,
+		TableNamePrefix: fmt.Sprintf("test-%d-", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, table := range []dynamo.Table{
+			c.users,
+			c.appleUsers,
+			c.tokens,
+			c.eats,
+			c.poops,
+			c.foods,
+			c.foodNames,
+			c.ingredients,
+		} {
+			if err := table.DeleteTable().Run(); err != nil {
+				t.Errorf("can't delete %s: %v", table.Name(), err)
+			}
+		}
+	})
+	return c
+}
+
+func TestQuerySubsecond(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := newFakeDynamoDB()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := New(&Config{Endpoint: server.URL, TableNamePrefix: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As RFC 3339, the first of these sorts after the others, since "Z" is
+	// after ".".
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, id := range []string{"p1", "p2", "p3"} {
+		p := Poop{ID: id, UserID: "u1", PoopedAt: at.Add(time.Duration(i) * 250 * time.Millisecond)}
+		if err := client.PutPoop(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := attrString(fake.items["test-poops"]["p1"]["pooped_at"]), "2026-01-02T03:04:05.000000000Z"; got != want {
+		t.Errorf("got pooped_at stored as %q, want %q", got, want)
+	}
+
+	for _, c := range []struct {
+		q    TimeQuery
+		want []string
+	}{
+		{TimeQuery{From: at.Add(250 * time.Millisecond)}, []string{"p2", "p3"}},
+		{TimeQuery{To: at.Add(250 * time.Millisecond)}, []string{"p1"}},
+		{TimeQuery{From: at, To: at.Add(500 * time.Millisecond)}, []string{"p1", "p2"}},
+	} {
+		page, err := client.QueryPoops(ctx, "u1", c.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, p := range page.Items {
+			ids = append(ids, p.ID)
+			if p.ID == "p1" && !p.PoopedAt.Equal(at) {
+				t.Errorf("got p1 pooped at %s, want %s", p.PoopedAt, at)
+			}
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, c.want) {
+			t.Errorf("got %v from %s to %s, want %v", ids, c.q.From, c.q.To, c.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Dump is every Potty Trainer record a user would call their data. API tokens
+// and Apple identities are left out, since they're credentials.
+type Dump struct {
+	Users       []User
+	Eats        []Eat
+	Poops       []Poop
+	Foods       []Food
+	FoodNames   []FoodName
+	Ingredients []Ingredient
+}
+
+// Load puts every record in d into s. Records that already exist are
+// overwritten, so loading the same dump twice is harmless.
+func Load(ctx context.Context, s Store, d *Dump) error {
+	for i := range d.Users {
+		if err := s.PutUser(ctx, &d.Users[i]); err != nil {
+			return fmt.Errorf("can't put user %s: %w", d.Users[i].ID, err)
+		}
+	}
+	for i := range d.Eats {
+		if err := s.PutEat(ctx, &d.Eats[i]); err != nil {
+			return fmt.Errorf("can't put eat %s: %w", d.Eats[i].ID, err)
+		}
+	}
+	for i := range d.Poops {
+		if err := s.PutPoop(ctx, &d.Poops[i]); err != nil {
+			return fmt.Errorf("can't put poop %s: %w", d.Poops[i].ID, err)
+		}
+	}
+	for i := range d.Foods {
+		if err := s.PutFood(ctx, &d.Foods[i]); err != nil {
+			return fmt.Errorf("can't put food %s: %w", d.Foods[i].ID, err)
+		}
+	}
+	for i := range d.FoodNames {
+		if err := s.PutFoodName(ctx, &d.FoodNames[i]); err != nil {
+			return fmt.Errorf("can't put food name %s: %w", d.FoodNames[i].ID, err)
+		}
+	}
+	for i := range d.Ingredients {
+		if err := s.PutIngredient(ctx, &d.Ingredients[i]); err != nil {
+			return fmt.Errorf("can't put ingredient %s: %w", d.Ingredients[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// sortDump puts every list in d oldest first, so stores that don't keep their
+// records in order still return them that way.
+func sortDump(d *Dump) {
+	sortBy(d.Users, func(u User) (time.Time, string) { return u.CreatedAt, u.ID })
+	sortBy(d.Eats, func(e Eat) (time.Time, string) { return e.AteAt, e.ID })
+	sortBy(d.Poops, func(p Poop) (time.Time, string) { return p.PoopedAt, p.ID })
+	sortBy(d.Foods, func(f Food) (time.Time, string) { return f.CreatedAt, f.ID })
+	sortBy(d.FoodNames, func(n FoodName) (time.Time, string) { return n.CreatedAt, n.ID })
+	sortBy(d.Ingredients, func(i Ingredient) (time.Time, string) { return i.CreatedAt, i.ID })
+}
+
+// sortBy sorts records by the time key returns, breaking ties by the ID.
+func sortBy[T any](records []T, key func(T) (time.Time, string)) {
+	sort.Slice(records, func(i, j int) bool {
+		ti, idi := key(records[i])
+		tj, idj := key(records[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return idi < idj
+	})
+}
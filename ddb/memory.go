@@ -0,0 +1,302 @@
+package ddb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps its records in memory, for tests. Its zero
+// value is not usable; use NewMemory.
+type Memory struct {
+	mu          sync.Mutex
+	users       map[string]User
+	tokens      map[string]APIToken
+	appleUsers  map[string]AppleUser
+	eats        map[string]Eat
+	poops       map[string]Poop
+	foods       map[string]Food
+	foodNames   map[string]FoodName
+	ingredients map[string]Ingredient
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{
+		users:       map[string]User{},
+		tokens:      map[string]APIToken{},
+		appleUsers:  map[string]AppleUser{},
+		eats:        map[string]Eat{},
+		poops:       map[string]Poop{},
+		foods:       map[string]Food{},
+		foodNames:   map[string]FoodName{},
+		ingredients: map[string]Ingredient{},
+	}
+}
+
+// ofUser returns the records in m belonging to userID, in no particular order.
+func ofUser[T any](m map[string]T, userID string, owner func(T) string) []T {
+	var records []T
+	for _, r := range m {
+		if owner(r) == userID {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// values returns the records in m, in no particular order.
+func values[T any](m map[string]T) []T {
+	var records []T
+	for _, r := range m {
+		records = append(records, r)
+	}
+	return records
+}
+
+func (m *Memory) User(_ context.Context, id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (m *Memory) UserFromToken(ctx context.Context, token string) (*User, error) {
+	m.mu.Lock()
+	var userID string
+	for _, t := range m.tokens {
+		if t.Token == token {
+			userID = t.UserID
+			break
+		}
+	}
+	m.mu.Unlock()
+	if userID == "" {
+		return nil, ErrNotFound
+	}
+	return m.User(ctx, userID)
+}
+
+func (m *Memory) PutUser(_ context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *Memory) PutToken(_ context.Context, t *APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.ID] = *t
+	return nil
+}
+
+func (m *Memory) AppleUser(_ context.Context, id string) (*AppleUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.appleUsers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (m *Memory) PutAppleUser(_ context.Context, a *AppleUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.appleUsers[a.ID] = *a
+	return nil
+}
+
+func (m *Memory) Eat(_ context.Context, id string) (*Eat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.eats[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &e, nil
+}
+
+func (m *Memory) Eats(_ context.Context, userID string) ([]Eat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	eats := ofUser(m.eats, userID, func(e Eat) string { return e.UserID })
+	sortBy(eats, func(e Eat) (time.Time, string) { return e.AteAt, e.ID })
+	return eats, nil
+}
+
+func (m *Memory) QueryEats(_ context.Context, userID string, q TimeQuery) (Page[Eat], error) {
+	if err := q.checkLimit(); err != nil {
+		return Page[Eat]{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	eats := ofUser(m.eats, userID, func(e Eat) string { return e.UserID })
+	return paginate(eats, func(e Eat) (time.Time, string) { return e.AteAt, e.ID }, q)
+}
+
+func (m *Memory) PutEat(_ context.Context, e *Eat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eats[e.ID] = *e
+	return nil
+}
+
+func (m *Memory) PutEats(_ context.Context, eats []Eat) error {
+	if err := checkPutEats(eats); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range eats {
+		m.eats[e.ID] = e
+	}
+	return nil
+}
+
+func (m *Memory) DeleteEat(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.eats, id)
+	return nil
+}
+
+func (m *Memory) Poop(_ context.Context, id string) (*Poop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.poops[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (m *Memory) Poops(_ context.Context, userID string) ([]Poop, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	poops := ofUser(m.poops, userID, func(p Poop) string { return p.UserID })
+	sortBy(poops, func(p Poop) (time.Time, string) { return p.PoopedAt, p.ID })
+	return poops, nil
+}
+
+func (m *Memory) QueryPoops(_ context.Context, userID string, q TimeQuery) (Page[Poop], error) {
+	if err := q.checkLimit(); err != nil {
+		return Page[Poop]{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	poops := ofUser(m.poops, userID, func(p Poop) string { return p.UserID })
+	return paginate(poops, func(p Poop) (time.Time, string) { return p.PoopedAt, p.ID }, q)
+}
+
+func (m *Memory) PutPoop(_ context.Context, p *Poop) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.poops[p.ID] = *p
+	return nil
+}
+
+func (m *Memory) DeletePoop(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.poops, id)
+	return nil
+}
+
+func (m *Memory) Foods(_ context.Context, userID string) ([]Food, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	foods := ofUser(m.foods, userID, func(f Food) string { return f.UserID })
+	sortBy(foods, func(f Food) (time.Time, string) { return f.CreatedAt, f.ID })
+	return foods, nil
+}
+
+func (m *Memory) PutFood(_ context.Context, f *Food) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.foods[f.ID] = *f
+	return nil
+}
+
+func (m *Memory) FoodName(_ context.Context, id string) (*FoodName, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.foodNames[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &n, nil
+}
+
+func (m *Memory) FoodNames(_ context.Context, userID string) ([]FoodName, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := ofUser(m.foodNames, userID, func(n FoodName) string { return n.UserID })
+	sortBy(names, func(n FoodName) (time.Time, string) { return n.CreatedAt, n.ID })
+	return names, nil
+}
+
+func (m *Memory) PutFoodName(_ context.Context, n *FoodName) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.foodNames[n.ID] = *n
+	return nil
+}
+
+func (m *Memory) DeleteFoodName(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.foodNames, id)
+	return nil
+}
+
+func (m *Memory) Ingredient(_ context.Context, id string) (*Ingredient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.ingredients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &i, nil
+}
+
+func (m *Memory) Ingredients(_ context.Context, userID string) ([]Ingredient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ingredients := ofUser(m.ingredients, userID, func(i Ingredient) string { return i.UserID })
+	sortBy(ingredients, func(i Ingredient) (time.Time, string) { return i.CreatedAt, i.ID })
+	return ingredients, nil
+}
+
+func (m *Memory) PutIngredient(_ context.Context, i *Ingredient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ingredients[i.ID] = *i
+	return nil
+}
+
+func (m *Memory) DeleteIngredient(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ingredients, id)
+	return nil
+}
+
+func (m *Memory) Dump(_ context.Context) (*Dump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := Dump{
+		Users:       values(m.users),
+		Eats:        values(m.eats),
+		Poops:       values(m.poops),
+		Foods:       values(m.foods),
+		FoodNames:   values(m.foodNames),
+		Ingredients: values(m.ingredients),
+	}
+	sortDump(&d)
+	return &d, nil
+}
@@ -0,0 +1,112 @@
+package ddb
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBadCursor is returned when a TimeQuery's Cursor wasn't one the store
+// returned.
+var ErrBadCursor = errors.New("invalid cursor")
+
+// TimeQuery bounds a list of a user's records by when they happened, and pages
+// through it.
+type TimeQuery struct {
+	// From and To bound the records to those that happened at or after From and
+	// before To. Either may be zero to leave that end open.
+	From, To time.Time
+	// Limit is the most records a page holds. Zero means no limit.
+	Limit int
+	// Cursor is the Next of the page before, or empty for the first page.
+	Cursor string
+}
+
+// Page is one page of a list, newest first.
+type Page[T any] struct {
+	Items []T
+	// Next is the cursor of the page after this one, or empty if this is the
+	// last.
+	Next string
+}
+
+// contains returns true if t is within q's bounds.
+func (q TimeQuery) contains(t time.Time) bool {
+	return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || t.Before(q.To))
+}
+
+// encodeCursor returns a cursor pointing after the record with the given time
+// and ID.
+func encodeCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(formatTime(t) + " " + id))
+}
+
+// decodeCursor returns the time and ID of the record a cursor from
+// encodeCursor points after.
+func decodeCursor(cursor string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrBadCursor
+	}
+	at, id, ok := strings.Cut(string(b), " ")
+	if !ok {
+		return time.Time{}, "", ErrBadCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", ErrBadCursor
+	}
+	return t, id, nil
+}
+
+// paginate returns the page of records q asks for, where key returns when each
+// record happened and its ID. records may be in any order.
+func paginate[T any](records []T, key func(T) (time.Time, string), q TimeQuery) (Page[T], error) {
+	var (
+		afterTime time.Time
+		afterID   string
+	)
+	if q.Cursor != "" {
+		var err error
+		if afterTime, afterID, err = decodeCursor(q.Cursor); err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	var page Page[T]
+	for _, r := range records {
+		t, id := key(r)
+		if !q.contains(t) {
+			continue
+		}
+		if q.Cursor != "" && (t.After(afterTime) || t.Equal(afterTime) && id >= afterID) {
+			continue
+		}
+		page.Items = append(page.Items, r)
+	}
+	sort.Slice(page.Items, func(i, j int) bool {
+		ti, idi := key(page.Items[i])
+		tj, idj := key(page.Items[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return idi > idj
+	})
+
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		page.Next = encodeCursor(key(page.Items[q.Limit-1]))
+	}
+	return page, nil
+}
+
+// checkLimit returns an error if q's limit is negative.
+func (q TimeQuery) checkLimit() error {
+	if q.Limit < 0 {
+		return fmt.Errorf("limit must not be negative, got %d", q.Limit)
+	}
+	return nil
+}
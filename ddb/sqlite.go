@@ -0,0 +1,554 @@
+package ddb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLite is a Store that keeps its records in the potty_* tables of
+// mainframe's database, so Potty Trainer can run without AWS.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite returns a Store backed by db, which must be migrated.
+func NewSQLite(db *sql.DB) *SQLite {
+	return &SQLite{db: db}
+}
+
+// timeFormat is RFC 3339 with every fractional digit kept, so times read back
+// unchanged, and always the same width, so they sort as strings.
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTime formats t for storing in a TEXT column.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTimes scans row into dest, where each *time.Time in dest is read from a
+// string written by formatTime.
+func scanTimes(row scanner, dest ...any) error {
+	raw := make([]string, len(dest))
+	args := make([]any, len(dest))
+	for i, d := range dest {
+		if _, ok := d.(*time.Time); ok {
+			args[i] = &raw[i]
+		} else {
+			args[i] = d
+		}
+	}
+	if err := row.Scan(args...); err != nil {
+		return err
+	}
+	for i, d := range dest {
+		t, ok := d.(*time.Time)
+		if !ok {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339Nano, raw[i]); err != nil {
+			return fmt.Errorf("invalid time %s: %w", raw[i], err)
+		}
+	}
+	return nil
+}
+
+// query runs q and scans every row with scan.
+func query[T any](
+	ctx context.Context,
+	db *sql.DB,
+	scan func(scanner) (T, error),
+	q string,
+	args ...any,
+) ([]T, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []T
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	err := scanTimes(row, &u.ID, &u.Email, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
+
+func scanAppleUser(row scanner) (AppleUser, error) {
+	var a AppleUser
+	err := scanTimes(row, &a.ID, &a.UserID, &a.IdentityToken, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+func scanEat(row scanner) (Eat, error) {
+	var e Eat
+	err := scanTimes(row, &e.ID, &e.UserID, &e.FoodID, &e.FoodText, &e.AteAt, &e.CreatedAt, &e.UpdatedAt)
+	return e, err
+}
+
+func scanPoop(row scanner) (Poop, error) {
+	var p Poop
+	err := scanTimes(
+		row,
+		&p.ID,
+		&p.UserID,
+		&p.PoopedAt,
+		&p.Quality,
+		&p.Bristol,
+		&p.Notes,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	return p, err
+}
+
+func scanFood(row scanner) (Food, error) {
+	var f Food
+	err := scanTimes(row, &f.ID, &f.UserID, &f.CreatedAt, &f.UpdatedAt)
+	return f, err
+}
+
+func scanFoodName(row scanner) (FoodName, error) {
+	var n FoodName
+	err := scanTimes(row, &n.ID, &n.UserID, &n.FoodID, &n.Name, &n.CreatedAt, &n.UpdatedAt)
+	return n, err
+}
+
+func scanIngredient(row scanner) (Ingredient, error) {
+	var i Ingredient
+	err := scanTimes(
+		row,
+		&i.ID,
+		&i.UserID,
+		&i.ResultingFoodID,
+		&i.ComponentFoodID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const (
+	userColumns       = `id, email, name, created_at, updated_at`
+	appleUserColumns  = `id, user_id, identity_token, created_at, updated_at`
+	eatColumns        = `id, user_id, food_id, food_text, ate_at, created_at, updated_at`
+	poopColumns       = `id, user_id, pooped_at, quality, bristol, notes, created_at, updated_at`
+	foodColumns       = `id, user_id, created_at, updated_at`
+	foodNameColumns   = `id, user_id, food_id, name, created_at, updated_at`
+	ingredientColumns = `id, user_id, resulting_food_id, component_food_id, created_at, updated_at`
+)
+
+// one scans the record in row, or returns ErrNotFound if there isn't one.
+func one[T any](row *sql.Row, scan func(scanner) (T, error)) (*T, error) {
+	r, err := scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *SQLite) User(ctx context.Context, id string) (*User, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+userColumns+` FROM potty_users WHERE id = $1`,
+		id,
+	), scanUser)
+}
+
+func (s *SQLite) UserFromToken(ctx context.Context, token string) (*User, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`
+		SELECT potty_users.id, email, name, potty_users.created_at, potty_users.updated_at
+		FROM potty_users
+		JOIN potty_tokens ON potty_tokens.user_id = potty_users.id
+		WHERE potty_tokens.token = $1
+		`,
+		token,
+	), scanUser)
+}
+
+func (s *SQLite) PutUser(ctx context.Context, u *User) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		u.ID,
+		u.Email,
+		u.Name,
+		formatTime(u.CreatedAt),
+		formatTime(u.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) PutToken(ctx context.Context, t *APIToken) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+		INSERT OR REPLACE INTO potty_tokens (id, user_id, token, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		`,
+		t.ID,
+		t.UserID,
+		t.Token,
+		formatTime(t.CreatedAt),
+		formatTime(t.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) AppleUser(ctx context.Context, id string) (*AppleUser, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+appleUserColumns+` FROM potty_apple_users WHERE id = $1`,
+		id,
+	), scanAppleUser)
+}
+
+func (s *SQLite) PutAppleUser(ctx context.Context, a *AppleUser) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_apple_users (`+appleUserColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		a.ID,
+		a.UserID,
+		a.IdentityToken,
+		formatTime(a.CreatedAt),
+		formatTime(a.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) Eat(ctx context.Context, id string) (*Eat, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+eatColumns+` FROM potty_eats WHERE id = $1`,
+		id,
+	), scanEat)
+}
+
+func (s *SQLite) Eats(ctx context.Context, userID string) ([]Eat, error) {
+	return query(
+		ctx,
+		s.db,
+		scanEat,
+		`SELECT `+eatColumns+` FROM potty_eats WHERE user_id = $1 ORDER BY ate_at, id`,
+		userID,
+	)
+}
+
+// queryPage returns the page of userID's rows in table that q asks for, where
+// timeColumn holds when each happened and key returns that and its ID.
+func queryPage[T any](
+	ctx context.Context,
+	db *sql.DB,
+	table, columns, timeColumn, userID string,
+	q TimeQuery,
+	scan func(scanner) (T, error),
+	key func(T) (time.Time, string),
+) (Page[T], error) {
+	if err := q.checkLimit(); err != nil {
+		return Page[T]{}, err
+	}
+
+	var args []any
+	// arg adds v to args and returns its placeholder.
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{`user_id = ` + arg(userID)}
+	if !q.From.IsZero() {
+		where = append(where, timeColumn+` >= `+arg(formatTime(q.From)))
+	}
+	if !q.To.IsZero() {
+		where = append(where, timeColumn+` < `+arg(formatTime(q.To)))
+	}
+	if q.Cursor != "" {
+		t, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		where = append(where, fmt.Sprintf(`(%[1]s < %[2]s OR %[1]s = %[2]s AND id < %[3]s)`, timeColumn, arg(formatTime(t)), arg(id)))
+	}
+	limit := -1
+	if q.Limit > 0 {
+		// One more than asked for, to know if there's a page after.
+		limit = q.Limit + 1
+	}
+
+	records, err := query(ctx, db, scan, fmt.Sprintf(
+		`SELECT %s FROM %s WHERE %s ORDER BY %s DESC, id DESC LIMIT %s`,
+		columns,
+		table,
+		strings.Join(where, " AND "),
+		timeColumn,
+		arg(limit),
+	), args...)
+	if err != nil {
+		return Page[T]{}, fmt.Errorf("can't query %s: %w", table, err)
+	}
+
+	page := Page[T]{Items: records}
+	if q.Limit > 0 && len(records) > q.Limit {
+		page.Items = records[:q.Limit]
+		page.Next = encodeCursor(key(page.Items[q.Limit-1]))
+	}
+	return page, nil
+}
+
+func (s *SQLite) QueryEats(ctx context.Context, userID string, q TimeQuery) (Page[Eat], error) {
+	return queryPage(
+		ctx,
+		s.db,
+		"potty_eats",
+		eatColumns,
+		"ate_at",
+		userID,
+		q,
+		scanEat,
+		func(e Eat) (time.Time, string) { return e.AteAt, e.ID },
+	)
+}
+
+func (s *SQLite) PutEat(ctx context.Context, e *Eat) error {
+	return putEat(ctx, s.db, e)
+}
+
+func (s *SQLite) PutEats(ctx context.Context, eats []Eat) error {
+	if err := checkPutEats(eats); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for i := range eats {
+		if err := putEat(ctx, tx, &eats[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func putEat(ctx context.Context, db execer, e *Eat) error {
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_eats (`+eatColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.ID,
+		e.UserID,
+		e.FoodID,
+		e.FoodText,
+		formatTime(e.AteAt),
+		formatTime(e.CreatedAt),
+		formatTime(e.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) DeleteEat(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM potty_eats WHERE id = $1`, id)
+	return err
+}
+
+func (s *SQLite) Poop(ctx context.Context, id string) (*Poop, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+poopColumns+` FROM potty_poops WHERE id = $1`,
+		id,
+	), scanPoop)
+}
+
+func (s *SQLite) Poops(ctx context.Context, userID string) ([]Poop, error) {
+	return query(
+		ctx,
+		s.db,
+		scanPoop,
+		`SELECT `+poopColumns+` FROM potty_poops WHERE user_id = $1 ORDER BY pooped_at, id`,
+		userID,
+	)
+}
+
+func (s *SQLite) QueryPoops(ctx context.Context, userID string, q TimeQuery) (Page[Poop], error) {
+	return queryPage(
+		ctx,
+		s.db,
+		"potty_poops",
+		poopColumns,
+		"pooped_at",
+		userID,
+		q,
+		scanPoop,
+		func(p Poop) (time.Time, string) { return p.PoopedAt, p.ID },
+	)
+}
+
+func (s *SQLite) PutPoop(ctx context.Context, p *Poop) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_poops (`+poopColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		p.ID,
+		p.UserID,
+		formatTime(p.PoopedAt),
+		p.Quality,
+		p.Bristol,
+		p.Notes,
+		formatTime(p.CreatedAt),
+		formatTime(p.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) DeletePoop(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM potty_poops WHERE id = $1`, id)
+	return err
+}
+
+func (s *SQLite) Foods(ctx context.Context, userID string) ([]Food, error) {
+	return query(
+		ctx,
+		s.db,
+		scanFood,
+		`SELECT `+foodColumns+` FROM potty_foods WHERE user_id = $1 ORDER BY created_at, id`,
+		userID,
+	)
+}
+
+func (s *SQLite) PutFood(ctx context.Context, f *Food) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_foods (`+foodColumns+`) VALUES ($1, $2, $3, $4)`,
+		f.ID,
+		f.UserID,
+		formatTime(f.CreatedAt),
+		formatTime(f.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) FoodName(ctx context.Context, id string) (*FoodName, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+foodNameColumns+` FROM potty_food_names WHERE id = $1`,
+		id,
+	), scanFoodName)
+}
+
+func (s *SQLite) FoodNames(ctx context.Context, userID string) ([]FoodName, error) {
+	return query(
+		ctx,
+		s.db,
+		scanFoodName,
+		`SELECT `+foodNameColumns+` FROM potty_food_names WHERE user_id = $1 ORDER BY created_at, id`,
+		userID,
+	)
+}
+
+func (s *SQLite) PutFoodName(ctx context.Context, n *FoodName) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_food_names (`+foodNameColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		n.ID,
+		n.UserID,
+		n.FoodID,
+		n.Name,
+		formatTime(n.CreatedAt),
+		formatTime(n.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) DeleteFoodName(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM potty_food_names WHERE id = $1`, id)
+	return err
+}
+
+func (s *SQLite) Ingredient(ctx context.Context, id string) (*Ingredient, error) {
+	return one(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+ingredientColumns+` FROM potty_ingredients WHERE id = $1`,
+		id,
+	), scanIngredient)
+}
+
+func (s *SQLite) Ingredients(ctx context.Context, userID string) ([]Ingredient, error) {
+	return query(
+		ctx,
+		s.db,
+		scanIngredient,
+		`SELECT `+ingredientColumns+` FROM potty_ingredients WHERE user_id = $1 ORDER BY created_at, id`,
+		userID,
+	)
+}
+
+func (s *SQLite) PutIngredient(ctx context.Context, i *Ingredient) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT OR REPLACE INTO potty_ingredients (`+ingredientColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		i.ID,
+		i.UserID,
+		i.ResultingFoodID,
+		i.ComponentFoodID,
+		formatTime(i.CreatedAt),
+		formatTime(i.UpdatedAt),
+	)
+	return err
+}
+
+func (s *SQLite) DeleteIngredient(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM potty_ingredients WHERE id = $1`, id)
+	return err
+}
+
+func (s *SQLite) Dump(ctx context.Context) (*Dump, error) {
+	var (
+		d   Dump
+		err error
+	)
+	if d.Users, err = query(ctx, s.db, scanUser, `SELECT `+userColumns+` FROM potty_users`); err != nil {
+		return nil, fmt.Errorf("can't query potty_users: %w", err)
+	}
+	if d.Eats, err = query(ctx, s.db, scanEat, `SELECT `+eatColumns+` FROM potty_eats`); err != nil {
+		return nil, fmt.Errorf("can't query potty_eats: %w", err)
+	}
+	if d.Poops, err = query(ctx, s.db, scanPoop, `SELECT `+poopColumns+` FROM potty_poops`); err != nil {
+		return nil, fmt.Errorf("can't query potty_poops: %w", err)
+	}
+	if d.Foods, err = query(ctx, s.db, scanFood, `SELECT `+foodColumns+` FROM potty_foods`); err != nil {
+		return nil, fmt.Errorf("can't query potty_foods: %w", err)
+	}
+	if d.FoodNames, err = query(ctx, s.db, scanFoodName, `SELECT `+foodNameColumns+` FROM potty_food_names`); err != nil {
+		return nil, fmt.Errorf("can't query potty_food_names: %w", err)
+	}
+	if d.Ingredients, err = query(ctx, s.db, scanIngredient, `SELECT `+ingredientColumns+` FROM potty_ingredients`); err != nil {
+		return nil, fmt.Errorf("can't query potty_ingredients: %w", err)
+	}
+	sortDump(&d)
+	return &d, nil
+}
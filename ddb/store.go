@@ -0,0 +1,90 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when a record being looked up doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// MaxPutEats is the most eats PutEats can put at once, which is the most
+// writes a DynamoDB transaction can hold.
+const MaxPutEats = 100
+
+// checkPutEats returns an error if eats are too many for PutEats.
+func checkPutEats(eats []Eat) error {
+	if len(eats) > MaxPutEats {
+		return fmt.Errorf("can't put %d eats at once; the most is %d", len(eats), MaxPutEats)
+	}
+	return nil
+}
+
+// Store keeps Potty Trainer's records. Client keeps them in DynamoDB, SQLite in
+// mainframe's database, and Memory nowhere at all.
+//
+// Putting a record with the ID of an existing one replaces it. Lists are of
+// one user's records, oldest first.
+type Store interface {
+	// User returns the user with the given ID.
+	User(ctx context.Context, id string) (*User, error)
+	// UserFromToken returns the user an API token belongs to.
+	UserFromToken(ctx context.Context, token string) (*User, error)
+	PutUser(ctx context.Context, u *User) error
+
+	PutToken(ctx context.Context, t *APIToken) error
+
+	// AppleUser returns the association with the given "Sign in with Apple"
+	// identity.
+	AppleUser(ctx context.Context, id string) (*AppleUser, error)
+	PutAppleUser(ctx context.Context, a *AppleUser) error
+
+	// Eat returns the eat with the given ID.
+	Eat(ctx context.Context, id string) (*Eat, error)
+	Eats(ctx context.Context, userID string) ([]Eat, error)
+	// QueryEats returns a page of userID's eats within q.
+	QueryEats(ctx context.Context, userID string, q TimeQuery) (Page[Eat], error)
+	PutEat(ctx context.Context, e *Eat) error
+	// PutEats puts every one of eats, or none of them if it fails. It takes
+	// at most MaxPutEats.
+	PutEats(ctx context.Context, eats []Eat) error
+	// DeleteEat deletes the eat with the given ID, if there is one.
+	DeleteEat(ctx context.Context, id string) error
+
+	// Poop returns the poop with the given ID.
+	Poop(ctx context.Context, id string) (*Poop, error)
+	Poops(ctx context.Context, userID string) ([]Poop, error)
+	// QueryPoops returns a page of userID's poops within q.
+	QueryPoops(ctx context.Context, userID string, q TimeQuery) (Page[Poop], error)
+	PutPoop(ctx context.Context, p *Poop) error
+	// DeletePoop deletes the poop with the given ID, if there is one.
+	DeletePoop(ctx context.Context, id string) error
+
+	Foods(ctx context.Context, userID string) ([]Food, error)
+	PutFood(ctx context.Context, f *Food) error
+
+	// FoodName returns the food name with the given ID.
+	FoodName(ctx context.Context, id string) (*FoodName, error)
+	FoodNames(ctx context.Context, userID string) ([]FoodName, error)
+	PutFoodName(ctx context.Context, n *FoodName) error
+	// DeleteFoodName deletes the food name with the given ID, if there is one.
+	DeleteFoodName(ctx context.Context, id string) error
+
+	// Ingredient returns the ingredient relationship with the given ID.
+	Ingredient(ctx context.Context, id string) (*Ingredient, error)
+	Ingredients(ctx context.Context, userID string) ([]Ingredient, error)
+	PutIngredient(ctx context.Context, i *Ingredient) error
+	// DeleteIngredient deletes the ingredient relationship with the given ID,
+	// if there is one.
+	DeleteIngredient(ctx context.Context, id string) error
+
+	// Dump returns every record a Dump holds, across all users.
+	Dump(ctx context.Context) (*Dump, error)
+}
+
+var (
+	_ Store = (*Client)(nil)
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Memory)(nil)
+)
@@ -0,0 +1,283 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+)
+
+// stores returns a fresh instance of every Store that runs without a network,
+// and of Client if DYNAMODB_ENDPOINT is set.
+func stores(t *testing.T) map[string]Store {
+	conn, err := db.New(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stores := map[string]Store{
+		"memory": NewMemory(),
+		"sqlite": NewSQLite(conn),
+	}
+	if c := dynamoDBStore(t); c != nil {
+		stores["dynamodb"] = c
+	}
+	return stores
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := User{ID: "u1", Email: "a@example.com", Name: "A", CreatedAt: at, UpdatedAt: at}
+			if err := s.PutUser(ctx, &user); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PutToken(ctx, &APIToken{ID: "t1", UserID: "u1", Token: "secret", CreatedAt: at, UpdatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.UserFromToken(ctx, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.CreatedAt.Equal(at) || got.Email != user.Email {
+				t.Errorf("got user %+v from token, want %+v", got, user)
+			}
+			if _, err := s.UserFromToken(ctx, "wrong"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for an unknown token, want ErrNotFound", err)
+			}
+			if _, err := s.User(ctx, "u2"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for an unknown user, want ErrNotFound", err)
+			}
+
+			apple := AppleUser{ID: "a1", UserID: "u1", IdentityToken: "jwt", CreatedAt: at, UpdatedAt: at}
+			if err := s.PutAppleUser(ctx, &apple); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.AppleUser(ctx, "a1"); err != nil || got.UserID != "u1" {
+				t.Errorf("got apple user %+v, %v, want %+v", got, err, apple)
+			}
+
+			// Put out of order, and once twice, to check lists are sorted and
+			// putting replaces.
+			for _, e := range []Eat{
+				{ID: "e2", UserID: "u1", FoodText: "eggs", AteAt: at.Add(time.Hour)},
+				{ID: "e1", UserID: "u1", FoodText: "coffee", AteAt: at},
+				{ID: "e1", UserID: "u1", FoodText: "coffee cake", AteAt: at},
+				{ID: "e3", UserID: "u2", FoodText: "toast", AteAt: at},
+			} {
+				if err := s.PutEat(ctx, &e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			eats, err := s.Eats(ctx, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			for _, e := range eats {
+				texts = append(texts, e.FoodText)
+			}
+			if want := []string{"coffee cake", "eggs"}; !reflect.DeepEqual(texts, want) {
+				t.Errorf("got eats %v, want %v", texts, want)
+			}
+			if e, err := s.Eat(ctx, "e2"); err != nil || e.FoodText != "eggs" {
+				t.Errorf("got eat %+v, %v, want eggs", e, err)
+			}
+			if err := s.PutEat(ctx, &Eat{ID: "e4", UserID: "u1", AteAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DeleteEat(ctx, "e4"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Eat(ctx, "e4"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for a deleted eat, want ErrNotFound", err)
+			}
+
+			batch := []Eat{
+				{ID: "e5", UserID: "u3", FoodText: "rice", AteAt: at},
+				{ID: "e6", UserID: "u3", FoodText: "beans", AteAt: at},
+			}
+			if err := s.PutEats(ctx, batch); err != nil {
+				t.Fatal(err)
+			}
+			if eats, err := s.Eats(ctx, "u3"); err != nil || len(eats) != 2 {
+				t.Errorf("got eats %+v, %v after putting two at once, want both", eats, err)
+			}
+			tooMany := make([]Eat, MaxPutEats+1)
+			for i := range tooMany {
+				tooMany[i] = Eat{ID: fmt.Sprintf("many%d", i), UserID: "u4", AteAt: at}
+			}
+			if err := s.PutEats(ctx, tooMany); err == nil {
+				t.Errorf("put %d eats at once without error", len(tooMany))
+			}
+			if eats, err := s.Eats(ctx, "u4"); err != nil || len(eats) != 0 {
+				t.Errorf("got eats %+v, %v after failing to put too many, want none", eats, err)
+			}
+			for _, e := range batch {
+				if err := s.DeleteEat(ctx, e.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			poop := Poop{ID: "p1", UserID: "u1", PoopedAt: at, Quality: BadQuality, Bristol: 6, Notes: "ow"}
+			if err := s.PutPoop(ctx, &poop); err != nil {
+				t.Fatal(err)
+			}
+			if poops, err := s.Poops(ctx, "u1"); err != nil || len(poops) != 1 || !reflect.DeepEqual(poops[0], poop) {
+				t.Errorf("got poops %+v, %v, want %+v", poops, err, poop)
+			}
+			if err := s.PutPoop(ctx, &Poop{ID: "p2", UserID: "u1", PoopedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DeletePoop(ctx, "p2"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Poop(ctx, "p2"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for a deleted poop, want ErrNotFound", err)
+			}
+
+			if err := s.PutFood(ctx, &Food{ID: "f1", UserID: "u1", CreatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PutFoodName(ctx, &FoodName{ID: "n1", UserID: "u1", FoodID: "f1", Name: "yogurt", CreatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PutIngredient(ctx, &Ingredient{ID: "i1", UserID: "u1", ResultingFoodID: "f2", ComponentFoodID: "f1", CreatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if foods, err := s.Foods(ctx, "u1"); err != nil || len(foods) != 1 {
+				t.Errorf("got foods %+v, %v, want one", foods, err)
+			}
+			if names, err := s.FoodNames(ctx, "u1"); err != nil || len(names) != 1 || names[0].Name != "yogurt" {
+				t.Errorf("got food names %+v, %v, want yogurt", names, err)
+			}
+			if ingredients, err := s.Ingredients(ctx, "u1"); err != nil || len(ingredients) != 1 {
+				t.Errorf("got ingredients %+v, %v, want one", ingredients, err)
+			}
+			if n, err := s.FoodName(ctx, "n1"); err != nil || n.Name != "yogurt" {
+				t.Errorf("got food name %+v, %v, want yogurt", n, err)
+			}
+			if i, err := s.Ingredient(ctx, "i1"); err != nil || i.ComponentFoodID != "f1" {
+				t.Errorf("got ingredient %+v, %v, want one of f1", i, err)
+			}
+			if err := s.PutFoodName(ctx, &FoodName{ID: "n2", UserID: "u1", FoodID: "f1", Name: "yoghurt", CreatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PutIngredient(ctx, &Ingredient{ID: "i2", UserID: "u1", ResultingFoodID: "f1", ComponentFoodID: "f3", CreatedAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DeleteFoodName(ctx, "n2"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DeleteIngredient(ctx, "i2"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.FoodName(ctx, "n2"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for a deleted food name, want ErrNotFound", err)
+			}
+			if _, err := s.Ingredient(ctx, "i2"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v for a deleted ingredient, want ErrNotFound", err)
+			}
+
+			d, err := s.Dump(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(d.Users) != 1 || len(d.Eats) != 3 || len(d.Poops) != 1 {
+				t.Errorf("got dump of %d users, %d eats and %d poops, want 1, 3 and 1", len(d.Users), len(d.Eats), len(d.Poops))
+			}
+
+			loaded := NewMemory()
+			if err := Load(ctx, loaded, d); err != nil {
+				t.Fatal(err)
+			}
+			reloaded, err := loaded.Dump(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, reloaded) {
+				t.Errorf("got %+v after loading a dump, want %+v", reloaded, d)
+			}
+		})
+	}
+}
+
+func TestStoresQuery(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, id := range []string{"p1", "p2", "p3", "p4", "p5"} {
+				p := Poop{ID: id, UserID: "u1", PoopedAt: at.Add(time.Duration(i) * time.Hour), Quality: 1}
+				if err := s.PutPoop(ctx, &p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.PutPoop(ctx, &Poop{ID: "p6", UserID: "u2", PoopedAt: at.Add(time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+
+			// p2 through p4, two at a time.
+			q := TimeQuery{From: at.Add(time.Hour), To: at.Add(4 * time.Hour), Limit: 2}
+			var ids []string
+			for pages := 0; ; pages++ {
+				if pages == 3 {
+					t.Fatalf("got more than 3 pages")
+				}
+				page, err := s.QueryPoops(ctx, "u1", q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page.Items) > 2 {
+					t.Errorf("got %d poops in a page, want at most 2", len(page.Items))
+				}
+				for _, p := range page.Items {
+					ids = append(ids, p.ID)
+				}
+				if page.Next == "" {
+					break
+				}
+				q.Cursor = page.Next
+			}
+			if want := []string{"p4", "p3", "p2"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("got poops %v, want %v", ids, want)
+			}
+
+			page, err := s.QueryPoops(ctx, "u1", TimeQuery{To: at.Add(time.Hour)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != 1 || page.Items[0].ID != "p1" || page.Next != "" {
+				t.Errorf("got %+v before p2, want just p1", page)
+			}
+
+			if err := s.PutEat(ctx, &Eat{ID: "e1", UserID: "u1", AteAt: at}); err != nil {
+				t.Fatal(err)
+			}
+			eats, err := s.QueryEats(ctx, "u1", TimeQuery{From: at})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(eats.Items) != 1 || eats.Items[0].ID != "e1" {
+				t.Errorf("got eats %+v from e1 on, want just e1", eats)
+			}
+
+			if _, err := s.QueryEats(ctx, "u1", TimeQuery{Cursor: "!"}); !errors.Is(err, ErrBadCursor) {
+				t.Errorf("got error %v for a bad cursor, want ErrBadCursor", err)
+			}
+		})
+	}
+}
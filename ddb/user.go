@@ -25,14 +25,22 @@ type User struct {
 	UpdatedAt time.Time `dynamo:"updated_at"`
 }
 
-func (db *Client) UserFromToken(ctx context.Context, token string) (*User, error) {
-	var apiToken APIToken
-	if err := db.tokens.Get("user_id", token).OneWithContext(ctx, &apiToken); err != nil {
-		return nil, err
-	}
+func (db *Client) User(ctx context.Context, id string) (*User, error) {
 	var user User
-	if err := db.users.Get("id", apiToken.UserID).OneWithContext(ctx, &user); err != nil {
+	if err := get(ctx, db.users.Get("id", id), &user); err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+func (db *Client) UserFromToken(ctx context.Context, token string) (*User, error) {
+	var apiToken APIToken
+	if err := get(ctx, db.tokens.Get("token", token).Index(tokenIndex), &apiToken); err != nil {
+		return nil, err
+	}
+	return db.User(ctx, apiToken.UserID)
+}
+
+func (db *Client) PutUser(ctx context.Context, u *User) error {
+	return put(ctx, db.users, u)
+}
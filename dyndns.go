@@ -90,7 +90,7 @@ func runDynDNS(
 	}
 	defer func() {
 		if err := req.Body.Close(); err != nil {
-			logger.Printf("can't close request body for getting current IP: %w", err)
+			logger.Printf("can't close request body for getting current IP: %v", err)
 		}
 	}()
 
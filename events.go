@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// calendarEvent is one occurrence of an event in the calendar_events table.
+// Recurring events are stored already expanded, one row per occurrence.
+type calendarEvent struct {
+	// Source is where the event came from, like "ics:school".
+	Source string
+	// UID identifies the event within its source. All occurrences of a
+	// recurring event share a UID.
+	UID string
+	// RecurrenceID tells occurrences of a recurring event apart. It is empty
+	// for events that don't repeat.
+	RecurrenceID string
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	// Busy is false for events that don't make us unavailable, like
+	// reminders or holidays marked as free.
+	Busy bool
+}
+
+// replaceCalendarEvents atomically replaces every stored event from source with
+// events.
+func replaceCalendarEvents(ctx context.Context, db *sql.DB, source string, events []calendarEvent) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`
+		DELETE FROM calendar_events
+		WHERE source = $1
+		`,
+		source,
+	); err != nil {
+		return fmt.Errorf("can't clear events from %s: %w", source, err)
+	}
+
+	for _, e := range events {
+		if _, err := tx.ExecContext(
+			ctx,
+			`
+			INSERT OR REPLACE INTO calendar_events (
+				source,
+				uid,
+				recurrence_id,
+				summary,
+				description,
+				location,
+				starts_at,
+				ends_at,
+				all_day,
+				busy
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+			)
+			`,
+			source,
+			e.UID,
+			e.RecurrenceID,
+			e.Summary,
+			e.Description,
+			e.Location,
+			e.Start.UTC().Format(time.RFC3339),
+			e.End.UTC().Format(time.RFC3339),
+			e.AllDay,
+			e.Busy,
+		); err != nil {
+			return fmt.Errorf("can't insert event %s from %s: %w", e.UID, source, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneCalendarEvents deletes the stored events of every source starting with
+// prefix, except those in keep. It cleans up after sources that are no longer
+// configured.
+func pruneCalendarEvents(ctx context.Context, db *sql.DB, prefix string, keep []string) error {
+	rows, err := db.QueryContext(
+		ctx,
+		`
+		SELECT DISTINCT source
+		FROM calendar_events
+		WHERE SUBSTR(source, 1, LENGTH($1)) = $1
+		`,
+		prefix,
+	)
+	if err != nil {
+		return fmt.Errorf("can't query sources: %w", err)
+	}
+	kept := map[string]struct{}{}
+	for _, source := range keep {
+		kept[source] = struct{}{}
+	}
+	var stale []string
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			rows.Close()
+			return fmt.Errorf("can't scan source: %w", err)
+		}
+		if _, ok := kept[source]; !ok {
+			stale = append(stale, source)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("can't close source rows: %w", err)
+	}
+
+	for _, source := range stale {
+		if err := replaceCalendarEvents(ctx, db, source, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calendarEventsBetween returns every stored event that overlaps [from, to),
+// sorted by start time. If any sources are given, only events from those
+// sources are returned.
+func calendarEventsBetween(
+	ctx context.Context,
+	db *sql.DB,
+	from, to time.Time,
+	sources ...string,
+) ([]calendarEvent, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`
+		SELECT
+			source,
+			uid,
+			recurrence_id,
+			summary,
+			description,
+			location,
+			starts_at,
+			ends_at,
+			all_day,
+			busy
+		FROM calendar_events
+		WHERE starts_at < $1
+		AND (ends_at > $2 OR (ends_at = starts_at AND starts_at >= $2))
+		ORDER BY starts_at ASC, ends_at ASC
+		`,
+		to.UTC().Format(time.RFC3339),
+		from.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query events: %w", err)
+	}
+	defer rows.Close()
+
+	wanted := map[string]struct{}{}
+	for _, s := range sources {
+		wanted[s] = struct{}{}
+	}
+
+	var events []calendarEvent
+	for rows.Next() {
+		var (
+			e            calendarEvent
+			start, end   string
+			allDay, busy bool
+		)
+		if err := rows.Scan(
+			&e.Source,
+			&e.UID,
+			&e.RecurrenceID,
+			&e.Summary,
+			&e.Description,
+			&e.Location,
+			&start,
+			&end,
+			&allDay,
+			&busy,
+		); err != nil {
+			return nil, fmt.Errorf("can't scan event: %w", err)
+		}
+		if _, ok := wanted[e.Source]; len(wanted) > 0 && !ok {
+			continue
+		}
+		if e.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return nil, fmt.Errorf("invalid starts_at `%s`: %w", start, err)
+		}
+		if e.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return nil, fmt.Errorf("invalid ends_at `%s`: %w", end, err)
+		}
+		e.Start = e.Start.In(time.Local)
+		e.End = e.End.In(time.Local)
+		e.AllDay = allDay
+		e.Busy = busy
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
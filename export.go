@@ -0,0 +1,603 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"twos.dev/mainframe/auth"
+	"twos.dev/mainframe/coldbrewcrew/iworkout"
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/ddb"
+)
+
+const (
+	// scopeExport lets API tokens download an export of their owner's data.
+	scopeExport = "export"
+	// manifestName is the file in an export describing the rest.
+	manifestName = "manifest.json"
+	// pottytrainerDir holds Potty Trainer's records in an export.
+	pottytrainerDir = "pottytrainer"
+	// iworkoutName holds iworkout stats in an export. They're rebuilt from
+	// Discord, so they aren't imported.
+	iworkoutName = "iworkout.json"
+)
+
+// exportTable is a table written to an export as CSV, one row per line.
+type exportTable struct {
+	name string
+	// columns are the columns exported. Any holding a credential is left out,
+	// so a new column isn't exported until it's listed here.
+	columns []string
+	// nullable are the columns whose NULLs are written as empty strings, and
+	// whose empty strings are imported as NULLs.
+	nullable []string
+	// importDefaults are values for columns left out of the export, like
+	// credentials, that must be set to import a row.
+	importDefaults map[string]string
+	// afterImport, if set, is run once the table's rows are imported.
+	afterImport string
+	// owned, if set, is a condition picking the rows that belong to the user
+	// whose ID is $1. Tables without one belong to the instance as a whole,
+	// and are left empty in a single user's export.
+	owned string
+}
+
+// exportTables are every table holding data someone would call theirs, in an
+// order that imports parents before their children. Sessions, API tokens and
+// anything that's only a cache are left out.
+var exportTables = []exportTable{
+	{
+		name:    "users",
+		columns: []string{"id", "created_at", "updated_at"},
+		owned:   `id = $1`,
+	},
+	{
+		name: "google_users",
+		columns: []string{
+			"id",
+			"user_id",
+			"external_id",
+			"email",
+			"verified_email",
+			"family_name",
+			"given_name",
+			"name",
+			"picture",
+			"gender",
+			"hosted_domain",
+			"link",
+			"locale",
+			"created_at",
+			"updated_at",
+		},
+		nullable: []string{"user_id"},
+		owned:    `user_id = $1`,
+	},
+	{
+		name: "google_links",
+		columns: []string{
+			"id",
+			"google_user_id",
+			"token_type",
+			"expires_at",
+			"dead_at",
+			"created_at",
+			"updated_at",
+		},
+		nullable:       []string{"google_user_id", "dead_at"},
+		importDefaults: map[string]string{"access_token": "", "refresh_token": ""},
+		// Without its tokens a link is dead until it's linked again.
+		afterImport: `UPDATE google_links SET dead_at = CURRENT_TIMESTAMP WHERE dead_at IS NULL`,
+		owned:       `google_user_id IN (SELECT id FROM google_users WHERE user_id = $1)`,
+	},
+	{
+		name:     "google_link_scopes",
+		columns:  []string{"id", "google_link_id", "scope"},
+		nullable: []string{"google_link_id"},
+		owned: `google_link_id IN (
+			SELECT google_links.id
+			FROM google_links
+			INNER JOIN google_users
+				ON google_links.google_user_id = google_users.id
+			WHERE google_users.user_id = $1
+		)`,
+	},
+	{
+		name: "calendar_blocks",
+		columns: []string{
+			"id",
+			"source_calendar_id",
+			"source_event_id",
+			"target_calendar_id",
+			"target_event_id",
+			"starts_at",
+			"ends_at",
+			"created_at",
+			"updated_at",
+		},
+	},
+	{
+		name:    "speedtests",
+		columns: []string{"id", "started_at", "ended_at", "kbps_down", "hostname"},
+	},
+	{
+		name: "speedtest_rollups",
+		columns: []string{
+			"id",
+			"period_start",
+			"hostname",
+			"tests",
+			"kbps_down_min",
+			"kbps_down_max",
+			"kbps_down_sum",
+			"created_at",
+			"updated_at",
+		},
+	},
+	{
+		name:    "ip_addresses",
+		columns: []string{"id", "ip_address", "created_at"},
+	},
+	{
+		name:    "ip_address_rollups",
+		columns: []string{"id", "period_start", "ip_address", "sightings", "created_at", "updated_at"},
+	},
+}
+
+// exportManifest describes an export. It's written last, as manifest.json.
+type exportManifest struct {
+	// Version is the version of mainframe that wrote the export.
+	Version string `json:"version"`
+	// Migration is the migration the database was at.
+	Migration uint `json:"migration"`
+	// UserID is the user whose data was exported, or 0 if it's everyone's.
+	UserID     int64        `json:"user_id,omitempty"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Files      []exportFile `json:"files"`
+}
+
+// exportFile is one file in an export.
+type exportFile struct {
+	Name string `json:"name"`
+	// Rows is how many rows or records the file holds.
+	Rows int `json:"rows"`
+}
+
+// iworkoutStats is one Discord user's stats in #iworkout.
+type iworkoutStats struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	// Workouts is how many messages the user reacted to.
+	Workouts int `json:"workouts"`
+}
+
+// writeExport writes a zip of every dataset to w: exportTables as CSV, Potty
+// Trainer's records and iworkout stats as JSON, and a manifest. potty may be
+// nil to leave Potty Trainer out.
+//
+// If userID isn't 0, only the rows that user owns are exported. Potty Trainer
+// and iworkout, whose users aren't mainframe's, are left out.
+func writeExport(
+	ctx context.Context,
+	w io.Writer,
+	conn *sql.DB,
+	potty ddb.Store,
+	userID int64,
+) (exportManifest, error) {
+	manifest := exportManifest{Version: version, ExportedAt: time.Now().UTC(), UserID: userID}
+	if err := conn.QueryRowContext(
+		ctx,
+		`SELECT version FROM schema_migrations`,
+	).Scan(&manifest.Migration); err != nil {
+		return exportManifest{}, fmt.Errorf("can't get migration version: %w", err)
+	}
+
+	z := zip.NewWriter(w)
+	for _, t := range exportTables {
+		f, err := z.Create(t.name + ".csv")
+		if err != nil {
+			return exportManifest{}, fmt.Errorf("can't add %s to export: %w", t.name, err)
+		}
+		n, err := t.export(ctx, conn, f, userID)
+		if err != nil {
+			return exportManifest{}, err
+		}
+		manifest.Files = append(manifest.Files, exportFile{Name: t.name + ".csv", Rows: n})
+	}
+
+	if userID != 0 {
+		potty = nil
+	}
+	if potty != nil {
+		dump, err := potty.Dump(ctx)
+		if err != nil {
+			return exportManifest{}, fmt.Errorf("can't dump potty trainer: %w", err)
+		}
+		for _, v := range []struct {
+			name    string
+			rows    int
+			records any
+		}{
+			{"users", len(dump.Users), dump.Users},
+			{"eats", len(dump.Eats), dump.Eats},
+			{"poops", len(dump.Poops), dump.Poops},
+			{"foods", len(dump.Foods), dump.Foods},
+			{"food_names", len(dump.FoodNames), dump.FoodNames},
+			{"ingredients", len(dump.Ingredients), dump.Ingredients},
+		} {
+			name := path.Join(pottytrainerDir, v.name+".json")
+			if err := writeJSON(z, name, v.records); err != nil {
+				return exportManifest{}, err
+			}
+			manifest.Files = append(manifest.Files, exportFile{Name: name, Rows: v.rows})
+		}
+	}
+
+	if userID == 0 {
+		stats := iworkoutExport()
+		if err := writeJSON(z, iworkoutName, stats); err != nil {
+			return exportManifest{}, err
+		}
+		manifest.Files = append(manifest.Files, exportFile{Name: iworkoutName, Rows: len(stats)})
+	}
+
+	if err := writeJSON(z, manifestName, manifest); err != nil {
+		return exportManifest{}, err
+	}
+	if err := z.Close(); err != nil {
+		return exportManifest{}, fmt.Errorf("can't finish export: %w", err)
+	}
+	return manifest, nil
+}
+
+// export writes t's rows to w as CSV, with a header, and returns how many it
+// wrote. If userID isn't 0, only the rows that user owns are written.
+func (t exportTable) export(ctx context.Context, conn *sql.DB, w io.Writer, userID int64) (int, error) {
+	c := csv.NewWriter(w)
+	if err := c.Write(t.columns); err != nil {
+		return 0, fmt.Errorf("can't write %s header: %w", t.name, err)
+	}
+	if userID != 0 && t.owned == "" {
+		c.Flush()
+		return 0, c.Error()
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(t.columns, ", "), t.name)
+	var args []any
+	if userID != 0 {
+		query += " WHERE " + t.owned
+		args = append(args, userID)
+	}
+	rows, err := conn.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return 0, fmt.Errorf("can't query %s: %w", t.name, err)
+	}
+	defer rows.Close()
+
+	var n int
+	values := make([]sql.NullString, len(t.columns))
+	dests := make([]any, len(t.columns))
+	record := make([]string, len(t.columns))
+	for i := range values {
+		dests[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dests...); err != nil {
+			return 0, fmt.Errorf("can't scan %s: %w", t.name, err)
+		}
+		for i, v := range values {
+			record[i] = v.String
+		}
+		if err := c.Write(record); err != nil {
+			return 0, fmt.Errorf("can't write %s: %w", t.name, err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("can't read %s: %w", t.name, err)
+	}
+
+	c.Flush()
+	if err := c.Error(); err != nil {
+		return 0, fmt.Errorf("can't write %s: %w", t.name, err)
+	}
+	return n, nil
+}
+
+// iworkoutExport returns the stats of everyone iworkout has seen react in
+// #iworkout, most workouts first.
+func iworkoutExport() []iworkoutStats {
+	users := iworkout.Users()
+	workouts := map[string]int{}
+	for messageID := range iworkout.Messages() {
+		for userID := range iworkout.Reactions()[messageID] {
+			workouts[userID]++
+		}
+	}
+
+	stats := []iworkoutStats{}
+	for userID, n := range workouts {
+		stats = append(stats, iworkoutStats{
+			UserID:   userID,
+			Username: users[userID].Username,
+			Workouts: n,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Workouts != stats[j].Workouts {
+			return stats[i].Workouts > stats[j].Workouts
+		}
+		return stats[i].UserID < stats[j].UserID
+	})
+	return stats
+}
+
+// writeJSON adds a file called name to z holding v as indented JSON.
+func writeJSON(z *zip.Writer, name string, v any) error {
+	f, err := z.Create(name)
+	if err != nil {
+		return fmt.Errorf("can't add %s to export: %w", name, err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("can't write %s: %w", name, err)
+	}
+	return nil
+}
+
+// readImport reconstructs an instance from an export made by writeExport. The
+// database must be migrated and have nothing in exportTables yet, and the
+// export is refused if it's from a newer mainframe. Potty Trainer's records
+// are only loaded if potty isn't nil.
+//
+// Linked Google accounts come back dead, since their tokens aren't exported;
+// link them again from /account.
+func readImport(
+	ctx context.Context,
+	logger *log.Logger,
+	r io.ReaderAt,
+	size int64,
+	conn *sql.DB,
+	potty ddb.Store,
+) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("can't read export: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	var manifest exportManifest
+	if err := readJSON(files, manifestName, &manifest); err != nil {
+		return err
+	}
+	latest, err := db.LatestVersion()
+	if err != nil {
+		return err
+	}
+	if manifest.Migration > latest {
+		return fmt.Errorf(
+			"export is from migration %d, but this mainframe only knows up to %d; upgrade it first",
+			manifest.Migration,
+			latest,
+		)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, t := range exportTables {
+		var existing int
+		if err := tx.QueryRowContext(
+			ctx,
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s`, t.name),
+		).Scan(&existing); err != nil {
+			return fmt.Errorf("can't count %s: %w", t.name, err)
+		}
+		if existing > 0 {
+			return fmt.Errorf("%s already has %d rows; import into a fresh database", t.name, existing)
+		}
+	}
+
+	for _, t := range exportTables {
+		f, ok := files[t.name+".csv"]
+		if !ok {
+			return fmt.Errorf("export has no %s.csv", t.name)
+		}
+		n, err := t.load(ctx, tx, f)
+		if err != nil {
+			return err
+		}
+		if t.afterImport != "" {
+			if _, err := tx.ExecContext(ctx, t.afterImport); err != nil {
+				return fmt.Errorf("can't finish importing %s: %w", t.name, err)
+			}
+		}
+		logger.Printf("Imported %d rows into %s", n, t.name)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit import: %w", err)
+	}
+
+	if potty != nil {
+		var dump ddb.Dump
+		for _, v := range []struct {
+			name string
+			dst  any
+		}{
+			{"users", &dump.Users},
+			{"eats", &dump.Eats},
+			{"poops", &dump.Poops},
+			{"foods", &dump.Foods},
+			{"food_names", &dump.FoodNames},
+			{"ingredients", &dump.Ingredients},
+		} {
+			if err := readJSON(files, path.Join(pottytrainerDir, v.name+".json"), v.dst); err != nil {
+				return err
+			}
+		}
+		if err := ddb.Load(ctx, potty, &dump); err != nil {
+			return fmt.Errorf("can't load potty trainer: %w", err)
+		}
+		logger.Printf(
+			"Imported %d eats and %d poops into potty trainer",
+			len(dump.Eats),
+			len(dump.Poops),
+		)
+	}
+	return nil
+}
+
+// load inserts the rows of f, a CSV written by export, and returns how many it
+// inserted.
+func (t exportTable) load(ctx context.Context, tx *sql.Tx, f *zip.File) (int, error) {
+	r, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("can't open %s: %w", f.Name, err)
+	}
+	defer r.Close()
+
+	c := csv.NewReader(r)
+	header, err := c.Read()
+	if err != nil {
+		return 0, fmt.Errorf("can't read %s header: %w", f.Name, err)
+	}
+	known := map[string]bool{}
+	for _, column := range t.columns {
+		known[column] = true
+	}
+	for _, column := range header {
+		if !known[column] {
+			return 0, fmt.Errorf("%s has unknown column %s", f.Name, column)
+		}
+	}
+	nullable := map[string]bool{}
+	for _, column := range t.nullable {
+		nullable[column] = true
+	}
+
+	var defaults []string
+	for column := range t.importDefaults {
+		defaults = append(defaults, column)
+	}
+	sort.Strings(defaults)
+	columns := append(append([]string{}, header...), defaults...)
+
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (%s) VALUES (%s)`,
+		t.name,
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	))
+	if err != nil {
+		return 0, fmt.Errorf("can't prepare insert into %s: %w", t.name, err)
+	}
+	defer stmt.Close()
+
+	var n int
+	for {
+		record, err := c.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("can't read %s: %w", f.Name, err)
+		}
+
+		args := make([]any, 0, len(columns))
+		for i, value := range record {
+			if value == "" && nullable[header[i]] {
+				args = append(args, nil)
+			} else {
+				args = append(args, value)
+			}
+		}
+		for _, column := range defaults {
+			args = append(args, t.importDefaults[column])
+		}
+
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return n, fmt.Errorf("can't insert row %d of %s: %w", n+1, f.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// readJSON decodes the file called name in files into v.
+func readJSON(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("export has no %s", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("can't open %s: %w", name, err)
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("can't read %s: %w", name, err)
+	}
+	return nil
+}
+
+// handleExport serves a zip of the requesting user's own data at /export, to
+// logged-in users and API tokens with the export scope. Everything else the
+// instance holds is only exported by the mainframe export command.
+func handleExport(logger *log.Logger, conn *sql.DB, mux *http.ServeMux) {
+	logger = log.New(logger.Writer(), "[export] ", logger.Flags())
+
+	mux.Handle("/export", auth.RequireScope(scopeExport, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserID(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Buffered so a failure partway can still be reported as one.
+		var buf bytes.Buffer
+		manifest, err := writeExport(r.Context(), &buf, conn, nil, userID)
+		if err != nil {
+			logger.Printf("can't export: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		logger.Printf("User %d exported %d files", userID, len(manifest.Files))
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="%s"`, exportFilename(manifest.ExportedAt)),
+		)
+		if _, err := buf.WriteTo(w); err != nil {
+			logger.Printf("can't write export: %v", err)
+		}
+	})))
+}
+
+// exportFilename is what an export taken at t is called.
+func exportFilename(t time.Time) string {
+	return fmt.Sprintf("%s-export-%s.zip", dbName, t.UTC().Format("20060102T150405Z"))
+}
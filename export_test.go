@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/ddb"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	open := func() *sql.DB {
+		t.Helper()
+		conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	count := func(conn *sql.DB, query string) int {
+		t.Helper()
+		var n int
+		if err := conn.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	conn := open()
+	for _, q := range []string{
+		`INSERT INTO users DEFAULT VALUES`,
+		`INSERT INTO users DEFAULT VALUES`,
+		`INSERT INTO google_users (
+			user_id, external_id, email, verified_email, family_name, given_name,
+			name, picture, gender, hosted_domain, link, locale
+		) VALUES
+			(1, 'g1', 'one@example.com', 1, '', '', 'One', '', '', '', '', 'en'),
+			(2, 'g2', 'two@example.com', 1, '', '', 'Two', '', '', '', '', 'en')`,
+		`INSERT INTO google_links (
+			google_user_id, access_token, token_type, refresh_token, expires_at
+		) VALUES
+			(1, 'access1', 'Bearer', 'refresh1', '2026-01-01 00:00:00'),
+			(2, 'access2', 'Bearer', 'refresh2', '2026-01-01 00:00:00')`,
+		`INSERT INTO google_link_scopes (google_link_id, scope) VALUES
+			(1, 'openid'),
+			(1, 'email'),
+			(2, 'openid')`,
+		`INSERT INTO speedtests (started_at, ended_at, kbps_down, hostname) VALUES
+			('2026-01-01T00:00:00Z', '2026-01-01T00:01:00Z', 1000, 'h')`,
+		`INSERT INTO ip_addresses (ip_address) VALUES ('1.1.1.1'), ('2.2.2.2')`,
+	} {
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	potty := ddb.NewMemory()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := ddb.Load(ctx, potty, &ddb.Dump{
+		Users: []ddb.User{{ID: "u", Email: "u@example.com", CreatedAt: now, UpdatedAt: now}},
+		Eats:  []ddb.Eat{{ID: "e", UserID: "u", FoodID: "f", FoodText: "tea", AteAt: now}},
+		Poops: []ddb.Poop{{ID: "p", UserID: "u", PoopedAt: now, Quality: 1, Bristol: 4}},
+		Foods: []ddb.Food{{ID: "f", UserID: "u", CreatedAt: now, UpdatedAt: now}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var export bytes.Buffer
+	manifest, err := writeExport(ctx, &export, conn, potty, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := db.LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Migration != latest {
+		t.Errorf("got migration %d in the manifest, want %d", manifest.Migration, latest)
+	}
+	rows := map[string]int{}
+	for _, f := range manifest.Files {
+		rows[f.Name] = f.Rows
+	}
+	for name, want := range map[string]int{
+		"users.csv":                                    2,
+		"google_links.csv":                             2,
+		"google_link_scopes.csv":                       3,
+		"speedtests.csv":                               1,
+		"ip_addresses.csv":                             2,
+		path.Join(pottytrainerDir, "eats.json"):        1,
+		path.Join(pottytrainerDir, "ingredients.json"): 0,
+		iworkoutName:                                   0,
+	} {
+		if got, ok := rows[name]; !ok || got != want {
+			t.Errorf("got %d rows in %s (listed=%t), want %d", got, name, ok, want)
+		}
+	}
+
+	imported, importedPotty := open(), ddb.NewMemory()
+	if err := readImport(ctx, logger, bytes.NewReader(export.Bytes()), int64(export.Len()), imported, importedPotty); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range exportTables {
+		query := `SELECT COUNT(*) FROM ` + table.name
+		if got, want := count(imported, query), count(conn, query); got != want {
+			t.Errorf("imported %d rows into %s, want %d", got, table.name, want)
+		}
+	}
+	if n := count(imported, `SELECT COUNT(*) FROM google_links WHERE dead_at IS NULL OR access_token != ''`); n != 0 {
+		t.Errorf("got %d Google links alive or with tokens after importing, want 0", n)
+	}
+	want, err := potty.Dump(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := importedPotty.Dump(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got potty trainer %+v after importing, want %+v", got, want)
+	}
+
+	// Importing again would clash with what's there now.
+	err = readImport(ctx, logger, bytes.NewReader(export.Bytes()), int64(export.Len()), imported, nil)
+	if err == nil || !strings.Contains(err.Error(), "fresh database") {
+		t.Errorf("got error %v importing into a database with data, want a refusal", err)
+	}
+
+	// An export from a newer mainframe is refused before anything is written.
+	newer := rewriteManifest(t, export.Bytes(), func(m *exportManifest) { m.Migration = latest + 1 })
+	fresh := open()
+	err = readImport(ctx, logger, bytes.NewReader(newer), int64(len(newer)), fresh, nil)
+	if err == nil || !strings.Contains(err.Error(), "upgrade") {
+		t.Errorf("got error %v importing from a newer migration, want a refusal", err)
+	}
+	if n := count(fresh, `SELECT COUNT(*) FROM users`); n != 0 {
+		t.Errorf("got %d users after refusing an import, want 0", n)
+	}
+
+	// A single user's export holds only their own rows.
+	var own bytes.Buffer
+	manifest, err = writeExport(ctx, &own, conn, potty, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.UserID != 2 {
+		t.Errorf("got user %d in the manifest, want 2", manifest.UserID)
+	}
+	rows = map[string]int{}
+	for _, f := range manifest.Files {
+		rows[f.Name] = f.Rows
+	}
+	wantRows := map[string]int{}
+	for _, table := range exportTables {
+		wantRows[table.name+".csv"] = 0
+	}
+	wantRows["users.csv"] = 1
+	wantRows["google_users.csv"] = 1
+	wantRows["google_links.csv"] = 1
+	wantRows["google_link_scopes.csv"] = 1
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("got rows %v in a user's export, want %v", rows, wantRows)
+	}
+	z, err := zip.NewReader(bytes.NewReader(own.Bytes()), int64(own.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range z.File {
+		if f.Name != "google_users.csv" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "one@example.com") {
+			t.Errorf("user 2's export has user 1's Google account:\n%s", b)
+		}
+	}
+}
+
+// rewriteManifest returns a copy of the export with its manifest changed by
+// edit.
+func rewriteManifest(t *testing.T, export []byte, edit func(*exportManifest)) []byte {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(export), int64(len(export)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == manifestName {
+			var m exportManifest
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatal(err)
+			}
+			edit(&m)
+			if b, err = json.Marshal(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dst, err := w.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
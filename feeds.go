@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"twos.dev/mainframe/ics"
+)
+
+const (
+	// icsFeedPast is how far into the past ICS feed events are kept.
+	icsFeedPast = 30 * 24 * time.Hour
+	// icsFeedFuture is how far into the future recurring ICS feed events are
+	// expanded.
+	icsFeedFuture = 365 * 24 * time.Hour
+	// icsSourcePrefix is prepended to feed names to make them event sources.
+	icsSourcePrefix = "ics:"
+)
+
+var (
+	// icsFeeds is a comma-separated list of name=location pairs, where each
+	// location is an http(s):// or webcal:// URL or a local file path.
+	icsFeeds = os.Getenv("ICS_FEEDS")
+	// icsExportToken guards the merged /calendar.ics feed. The feed is disabled
+	// if this is unset.
+	icsExportToken = os.Getenv("ICS_EXPORT_TOKEN")
+)
+
+// runICSFeeds fetches every feed in ICS_FEEDS and replaces its events in the
+// calendar_events table with the feed's current contents. Events of feeds no
+// longer in ICS_FEEDS are deleted.
+func runICSFeeds(logger *log.Logger, _ string, db *sql.DB, _ *http.ServeMux, _ *googleClient) error {
+	logger = log.New(logger.Writer(), "[ics] ", logger.Flags())
+	ctx := context.Background()
+
+	now := time.Now()
+	var (
+		failed  []string
+		sources []string
+	)
+	for _, feed := range strings.Split(icsFeeds, ",") {
+		if strings.TrimSpace(feed) == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(strings.TrimSpace(feed), "=")
+		if !ok || name == "" || location == "" {
+			return fmt.Errorf("ICS_FEEDS entry %q must look like name=location", feed)
+		}
+		// Feeds that fail keep their old events until they sync again.
+		sources = append(sources, icsSourcePrefix+name)
+
+		n, err := syncICSFeed(ctx, db, name, location, now.Add(-icsFeedPast), now.Add(icsFeedFuture))
+		if err != nil {
+			logger.Printf("can't sync %s: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		logger.Printf("Synced %d events from %s", n, name)
+	}
+	if err := pruneCalendarEvents(ctx, db, icsSourcePrefix, sources); err != nil {
+		return fmt.Errorf("can't prune removed feeds: %w", err)
+	}
+	if len(sources) == 0 {
+		logger.Println("No ICS feeds to sync; set ICS_FEEDS to name=location pairs to add some")
+		return nil
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("can't sync feeds %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// syncICSFeed replaces the stored events of the named feed with those found at
+// location between from and to, returning how many there were.
+func syncICSFeed(ctx context.Context, db *sql.DB, name, location string, from, to time.Time) (int, error) {
+	r, err := openICSFeed(ctx, location)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cal, err := ics.Parse(r)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse feed: %w", err)
+	}
+
+	var events []calendarEvent
+	for _, e := range cal.Expand(from, to) {
+		var recurrenceID string
+		if !e.RecurrenceID.IsZero() {
+			recurrenceID = e.RecurrenceID.UTC().Format(time.RFC3339)
+		}
+		events = append(events, calendarEvent{
+			UID:          e.UID,
+			RecurrenceID: recurrenceID,
+			Summary:      e.Summary,
+			Description:  e.Description,
+			Location:     e.Location,
+			Start:        e.Start,
+			End:          e.End,
+			AllDay:       e.AllDay,
+			Busy:         !e.Transparent,
+		})
+	}
+
+	if err := replaceCalendarEvents(ctx, db, icsSourcePrefix+name, events); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// openICSFeed opens the feed at location, which is either a URL or a local
+// file path.
+func openICSFeed(ctx context.Context, location string) (io.ReadCloser, error) {
+	if strings.HasPrefix(location, "webcal://") {
+		location = "https://" + strings.TrimPrefix(location, "webcal://")
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		f, err := os.Open(strings.TrimPrefix(location, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("can't open feed: %w", err)
+		}
+		return f, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("can't build feed request: %w", err)
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("can't fetch feed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("unexpected status code %d fetching feed", resp.StatusCode)
+	}
+	return cancelingReadCloser{resp.Body, cancel}, nil
+}
+
+// cancelingReadCloser cancels a context when closed, so a response body can
+// outlive the function that made its request.
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelingReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// handleCalendarFeed serves every stored event as one merged ICS feed at
+// /calendar.ics, for subscribing to from other calendar apps.
+//
+// The feed requires ?token= to match ICS_EXPORT_TOKEN. It can be filtered with
+// ?source=a,b (feed names or full sources), ?from= and ?to= (YYYY-MM-DD),
+// ?q= (summary substring) and ?busy=1 (busy events only).
+func handleCalendarFeed(logger *log.Logger, db *sql.DB, mux *http.ServeMux) {
+	logger = log.New(logger.Writer(), "[ics] ", logger.Flags())
+
+	mux.HandleFunc("/calendar.ics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if icsExportToken == "" {
+			http.Error(w, "calendar feed is disabled; set ICS_EXPORT_TOKEN to enable it", http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(icsExportToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		now := time.Now()
+		from, to := now.Add(-icsFeedPast), now.Add(icsFeedFuture)
+		for param, t := range map[string]*time.Time{"from": &from, "to": &to} {
+			v := r.FormValue(param)
+			if v == "" {
+				continue
+			}
+			parsed, err := time.ParseInLocation("2006-01-02", v, time.Local)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s must look like 2006-01-02", param), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+
+		var sources []string
+		if v := r.FormValue("source"); v != "" {
+			for _, source := range strings.Split(v, ",") {
+				if !strings.Contains(source, ":") {
+					source = icsSourcePrefix + source
+				}
+				sources = append(sources, source)
+			}
+		}
+
+		events, err := calendarEventsBetween(r.Context(), db, from, to, sources...)
+		if err != nil {
+			logger.Printf("can't load events for feed: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		q := strings.ToLower(r.FormValue("q"))
+		cal := ics.Calendar{Name: "Mainframe"}
+		for _, e := range events {
+			if q != "" && !strings.Contains(strings.ToLower(e.Summary), q) {
+				continue
+			}
+			if r.FormValue("busy") == "1" && !e.Busy {
+				continue
+			}
+			id := sha256.Sum256([]byte(e.Source + "\x00" + e.UID + "\x00" + e.RecurrenceID))
+			cal.Events = append(cal.Events, ics.Event{
+				UID:         fmt.Sprintf("%x@mainframe", id[:16]),
+				Summary:     e.Summary,
+				Description: e.Description,
+				Location:    e.Location,
+				Start:       e.Start,
+				End:         e.End,
+				AllDay:      e.AllDay,
+				Transparent: !e.Busy,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if err := ics.Encode(w, &cal); err != nil {
+			logger.Printf("can't write feed: %v", err)
+		}
+	})
+}
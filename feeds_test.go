@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+	"twos.dev/mainframe/ics"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	for name, want := range map[string]int{"school": 4, "club": 1} {
+		n, err := syncICSFeed(ctx, conn, name, filepath.Join("testdata", name+".ics"), from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("got %d events from %s, want %d", n, name, want)
+		}
+	}
+
+	mux := http.NewServeMux()
+	handleCalendarFeed(logger, conn, mux)
+	get := func(query string) (int, []string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/calendar.ics?"+query, nil))
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		cal, err := ics.Parse(rec.Body)
+		if err != nil {
+			t.Fatalf("can't parse feed for %s: %v", query, err)
+		}
+		var summaries []string
+		for _, e := range cal.Events {
+			start := e.Start
+			if !e.AllDay {
+				start = start.UTC()
+			}
+			summaries = append(summaries, start.Format("01-02")+" "+e.Summary)
+		}
+		sort.Strings(summaries)
+		return rec.Code, summaries
+	}
+
+	defer func(token string) { icsExportToken = token }(icsExportToken)
+	icsExportToken = ""
+	if code, _ := get("token="); code != http.StatusNotFound {
+		t.Errorf("got %d with no export token set, want 404", code)
+	}
+	icsExportToken = "secret"
+	for _, query := range []string{"", "token=wrong"} {
+		if code, _ := get(query); code != http.StatusUnauthorized {
+			t.Errorf("got %d for %q, want 401", code, query)
+		}
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"from=2026-01-01&to=2026-02-01", []string{
+			"01-05 Soccer practice",
+			"01-06 Chess club",
+			"01-09 Teacher work day",
+			"01-12 Soccer practice",
+			"01-19 Soccer practice",
+		}},
+		{"from=2026-01-01&to=2026-02-01&source=club", []string{"01-06 Chess club"}},
+		{"from=2026-01-01&to=2026-01-10&source=ics:club,school", []string{
+			"01-05 Soccer practice",
+			"01-06 Chess club",
+			"01-09 Teacher work day",
+		}},
+		{"from=2026-01-10&to=2026-02-01&q=SOCCER", []string{"01-12 Soccer practice", "01-19 Soccer practice"}},
+		{"from=2026-01-01&to=2026-01-12&source=school&busy=1", []string{"01-05 Soccer practice"}},
+	} {
+		code, got := get("token=secret&" + c.query)
+		if code != http.StatusOK {
+			t.Errorf("got %d for %q, want 200", code, c.query)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("got %q for %q, want %q", got, c.query, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("got %q for %q, want %q", got, c.query, c.want)
+				break
+			}
+		}
+	}
+
+	if code, _ := get("token=secret&from=tomorrow"); code != http.StatusBadRequest {
+		t.Errorf("got %d for a bad date, want 400", code)
+	}
+}
+
+func TestRunICSFeeds(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	conn, err := db.New(logger, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	start := time.Now().Add(24 * time.Hour).UTC()
+	feed := filepath.Join(t.TempDir(), "feed.ics")
+	if err := os.WriteFile(feed, []byte(strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:tomorrow",
+		"SUMMARY:Tomorrow",
+		"DTSTART:" + start.Format("20060102T150405Z"),
+		"DTEND:" + start.Add(time.Hour).Format("20060102T150405Z"),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := func() []string {
+		t.Helper()
+		events, err := calendarEventsBetween(ctx, conn, start.Add(-time.Hour), start.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sources []string
+		for _, e := range events {
+			sources = append(sources, e.Source)
+		}
+		sort.Strings(sources)
+		return sources
+	}
+
+	defer func(feeds string) { icsFeeds = feeds }(icsFeeds)
+	for _, c := range []struct {
+		feeds string
+		want  []string
+	}{
+		{"school=" + feed + ",club=" + feed, []string{"ics:club", "ics:school"}},
+		// Removed feeds lose their events, and failing ones keep them.
+		{"school=" + feed + ",club=" + filepath.Join(t.TempDir(), "gone.ics"), []string{"ics:club", "ics:school"}},
+		{"school=" + feed, []string{"ics:school"}},
+		{"", nil},
+	} {
+		icsFeeds = c.feeds
+		err := runICSFeeds(logger, "", conn, nil, nil)
+		if failing := strings.Contains(c.feeds, "gone.ics"); (err != nil) != failing {
+			t.Errorf("got error %v syncing %q, want error %t", err, c.feeds, failing)
+		}
+		if got := sources(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("got events from %v after syncing %q, want %v", got, c.feeds, c.want)
+		}
+	}
+}
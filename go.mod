@@ -14,7 +14,6 @@ require (
 	github.com/guregu/dynamo v1.18.2
 	github.com/inconshreveable/go-update v0.0.0-20160112193335-8152e7eb6ccf
 	github.com/jayschwa/go-dyndns v0.0.0-20130808202408-c49f6dc440e2
-	github.com/markbates/pkger v0.17.1
 	github.com/mitranim/gow v0.0.0-20230208153212-36c8536a96b8
 	github.com/robfig/cron/v3 v3.0.0
 	golang.org/x/oauth2 v0.4.0
@@ -33,7 +32,6 @@ require (
 	github.com/ddo/pick-json v0.0.0-20170207095303-c8760e09e0fe // indirect
 	github.com/ddo/rq v0.0.0-20190828174524-b3daa55fcaba // indirect
 	github.com/dustin/go-humanize v1.0.0 // indirect
-	github.com/gobuffalo/here v0.6.0 // indirect
 	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
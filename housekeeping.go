@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// stalePurge deletes rows nothing will ever read again. The query is given
+// the cutoff, formatted to match how the table stores its timestamps.
+type stalePurge struct {
+	table  string
+	query  string
+	cutoff func(now time.Time) string
+}
+
+// stalePurges are run by the housekeeping cron. Anything that leaves rows
+// behind to expire belongs here; time-series data worth summarizing belongs in
+// retentionPolicies instead.
+var stalePurges = []stalePurge{
+	{
+		// Abandoned logins. created_at is in SQLite's CURRENT_TIMESTAMP format.
+		table: "google_oauth_states",
+		query: `DELETE FROM google_oauth_states WHERE created_at < $1`,
+		cutoff: func(now time.Time) string {
+			return now.Add(-stateMaxAge).UTC().Format(time.DateTime)
+		},
+	},
+	{
+		table: "sessions",
+		query: `DELETE FROM sessions WHERE expires_at <= $1`,
+		cutoff: func(now time.Time) string {
+			return now.UTC().Format(time.RFC3339)
+		},
+	},
+}
+
+// runHousekeeping deletes expired OAuth states and expired sessions.
+func runHousekeeping(
+	logger *log.Logger,
+	_ string,
+	db *sql.DB,
+	_ *http.ServeMux,
+	_ *googleClient,
+) error {
+	logger = log.New(logger.Writer(), "[housekeeping] ", logger.Flags())
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, purge := range stalePurges {
+		result, err := db.ExecContext(ctx, purge.query, purge.cutoff(now))
+		if err != nil {
+			return fmt.Errorf("can't purge %s: %w", purge.table, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("can't count purged %s: %w", purge.table, err)
+		}
+		if n > 0 {
+			logger.Printf("Purged %d stale rows from %s", n, purge.table)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package ics
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// productID identifies mainframe as the producer of calendars it encodes.
+const productID = "-//twos.dev//mainframe//EN"
+
+// Encode writes c to w as a VCALENDAR object. Times are written in UTC, except
+// for all-day events, which are written as dates.
+//
+// Events are written as given. To publish expanded occurrences as standalone
+// events, give each its own UID and clear its RecurrenceID first.
+func Encode(w io.Writer, c *Calendar) error {
+	bw := bufio.NewWriter(w)
+	stamp := time.Now().UTC().Format(utcDateTimeFormat)
+
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:"+productID)
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	if c.Name != "" {
+		writeLine(bw, "X-WR-CALNAME:"+escapeText(c.Name))
+	}
+	for _, e := range c.Events {
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+e.UID)
+		writeLine(bw, "DTSTAMP:"+stamp)
+		writeLine(bw, formatTime("DTSTART", e.Start, e.AllDay))
+		writeLine(bw, formatTime("DTEND", e.End, e.AllDay))
+		if !e.RecurrenceID.IsZero() {
+			writeLine(bw, formatTime("RECURRENCE-ID", e.RecurrenceID, e.AllDay))
+		}
+		if e.RRule != nil {
+			writeLine(bw, "RRULE:"+e.RRule.String())
+			for _, t := range e.RDates {
+				writeLine(bw, formatTime("RDATE", t, e.AllDay))
+			}
+			for _, t := range e.ExDates {
+				writeLine(bw, formatTime("EXDATE", t, e.AllDay))
+			}
+		}
+		if e.Summary != "" {
+			writeLine(bw, "SUMMARY:"+escapeText(e.Summary))
+		}
+		if e.Description != "" {
+			writeLine(bw, "DESCRIPTION:"+escapeText(e.Description))
+		}
+		if e.Location != "" {
+			writeLine(bw, "LOCATION:"+escapeText(e.Location))
+		}
+		if e.Status != "" {
+			writeLine(bw, "STATUS:"+e.Status)
+		}
+		if e.Transparent {
+			writeLine(bw, "TRANSP:TRANSPARENT")
+		} else {
+			writeLine(bw, "TRANSP:OPAQUE")
+		}
+		writeLine(bw, "END:VEVENT")
+	}
+	writeLine(bw, "END:VCALENDAR")
+
+	return bw.Flush()
+}
+
+func formatTime(name string, t time.Time, allDay bool) string {
+	if allDay {
+		return name + ";VALUE=DATE:" + t.Format(dateFormat)
+	}
+	return name + ":" + t.UTC().Format(utcDateTimeFormat)
+}
+
+// writeLine writes line to w followed by CRLF, folding it so no line is longer
+// than 75 octets. Multi-byte characters are never split across lines.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines lose one octet to the leading space.
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+// escapeText escapes s for use as a TEXT value.
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
@@ -0,0 +1,74 @@
+package ics
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	for _, name := range []string{"zones.ics", "recurring.ics"} {
+		t.Run(name, func(t *testing.T) {
+			cal := parseFile(t, name)
+			var buf bytes.Buffer
+			if err := Encode(&buf, cal); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+				if len(line) > maxLineOctets {
+					t.Errorf("got a %d-octet line %q, want at most %d", len(line), line, maxLineOctets)
+				}
+			}
+
+			got, err := Parse(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != cal.Name {
+				t.Errorf("got name %q, want %q", got.Name, cal.Name)
+			}
+			if len(got.Events) != len(cal.Events) {
+				t.Fatalf("got %d events, want %d", len(got.Events), len(cal.Events))
+			}
+			for i, want := range cal.Events {
+				if e := got.Events[i]; !sameEvent(e, want) {
+					t.Errorf("got %+v, want %+v", e, want)
+				}
+			}
+		})
+	}
+}
+
+// sameEvent reports whether a and b are the same event, whatever time zones
+// their times are in. All-day events are compared by date, since encoding
+// them drops their zone.
+func sameEvent(a, b Event) bool {
+	same := func(x, y time.Time) bool {
+		if a.AllDay {
+			return x.Format(dateFormat) == y.Format(dateFormat)
+		}
+		return x.Equal(y)
+	}
+	sameAll := func(x, y []time.Time) bool {
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !same(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if !same(a.Start, b.Start) || !same(a.End, b.End) || !same(a.RecurrenceID, b.RecurrenceID) ||
+		!sameAll(a.RDates, b.RDates) || !sameAll(a.ExDates, b.ExDates) {
+		return false
+	}
+	if (a.RRule == nil) != (b.RRule == nil) || a.RRule != nil && a.RRule.String() != b.RRule.String() {
+		return false
+	}
+	a.Start, a.End, a.RecurrenceID, a.RDates, a.ExDates, a.RRule = b.Start, b.End, b.RecurrenceID, b.RDates, b.ExDates, b.RRule
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,631 @@
+// Package ics reads and writes iCalendar (RFC 5545) data, as published by
+// most calendar apps at .ics URLs.
+//
+// Only events are supported. Recurring events can be expanded into their
+// individual occurrences with Calendar.Expand.
+package ics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateFormat        = "20060102"
+	dateTimeFormat    = "20060102T150405"
+	utcDateTimeFormat = "20060102T150405Z"
+	maxLineOctets     = 75
+)
+
+// Calendar is a parsed VCALENDAR object.
+type Calendar struct {
+	// Name is the human-readable name of the calendar, if the publisher gave
+	// one.
+	Name string
+	// Events are the calendar's events, in the order they were published.
+	// Recurring events appear once, with RRule set; use Expand to get their
+	// occurrences.
+	Events []Event
+}
+
+// Event is a single VEVENT.
+type Event struct {
+	// UID uniquely identifies the event within its calendar. All occurrences of
+	// a recurring event share a UID.
+	UID string
+	// Summary is the title of the event.
+	Summary string
+	// Description is the long-form description of the event.
+	Description string
+	// Location is the free-form location of the event.
+	Location string
+	// Status is one of TENTATIVE, CONFIRMED or CANCELLED, or empty if the
+	// publisher did not say.
+	Status string
+	// Start is when the event starts. For all-day events, this is midnight at
+	// the start of the day in the calendar's default location.
+	Start time.Time
+	// End is when the event ends, exclusive.
+	End time.Time
+	// AllDay is true if the event takes up whole days rather than a span of
+	// time.
+	AllDay bool
+	// Transparent is true if the event does not make its attendees busy.
+	Transparent bool
+	// RRule is the recurrence rule of the event, or nil if it does not repeat.
+	RRule *RRule
+	// RDates are extra occurrences of a recurring event outside its RRule.
+	RDates []time.Time
+	// ExDates are occurrences of a recurring event that have been removed.
+	ExDates []time.Time
+	// RecurrenceID is the original start of the occurrence this event
+	// overrides, or the zero time if this is not an override. Occurrences
+	// returned by Calendar.Expand also have this set to their original start.
+	RecurrenceID time.Time
+}
+
+// Cancelled returns true if the event has been cancelled by its organizer.
+func (e Event) Cancelled() bool {
+	return e.Status == "CANCELLED"
+}
+
+// property is one content line of an iCalendar object.
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// component is a BEGIN/END block of an iCalendar object.
+type component struct {
+	name       string
+	properties []property
+	children   []*component
+}
+
+// get returns the first property with the given name, if any.
+func (c *component) get(name string) (property, bool) {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return property{}, false
+}
+
+// Parse reads a VCALENDAR object from r. Floating times (times with no time
+// zone) are interpreted in the calendar's X-WR-TIMEZONE if it has one, or
+// time.Local otherwise.
+func Parse(r io.Reader) (*Calendar, error) {
+	root, err := parseComponents(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var vcalendar *component
+	for _, child := range root.children {
+		if child.name == "VCALENDAR" {
+			vcalendar = child
+			break
+		}
+	}
+	if vcalendar == nil {
+		return nil, errors.New("no VCALENDAR found")
+	}
+
+	zones := zones{
+		defaults: time.Local,
+		custom:   map[string]*time.Location{},
+	}
+	if p, ok := vcalendar.get("X-WR-TIMEZONE"); ok {
+		if loc, err := time.LoadLocation(p.value); err == nil {
+			zones.defaults = loc
+		}
+	}
+	for _, child := range vcalendar.children {
+		if child.name == "VTIMEZONE" {
+			zones.addVTimezone(child)
+		}
+	}
+
+	var cal Calendar
+	if p, ok := vcalendar.get("X-WR-CALNAME"); ok {
+		cal.Name = unescapeText(p.value)
+	}
+	for _, child := range vcalendar.children {
+		if child.name != "VEVENT" {
+			continue
+		}
+		event, err := parseEvent(child, zones)
+		if err != nil {
+			return nil, err
+		}
+		cal.Events = append(cal.Events, event)
+	}
+
+	return &cal, nil
+}
+
+// parseComponents unfolds and tokenizes r into a tree of components. The
+// returned component is a nameless root whose children are the top-level
+// components of r.
+func parseComponents(r io.Reader) (*component, error) {
+	root := &component{}
+	stack := []*component{root}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read calendar: %w", err)
+	}
+
+	for i, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		current := stack[len(stack)-1]
+		switch p.name {
+		case "BEGIN":
+			child := &component{name: strings.ToUpper(p.value)}
+			current.children = append(current.children, child)
+			stack = append(stack, child)
+		case "END":
+			if len(stack) == 1 || current.name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			current.properties = append(current.properties, p)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unterminated %s", stack[len(stack)-1].name)
+	}
+
+	return root, nil
+}
+
+// parseProperty parses one unfolded content line.
+func parseProperty(line string) (property, error) {
+	var (
+		quoted bool
+		colon  = -1
+	)
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return property{}, fmt.Errorf("no value in %q", line)
+	}
+
+	p := property{
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	parts := splitUnquoted(line[:colon], ';')
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return p, nil
+}
+
+// splitUnquoted splits s around sep, ignoring any sep between double quotes.
+func splitUnquoted(s string, sep rune) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseEvent(c *component, zones zones) (Event, error) {
+	var (
+		e           Event
+		err         error
+		hasEnd      bool
+		hasDuration bool
+		duration    time.Duration
+	)
+	for _, p := range c.properties {
+		switch p.name {
+		case "UID":
+			e.UID = p.value
+		case "SUMMARY":
+			e.Summary = unescapeText(p.value)
+		case "DESCRIPTION":
+			e.Description = unescapeText(p.value)
+		case "LOCATION":
+			e.Location = unescapeText(p.value)
+		case "STATUS":
+			e.Status = strings.ToUpper(p.value)
+		case "TRANSP":
+			e.Transparent = strings.EqualFold(p.value, "TRANSPARENT")
+		case "DTSTART":
+			if e.Start, e.AllDay, err = zones.parseTime(p); err != nil {
+				return Event{}, fmt.Errorf("bad DTSTART in %s: %w", e.UID, err)
+			}
+		case "DTEND":
+			if e.End, _, err = zones.parseTime(p); err != nil {
+				return Event{}, fmt.Errorf("bad DTEND in %s: %w", e.UID, err)
+			}
+			hasEnd = true
+		case "DURATION":
+			if duration, err = parseDuration(p.value); err != nil {
+				return Event{}, fmt.Errorf("bad DURATION in %s: %w", e.UID, err)
+			}
+			hasDuration = true
+		case "RECURRENCE-ID":
+			if e.RecurrenceID, _, err = zones.parseTime(p); err != nil {
+				return Event{}, fmt.Errorf("bad RECURRENCE-ID in %s: %w", e.UID, err)
+			}
+		case "RRULE":
+			if e.RRule, err = ParseRRule(p.value, zones.defaults); err != nil {
+				return Event{}, fmt.Errorf("bad RRULE in %s: %w", e.UID, err)
+			}
+		case "RDATE":
+			times, err := zones.parseTimes(p)
+			if err != nil {
+				return Event{}, fmt.Errorf("bad RDATE in %s: %w", e.UID, err)
+			}
+			e.RDates = append(e.RDates, times...)
+		case "EXDATE":
+			times, err := zones.parseTimes(p)
+			if err != nil {
+				return Event{}, fmt.Errorf("bad EXDATE in %s: %w", e.UID, err)
+			}
+			e.ExDates = append(e.ExDates, times...)
+		}
+	}
+
+	if e.Start.IsZero() {
+		return Event{}, fmt.Errorf("event %s has no DTSTART", e.UID)
+	}
+	switch {
+	case hasEnd:
+	case hasDuration:
+		e.End = e.Start.Add(duration)
+	case e.AllDay:
+		e.End = e.Start.AddDate(0, 0, 1)
+	default:
+		e.End = e.Start
+	}
+
+	return e, nil
+}
+
+// zones resolves TZID parameters to locations.
+type zones struct {
+	// defaults is the location used for floating times and all-day events.
+	defaults *time.Location
+	// custom holds locations defined by VTIMEZONE components whose TZIDs are
+	// not IANA names (e.g. Windows time zone names from Outlook).
+	custom map[string]*time.Location
+}
+
+// addVTimezone defines a VTIMEZONE whose TZID is not an IANA name by its
+// STANDARD and DAYLIGHT rules.
+func (z zones) addVTimezone(c *component) {
+	tzid, ok := c.get("TZID")
+	if !ok {
+		return
+	}
+	if _, err := time.LoadLocation(strings.TrimPrefix(tzid.value, "/")); err == nil {
+		return
+	}
+	if loc, err := vtimezoneLocation(tzid.value, c); err == nil {
+		z.custom[tzid.value] = loc
+	}
+}
+
+// location returns the location named by tzid.
+func (z zones) location(tzid string) *time.Location {
+	if tzid == "" {
+		return z.defaults
+	}
+	if loc, ok := z.custom[tzid]; ok {
+		return loc
+	}
+	if loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+		return loc
+	}
+	return z.defaults
+}
+
+// parseTime parses the DATE or DATE-TIME value of p. The second return value
+// is true if the value was a DATE.
+func (z zones) parseTime(p property) (time.Time, bool, error) {
+	times, err := z.parseTimes(p)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(times) != 1 {
+		return time.Time{}, false, fmt.Errorf("expected one time, got %d", len(times))
+	}
+	return times[0], isDate(p), nil
+}
+
+// parseTimes parses the comma-separated DATE or DATE-TIME values of p.
+func (z zones) parseTimes(p property) ([]time.Time, error) {
+	loc := z.location(p.params["TZID"])
+	var times []time.Time
+	for _, v := range strings.Split(p.value, ",") {
+		var (
+			t   time.Time
+			err error
+		)
+		switch {
+		case isDate(p) || len(v) == len(dateFormat):
+			t, err = time.ParseInLocation(dateFormat, v, z.defaults)
+		case strings.HasSuffix(v, "Z"):
+			t, err = time.Parse(utcDateTimeFormat, v)
+		default:
+			t, err = time.ParseInLocation(dateTimeFormat, v, loc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+func isDate(p property) bool {
+	return strings.EqualFold(p.params["VALUE"], "DATE")
+}
+
+// parseUTCOffset parses a UTC-OFFSET value like -0500 or +053000 into seconds
+// east of UTC.
+func parseUTCOffset(s string) (int, error) {
+	if len(s) != 5 && len(s) != 7 {
+		return 0, fmt.Errorf("bad UTC offset %q", s)
+	}
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, fmt.Errorf("bad UTC offset %q", s)
+	}
+	var parts []int
+	for i := 1; i < len(s); i += 2 {
+		n, err := strconv.Atoi(s[i : i+2])
+		if err != nil {
+			return 0, fmt.Errorf("bad UTC offset %q", s)
+		}
+		parts = append(parts, n)
+	}
+	seconds := parts[0]*3600 + parts[1]*60
+	if len(parts) == 3 {
+		seconds += parts[2]
+	}
+	return sign * seconds, nil
+}
+
+// parseDuration parses a DURATION value like PT1H30M or -P2D.
+func parseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("bad duration %q", orig)
+	}
+	s = s[1:]
+
+	var (
+		d      time.Duration
+		inTime bool
+		num    string
+	)
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T':
+			inTime = true
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("bad duration %q", orig)
+			}
+			num = ""
+			switch {
+			case r == 'W' && !inTime:
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case r == 'D' && !inTime:
+				d += time.Duration(n) * 24 * time.Hour
+			case r == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case r == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case r == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("bad duration %q", orig)
+			}
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("bad duration %q", orig)
+	}
+	return sign * d, nil
+}
+
+// unescapeText reverses the escaping applied to TEXT values.
+func unescapeText(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		escaped = false
+		switch r {
+		case 'n', 'N':
+			b.WriteRune('\n')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Expand returns every occurrence of every event in c that overlaps the span
+// [from, to), sorted by start time. Recurring events are expanded according
+// to their RRULE, RDATEs and EXDATEs, and overridden occurrences are replaced
+// by their overrides, wherever those moved them. Cancelled events are left out.
+//
+// The returned events never have RRule set. Occurrences of recurring events
+// have RecurrenceID set to their original start, which together with UID
+// identifies them uniquely.
+func (c *Calendar) Expand(from, to time.Time) []Event {
+	overrides := map[string]map[int64]Event{}
+	for _, e := range c.Events {
+		if e.RecurrenceID.IsZero() {
+			continue
+		}
+		if overrides[e.UID] == nil {
+			overrides[e.UID] = map[int64]Event{}
+		}
+		overrides[e.UID][e.RecurrenceID.Unix()] = e
+	}
+
+	var occurrences []Event
+	add := func(e Event) {
+		if e.Cancelled() || !overlaps(e, from, to) {
+			return
+		}
+		occurrences = append(occurrences, e)
+	}
+
+	for _, e := range c.Events {
+		if !e.RecurrenceID.IsZero() {
+			// Overrides whose master is missing stand on their own.
+			if e.RRule == nil && !hasMaster(c.Events, e.UID) {
+				add(e)
+			}
+			continue
+		}
+		if e.RRule == nil && len(e.RDates) == 0 {
+			add(e)
+			continue
+		}
+
+		length := e.End.Sub(e.Start)
+		excluded := map[int64]struct{}{}
+		for _, t := range e.ExDates {
+			excluded[t.Unix()] = struct{}{}
+		}
+		starts := []time.Time{e.Start}
+		if e.RRule != nil {
+			starts = e.RRule.Occurrences(e.Start, from.Add(-length), to)
+		}
+		starts = append(starts, e.RDates...)
+		seen := map[int64]struct{}{}
+		for _, start := range starts {
+			key := start.Unix()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if _, ok := excluded[key]; ok {
+				continue
+			}
+			if override, ok := overrides[e.UID][key]; ok {
+				add(override)
+				continue
+			}
+			occurrence := e
+			occurrence.RRule = nil
+			occurrence.RDates = nil
+			occurrence.ExDates = nil
+			occurrence.Start = start
+			occurrence.End = start.Add(length)
+			occurrence.RecurrenceID = start
+			add(occurrence)
+		}
+		// Overrides can move an occurrence into the span from outside it.
+		for key, override := range overrides[e.UID] {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			if _, ok := excluded[key]; ok {
+				continue
+			}
+			add(override)
+		}
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	return occurrences
+}
+
+// overlaps returns true if e takes place at least partly within [from, to).
+// Zero-length events overlap if they start within the span.
+func overlaps(e Event, from, to time.Time) bool {
+	if !e.Start.Before(to) {
+		return false
+	}
+	if e.End.Equal(e.Start) {
+		return !e.Start.Before(from)
+	}
+	return e.End.After(from)
+}
+
+func hasMaster(events []Event, uid string) bool {
+	for _, e := range events {
+		if e.UID == uid && e.RecurrenceID.IsZero() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package ics
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFile parses the fixture at testdata/name.
+func parseFile(t *testing.T, name string) *Calendar {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cal, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+// event returns the first event in c with the given UID.
+func event(t *testing.T, c *Calendar, uid string) Event {
+	t.Helper()
+	for _, e := range c.Events {
+		if e.UID == uid {
+			return e
+		}
+	}
+	t.Fatalf("no event %s", uid)
+	return Event{}
+}
+
+func TestParse(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal := parseFile(t, "zones.ics")
+
+	if cal.Name != "Zones, and more" {
+		t.Errorf("got name %q, want %q", cal.Name, "Zones, and more")
+	}
+
+	for _, c := range []struct {
+		uid         string
+		start, end  time.Time
+		allDay      bool
+		transparent bool
+	}{
+		{
+			// A Windows time zone name, defined by its VTIMEZONE.
+			uid:   "windows",
+			start: time.Date(2026, 1, 5, 17, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 1, 5, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			// The same zone on the day its DAYLIGHT rule starts.
+			uid:   "windows-summer",
+			start: time.Date(2026, 3, 8, 16, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 8, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			// A DAYLIGHT rule whose UNTIL includes its last onset, after
+			// which the zone stays on standard time.
+			uid:   "windows-until",
+			start: time.Date(2025, 3, 30, 7, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 7, 5, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			// An IANA name in summer, ending after its DURATION.
+			uid:   "iana",
+			start: time.Date(2026, 7, 1, 13, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 7, 1, 14, 30, 0, 0, time.UTC),
+		},
+		{
+			uid:   "utc",
+			start: time.Date(2026, 1, 5, 17, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 1, 5, 17, 30, 0, 0, time.UTC),
+		},
+		{
+			// Floating, so in X-WR-TIMEZONE, which is on summer time.
+			uid:   "floating",
+			start: time.Date(2026, 7, 5, 11, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 7, 5, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			// One day, with no DTEND.
+			uid:         "allday",
+			start:       time.Date(2026, 1, 6, 0, 0, 0, 0, london),
+			end:         time.Date(2026, 1, 7, 0, 0, 0, 0, london),
+			allDay:      true,
+			transparent: true,
+		},
+		{
+			uid:    "multiday",
+			start:  time.Date(2026, 1, 10, 0, 0, 0, 0, london),
+			end:    time.Date(2026, 1, 13, 0, 0, 0, 0, london),
+			allDay: true,
+		},
+	} {
+		t.Run(c.uid, func(t *testing.T) {
+			e := event(t, cal, c.uid)
+			if !e.Start.Equal(c.start) || !e.End.Equal(c.end) {
+				t.Errorf("got %s to %s, want %s to %s", e.Start, e.End, c.start, c.end)
+			}
+			if e.AllDay != c.allDay {
+				t.Errorf("got all day %t, want %t", e.AllDay, c.allDay)
+			}
+			if e.Transparent != c.transparent {
+				t.Errorf("got transparent %t, want %t", e.Transparent, c.transparent)
+			}
+		})
+	}
+
+	trip := event(t, cal, "multiday")
+	if want := "Pack light.\nBring a jacket that folds; it rains."; trip.Description != want {
+		t.Errorf("got description %q, want %q", trip.Description, want)
+	}
+	if want := "Lisbon, Portugal"; trip.Location != want {
+		t.Errorf("got location %q, want %q", trip.Location, want)
+	}
+	if got := event(t, cal, "utc").Status; got != "TENTATIVE" {
+		t.Errorf("got status %q, want TENTATIVE", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no calendar":  "BEGIN:VEVENT\nEND:VEVENT\n",
+		"unterminated": "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:20260101T000000Z\n",
+		"mismatched":   "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VCALENDAR\n",
+		"no start":     "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nEND:VEVENT\nEND:VCALENDAR\n",
+		"bad rule":     "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:20260101T000000Z\nRRULE:FREQ=FORTNIGHTLY\nEND:VEVENT\nEND:VCALENDAR\n",
+	} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("parsed %s without error", name)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	cal := parseFile(t, "recurring.ics")
+	occurrences := cal.Expand(
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+	)
+
+	got := map[string][]string{}
+	for i, e := range occurrences {
+		if i > 0 && e.Start.Before(occurrences[i-1].Start) {
+			t.Errorf("%s at %s is out of order", e.UID, e.Start)
+		}
+		if e.RRule != nil {
+			t.Errorf("%s at %s still has a rule", e.UID, e.Start)
+		}
+		got[e.UID] = append(got[e.UID], e.Start.UTC().Format(time.RFC3339)+" "+e.Summary)
+	}
+
+	for uid, want := range map[string][]string{
+		"count": {
+			"2026-01-05T15:00:00Z Standup",
+			"2026-01-06T15:00:00Z Standup",
+			"2026-01-07T15:00:00Z Standup",
+		},
+		// UNTIL is inclusive.
+		"until": {
+			"2026-01-02T10:00:00Z Weekly review",
+			"2026-01-09T10:00:00Z Weekly review",
+			"2026-01-16T10:00:00Z Weekly review",
+		},
+		// Wednesday the 7th is excluded, but still counts toward COUNT.
+		"byday": {
+			"2026-01-05T12:00:00Z Gym",
+			"2026-01-09T12:00:00Z Gym",
+			"2026-01-12T12:00:00Z Gym",
+			"2026-01-14T12:00:00Z Gym",
+		},
+		"monthly": {
+			"2026-01-31T09:00:00Z Rent",
+			"2026-02-28T09:00:00Z Rent",
+			"2026-03-31T09:00:00Z Rent",
+		},
+		// Daylight saving time starts on the 8th, and the walk stays at 9am.
+		"dst": {
+			"2026-03-06T14:00:00Z Walk",
+			"2026-03-07T14:00:00Z Walk",
+			"2026-03-08T13:00:00Z Walk",
+		},
+		// The second is moved and the third cancelled.
+		"override": {
+			"2026-01-06T23:00:00Z Piano",
+			"2026-01-14T23:00:00Z Piano (moved)",
+		},
+		"moved-in": {
+			"2026-01-08T18:00:00Z Lesson",
+			"2026-01-09T18:00:00Z Lesson (moved)",
+		},
+	} {
+		if len(got[uid]) != len(want) {
+			t.Errorf("%s: got %q, want %q", uid, got[uid], want)
+			continue
+		}
+		for i := range want {
+			if got[uid][i] != want[i] {
+				t.Errorf("%s: got %q, want %q", uid, got[uid], want)
+				break
+			}
+		}
+	}
+
+	// Only occurrences overlapping the span are returned, including one that
+	// started before it.
+	partial := cal.Expand(
+		time.Date(2026, 1, 6, 15, 10, 0, 0, time.UTC),
+		time.Date(2026, 1, 7, 0, 0, 0, 0, time.UTC),
+	)
+	var uids []string
+	for _, e := range partial {
+		uids = append(uids, e.UID+" "+e.RecurrenceID.UTC().Format(time.RFC3339))
+	}
+	want := []string{"count 2026-01-06T15:00:00Z", "override 2026-01-06T23:00:00Z"}
+	if len(uids) != len(want) || uids[0] != want[0] || uids[1] != want[1] {
+		t.Errorf("got %q overlapping the 6th, want %q", uids, want)
+	}
+
+	// An occurrence moved into the span is returned even though it was
+	// originally outside it.
+	moved := cal.Expand(
+		time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC),
+	)
+	var lessons []string
+	for _, e := range moved {
+		if e.UID == "moved-in" {
+			lessons = append(lessons, e.Summary)
+		}
+	}
+	if len(lessons) != 1 || lessons[0] != "Lesson (moved)" {
+		t.Errorf("got lessons %q on the 9th, want only the moved one", lessons)
+	}
+}
@@ -0,0 +1,477 @@
+package ics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeriods bounds how many periods of a recurrence rule are walked before
+// giving up, in case a rule is pathological.
+const maxPeriods = 1000000
+
+// Frequency is the FREQ part of a recurrence rule.
+type Frequency string
+
+const (
+	Secondly Frequency = "SECONDLY"
+	Minutely Frequency = "MINUTELY"
+	Hourly   Frequency = "HOURLY"
+	Daily    Frequency = "DAILY"
+	Weekly   Frequency = "WEEKLY"
+	Monthly  Frequency = "MONTHLY"
+	Yearly   Frequency = "YEARLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// WeekdayNum is one entry of a BYDAY list, like MO (every Monday), 2TU (the
+// second Tuesday) or -1FR (the last Friday).
+type WeekdayNum struct {
+	// Weekday is the day of the week.
+	Weekday time.Weekday
+	// N is which occurrence of Weekday within the month or year is meant,
+	// counting from the end if negative. Zero means every occurrence.
+	N int
+}
+
+func (w WeekdayNum) String() string {
+	s := strings.ToUpper(w.Weekday.String()[:2])
+	if w.N != 0 {
+		return strconv.Itoa(w.N) + s
+	}
+	return s
+}
+
+// RRule is a recurrence rule, as found in the RRULE property of an event.
+//
+// The BYHOUR, BYMINUTE, BYSECOND, BYYEARDAY and BYWEEKNO parts are not
+// supported and are ignored if present.
+type RRule struct {
+	// Freq is the unit of time the rule repeats in.
+	Freq Frequency
+	// Interval is how many units of Freq pass between repetitions. It is at
+	// least 1.
+	Interval int
+	// Count is the maximum number of occurrences, including the first, or 0 if
+	// unbounded.
+	Count int
+	// Until is the last instant an occurrence may start at, or the zero time if
+	// unbounded.
+	Until time.Time
+	// ByDay limits or expands occurrences to certain days of the week.
+	ByDay []WeekdayNum
+	// ByMonthDay limits or expands occurrences to certain days of the month.
+	// Negative days count from the end of the month.
+	ByMonthDay []int
+	// ByMonth limits or expands occurrences to certain months.
+	ByMonth []time.Month
+	// BySetPos picks occurrences by position within each period, counting from
+	// the end if negative.
+	BySetPos []int
+	// WeekStart is the day weeks start on. It defaults to Monday.
+	WeekStart time.Weekday
+}
+
+// ParseRRule parses the value of an RRULE property. Floating or date-only
+// UNTIL values are interpreted in loc.
+func ParseRRule(s string, loc *time.Location) (*RRule, error) {
+	r := RRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(v))
+			switch r.Freq {
+			case Secondly, Minutely, Hourly, Daily, Weekly, Monthly, Yearly:
+			default:
+				return nil, fmt.Errorf("unknown frequency %q", v)
+			}
+		case "INTERVAL":
+			if r.Interval, err = strconv.Atoi(v); err != nil || r.Interval < 1 {
+				return nil, fmt.Errorf("bad interval %q", v)
+			}
+		case "COUNT":
+			if r.Count, err = strconv.Atoi(v); err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("bad count %q", v)
+			}
+		case "UNTIL":
+			switch {
+			case len(v) == len(dateFormat):
+				day, err := time.ParseInLocation(dateFormat, v, loc)
+				if err != nil {
+					return nil, fmt.Errorf("bad until %q", v)
+				}
+				r.Until = day.AddDate(0, 0, 1).Add(-time.Second)
+			case strings.HasSuffix(v, "Z"):
+				if r.Until, err = time.Parse(utcDateTimeFormat, v); err != nil {
+					return nil, fmt.Errorf("bad until %q", v)
+				}
+			default:
+				if r.Until, err = time.ParseInLocation(dateTimeFormat, v, loc); err != nil {
+					return nil, fmt.Errorf("bad until %q", v)
+				}
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(v, ",") {
+				day = strings.ToUpper(strings.TrimSpace(day))
+				if len(day) < 2 {
+					return nil, fmt.Errorf("bad day %q", day)
+				}
+				weekday, ok := weekdays[day[len(day)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("bad day %q", day)
+				}
+				w := WeekdayNum{Weekday: weekday}
+				if n := day[:len(day)-2]; n != "" {
+					if w.N, err = strconv.Atoi(n); err != nil || w.N == 0 {
+						return nil, fmt.Errorf("bad day %q", day)
+					}
+				}
+				r.ByDay = append(r.ByDay, w)
+			}
+		case "BYMONTHDAY":
+			if r.ByMonthDay, err = parseInts(v, 31); err != nil {
+				return nil, fmt.Errorf("bad month day: %w", err)
+			}
+		case "BYMONTH":
+			months, err := parseInts(v, 12)
+			if err != nil {
+				return nil, fmt.Errorf("bad month: %w", err)
+			}
+			for _, m := range months {
+				if m < 1 {
+					return nil, fmt.Errorf("bad month %d", m)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "BYSETPOS":
+			if r.BySetPos, err = parseInts(v, 366); err != nil {
+				return nil, fmt.Errorf("bad set position: %w", err)
+			}
+		case "WKST":
+			weekday, ok := weekdays[strings.ToUpper(v)]
+			if !ok {
+				return nil, fmt.Errorf("bad week start %q", v)
+			}
+			r.WeekStart = weekday
+		}
+	}
+	if r.Freq == "" {
+		return nil, fmt.Errorf("no frequency in %q", s)
+	}
+	return &r, nil
+}
+
+// parseInts parses a comma-separated list of non-zero integers no further
+// than max from zero.
+func parseInts(s string, max int) ([]int, error) {
+	var ints []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n == 0 || n > max || n < -max {
+			return nil, fmt.Errorf("bad value %q", v)
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// String returns r in RRULE property value form.
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(utcDateTimeFormat))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, d := range r.ByDay {
+			days = append(days, d.String())
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		var months []int
+		for _, m := range r.ByMonth {
+			months = append(months, int(m))
+		}
+		parts = append(parts, "BYMONTH="+joinInts(months))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinInts(r.BySetPos))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(ints []int) string {
+	var s []string
+	for _, n := range ints {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ",")
+}
+
+// Occurrences returns the start of every occurrence of a series starting at
+// start that falls within [from, to). The first occurrence is always start
+// itself, and counts toward Count, even if start does not match the rule.
+func (r *RRule) Occurrences(start, from, to time.Time) []time.Time {
+	var (
+		out   []time.Time
+		count = 1
+	)
+	if !start.Before(from) && start.Before(to) {
+		out = append(out, start)
+	}
+
+	for k := 0; k < maxPeriods; k++ {
+		periodStart, candidates := r.period(start, k)
+		if !periodStart.Before(to) {
+			break
+		}
+		if !r.Until.IsZero() && periodStart.After(r.Until) {
+			break
+		}
+		for _, c := range candidates {
+			if !c.After(start) {
+				continue
+			}
+			if !r.Until.IsZero() && c.After(r.Until) {
+				return out
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return out
+			}
+			if !c.Before(to) {
+				return out
+			}
+			if !c.Before(from) {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// period returns the start of the kth period of the series starting at start,
+// along with the sorted candidate occurrences within it.
+func (r *RRule) period(start time.Time, k int) (time.Time, []time.Time) {
+	var (
+		loc          = start.Location()
+		y, mo, d     = start.Date()
+		h, mi, s     = start.Clock()
+		periodStart  time.Time
+		days         []time.Time
+		subDailyStep time.Duration
+	)
+	at := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, mi, s, start.Nanosecond(), loc)
+	}
+
+	switch r.Freq {
+	case Secondly:
+		subDailyStep = time.Second
+	case Minutely:
+		subDailyStep = time.Minute
+	case Hourly:
+		subDailyStep = time.Hour
+	case Daily:
+		periodStart = time.Date(y, mo, d+k*r.Interval, 0, 0, 0, 0, loc)
+		if r.matchesMonth(periodStart) && r.matchesMonthDay(periodStart) && r.matchesWeekday(periodStart) {
+			days = append(days, periodStart)
+		}
+	case Weekly:
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		periodStart = time.Date(y, mo, d-offset+k*r.Interval*7, 0, 0, 0, 0, loc)
+		for i := 0; i < 7; i++ {
+			day := periodStart.AddDate(0, 0, i)
+			if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		periodStart = time.Date(y, mo+time.Month(k*r.Interval), 1, 0, 0, 0, 0, loc)
+		if r.matchesMonth(periodStart) {
+			days = r.monthDays(periodStart, d)
+		}
+	case Yearly:
+		periodStart = time.Date(y+k*r.Interval, time.January, 1, 0, 0, 0, 0, loc)
+		switch {
+		case len(r.ByMonth) > 0:
+			for _, m := range r.ByMonth {
+				days = append(days, r.monthDays(time.Date(periodStart.Year(), m, 1, 0, 0, 0, 0, loc), d)...)
+			}
+		case len(r.ByMonthDay) > 0:
+			for m := time.January; m <= time.December; m++ {
+				days = append(days, r.monthDays(time.Date(periodStart.Year(), m, 1, 0, 0, 0, 0, loc), d)...)
+			}
+		case len(r.ByDay) > 0:
+			days = r.weekdaysIn(periodStart, periodStart.AddDate(1, 0, 0))
+		default:
+			day := time.Date(periodStart.Year(), mo, d, 0, 0, 0, 0, loc)
+			if day.Day() == d {
+				days = append(days, day)
+			}
+		}
+	}
+
+	var candidates []time.Time
+	if subDailyStep != 0 {
+		c := start.Add(time.Duration(k*r.Interval) * subDailyStep)
+		if r.matchesMonth(c) && r.matchesMonthDay(c) && r.matchesWeekday(c) {
+			candidates = append(candidates, c)
+		}
+		return c, candidates
+	}
+
+	for _, day := range days {
+		candidates = append(candidates, at(day))
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	return periodStart, r.setPos(candidates)
+}
+
+// monthDays returns the days in the month starting at first that match the
+// rule's BYMONTHDAY and BYDAY parts. If it has neither, the day of the month
+// the series started on is used.
+func (r *RRule) monthDays(first time.Time, startDay int) []time.Time {
+	next := first.AddDate(0, 1, 0)
+	last := next.AddDate(0, 0, -1).Day()
+
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = last + 1 + d
+			}
+			if d < 1 || d > last {
+				continue
+			}
+			day := first.AddDate(0, 0, d-1)
+			if r.matchesWeekday(day) {
+				days = append(days, day)
+			}
+		}
+	case len(r.ByDay) > 0:
+		days = r.weekdaysIn(first, next)
+	default:
+		if startDay <= last {
+			days = append(days, first.AddDate(0, 0, startDay-1))
+		}
+	}
+	return days
+}
+
+// weekdaysIn returns the days in [from, to) matched by the rule's BYDAY part,
+// honoring ordinals like 2MO or -1FR relative to the span.
+func (r *RRule) weekdaysIn(from, to time.Time) []time.Time {
+	var days []time.Time
+	for _, w := range r.ByDay {
+		var matches []time.Time
+		for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+			if day.Weekday() == w.Weekday {
+				matches = append(matches, day)
+			}
+		}
+		switch {
+		case w.N == 0:
+			days = append(days, matches...)
+		case w.N > 0 && w.N <= len(matches):
+			days = append(days, matches[w.N-1])
+		case w.N < 0 && -w.N <= len(matches):
+			days = append(days, matches[len(matches)+w.N])
+		}
+	}
+	return days
+}
+
+func (r *RRule) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if t.Month() == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || d < 0 && last+1+d == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday reports whether t falls on one of the rule's BYDAY weekdays,
+// ignoring ordinals.
+func (r *RRule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, w := range r.ByDay {
+		if t.Weekday() == w.Weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// setPos applies the rule's BYSETPOS part to the sorted candidates of one
+// period.
+func (r *RRule) setPos(candidates []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return candidates
+	}
+	var picked []time.Time
+	for _, pos := range r.BySetPos {
+		switch {
+		case pos > 0 && pos <= len(candidates):
+			picked = append(picked, candidates[pos-1])
+		case pos < 0 && -pos <= len(candidates):
+			picked = append(picked, candidates[len(candidates)+pos])
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Before(picked[j]) })
+	return picked
+}
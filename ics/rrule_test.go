@@ -0,0 +1,104 @@
+package ics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRRule(t *testing.T) {
+	for _, c := range []struct {
+		rule string
+		want RRule
+	}{
+		{
+			rule: "FREQ=DAILY;COUNT=3",
+			want: RRule{Freq: Daily, Interval: 1, Count: 3, WeekStart: time.Monday},
+		},
+		{
+			rule: "FREQ=WEEKLY;INTERVAL=2;UNTIL=20260116T100000Z;BYDAY=MO,WE,FR;WKST=SU",
+			want: RRule{
+				Freq:      Weekly,
+				Interval:  2,
+				Until:     time.Date(2026, 1, 16, 10, 0, 0, 0, time.UTC),
+				ByDay:     []WeekdayNum{{Weekday: time.Monday}, {Weekday: time.Wednesday}, {Weekday: time.Friday}},
+				WeekStart: time.Sunday,
+			},
+		},
+		{
+			rule: "FREQ=MONTHLY;BYDAY=-1FR;BYMONTH=3,6",
+			want: RRule{
+				Freq:      Monthly,
+				Interval:  1,
+				ByDay:     []WeekdayNum{{Weekday: time.Friday, N: -1}},
+				ByMonth:   []time.Month{time.March, time.June},
+				WeekStart: time.Monday,
+			},
+		},
+		{
+			rule: "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			want: RRule{
+				Freq:     Monthly,
+				Interval: 1,
+				ByDay: []WeekdayNum{
+					{Weekday: time.Monday},
+					{Weekday: time.Tuesday},
+					{Weekday: time.Wednesday},
+					{Weekday: time.Thursday},
+					{Weekday: time.Friday},
+				},
+				BySetPos:  []int{-1},
+				WeekStart: time.Monday,
+			},
+		},
+	} {
+		t.Run(c.rule, func(t *testing.T) {
+			got, err := ParseRRule(c.rule, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, c.want) {
+				t.Errorf("got %+v, want %+v", *got, c.want)
+			}
+			again, err := ParseRRule(got.String(), time.UTC)
+			if err != nil {
+				t.Fatalf("can't parse %s again: %v", got.String(), err)
+			}
+			if !reflect.DeepEqual(again, got) {
+				t.Errorf("got %+v after a round trip through %s, want %+v", *again, got.String(), *got)
+			}
+		})
+	}
+
+	for _, rule := range []string{"", "COUNT=3", "FREQ=DAILY;COUNT=x", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;INTERVAL=0"} {
+		if _, err := ParseRRule(rule, time.UTC); err == nil {
+			t.Errorf("parsed %q without error", rule)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		rule string
+		want []string
+	}{
+		{"FREQ=DAILY;INTERVAL=2;COUNT=3", []string{"2026-01-01", "2026-01-03", "2026-01-05"}},
+		// The last weekday of each month.
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3", []string{"2026-01-01", "2026-01-30", "2026-02-27"}},
+		{"FREQ=YEARLY;COUNT=2", []string{"2026-01-01", "2027-01-01"}},
+		{"FREQ=WEEKLY;BYDAY=TH;UNTIL=20260115", []string{"2026-01-01", "2026-01-08", "2026-01-15"}},
+	} {
+		r, err := ParseRRule(c.rule, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range r.Occurrences(start, start, start.AddDate(2, 0, 0)) {
+			got = append(got, o.Format("2006-01-02"))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.rule, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// zoneRulesEnd is the year observances in a VTIMEZONE are expanded to.
+const zoneRulesEnd = 2100
+
+// observance is one STANDARD or DAYLIGHT sub-component of a VTIMEZONE.
+type observance struct {
+	name   string
+	offset int
+	from   int
+	isDST  bool
+	onsets []time.Time
+}
+
+// zoneTransition is the instant a VTIMEZONE switches to an observance.
+type zoneTransition struct {
+	at  time.Time
+	obs *observance
+}
+
+// vtimezoneLocation builds a location from the STANDARD and DAYLIGHT
+// observances of a VTIMEZONE, expanding their RRULEs and RDATEs into
+// transitions up to zoneRulesEnd.
+func vtimezoneLocation(tzid string, c *component) (*time.Location, error) {
+	var (
+		observances []*observance
+		transitions []zoneTransition
+	)
+	for _, child := range c.children {
+		if child.name != "STANDARD" && child.name != "DAYLIGHT" {
+			continue
+		}
+		o, err := parseObservance(child)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", child.name, err)
+		}
+		observances = append(observances, o)
+		for _, onset := range o.onsets {
+			transitions = append(transitions, zoneTransition{at: onset, obs: o})
+		}
+	}
+	if len(observances) == 0 {
+		return nil, errors.New("no STANDARD or DAYLIGHT observances")
+	}
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].at.Before(transitions[j].at)
+	})
+	return time.LoadLocationFromTZData(tzid, tzData(observances, transitions))
+}
+
+// parseObservance parses a STANDARD or DAYLIGHT component, returning the UTC
+// instants at which it takes effect.
+func parseObservance(c *component) (*observance, error) {
+	o := &observance{isDST: c.name == "DAYLIGHT"}
+	var err error
+	to, ok := c.get("TZOFFSETTO")
+	if !ok {
+		return nil, errors.New("no TZOFFSETTO")
+	}
+	if o.offset, err = parseUTCOffset(to.value); err != nil {
+		return nil, err
+	}
+	o.from = o.offset
+	if from, ok := c.get("TZOFFSETFROM"); ok {
+		if o.from, err = parseUTCOffset(from.value); err != nil {
+			return nil, err
+		}
+	}
+	o.name = to.value
+	if name, ok := c.get("TZNAME"); ok && name.value != "" {
+		o.name = unescapeText(name.value)
+	}
+
+	// Onsets are local times in the offset being switched from. Expand them as
+	// wall clock times in UTC, then shift them to the real instant.
+	dtstart, ok := c.get("DTSTART")
+	if !ok {
+		return nil, errors.New("no DTSTART")
+	}
+	start, err := time.Parse(dateTimeFormat, dtstart.value)
+	if err != nil {
+		return nil, err
+	}
+	wall := []time.Time{start}
+	if rrule, ok := c.get("RRULE"); ok {
+		r, err := ParseRRule(rrule.value, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		if utcUntil(rrule.value) {
+			r.Until = r.Until.Add(time.Duration(o.from) * time.Second)
+		}
+		end := time.Date(zoneRulesEnd, 1, 1, 0, 0, 0, 0, time.UTC)
+		wall = r.Occurrences(start, start, end)
+	}
+	for _, rdate := range c.properties {
+		if rdate.name != "RDATE" {
+			continue
+		}
+		for _, v := range strings.Split(rdate.value, ",") {
+			t, err := time.Parse(dateTimeFormat, v)
+			if err != nil {
+				return nil, err
+			}
+			wall = append(wall, t)
+		}
+	}
+	for _, t := range wall {
+		o.onsets = append(o.onsets, t.Add(-time.Duration(o.from)*time.Second))
+	}
+	return o, nil
+}
+
+// utcUntil reports whether the UNTIL of rule is a UTC time, which must be
+// compared with onsets in local time.
+func utcUntil(rule string) bool {
+	for _, part := range strings.Split(rule, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		if strings.EqualFold(name, "UNTIL") {
+			return strings.HasSuffix(value, "Z")
+		}
+	}
+	return false
+}
+
+// tzData encodes observances and their transitions in the TZif version 2
+// format read by time.LoadLocationFromTZData.
+func tzData(observances []*observance, transitions []zoneTransition) []byte {
+	var (
+		index   = map[*observance]int{}
+		abbrevs []byte
+		types   []byte
+	)
+	// Times before the first transition use the first type, so put a standard
+	// observance first.
+	sort.SliceStable(observances, func(i, j int) bool {
+		return !observances[i].isDST && observances[j].isDST
+	})
+	for i, o := range observances {
+		index[o] = i
+		var isDST byte
+		if o.isDST {
+			isDST = 1
+		}
+		types = binary.BigEndian.AppendUint32(types, uint32(int32(o.offset)))
+		types = append(types, isDST, byte(len(abbrevs)))
+		abbrevs = append(abbrevs, o.name...)
+		abbrevs = append(abbrevs, 0)
+	}
+
+	var buf bytes.Buffer
+	header := func(timecnt, typecnt, charcnt int) {
+		buf.WriteString("TZif2")
+		buf.Write(make([]byte, 15))
+		for _, n := range []int{0, 0, 0, timecnt, typecnt, charcnt} {
+			binary.Write(&buf, binary.BigEndian, uint32(n))
+		}
+	}
+	// An empty version 1 block, followed by the 64-bit version 2 data.
+	header(0, 0, 0)
+	header(len(transitions), len(observances), len(abbrevs))
+	for _, t := range transitions {
+		binary.Write(&buf, binary.BigEndian, t.at.Unix())
+	}
+	for _, t := range transitions {
+		buf.WriteByte(byte(index[t.obs]))
+	}
+	buf.Write(types)
+	buf.Write(abbrevs)
+	buf.WriteString("\n\n")
+	return buf.Bytes()
+}
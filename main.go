@@ -1,15 +1,18 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"twos.dev/mainframe/auth"
 	_ "twos.dev/mainframe/coldbrewcrew/iworkout"
 	"twos.dev/mainframe/db"
 	"twos.dev/mainframe/pottytrainer"
+	"twos.dev/mainframe/secrets"
 	"twos.dev/mainframe/web"
 )
 
@@ -21,6 +24,14 @@ var (
 		false,
 		"runs in debug mode (frequent crons)",
 	)
+	dbFlag = flag.String(
+		"db",
+		"",
+		"path to the SQLite database (default $MAINFRAME_DB_PATH, or mainframe/mainframe.db under $XDG_DATA_HOME)",
+	)
+
+	// dbPath is where the database lives. It's set before anything opens it.
+	dbPath string
 )
 
 func main() {
@@ -33,27 +44,73 @@ func main() {
 		return
 	}
 
+	var err error
+	if dbPath, err = databasePath(logger); err != nil {
+		logger.Fatalf("database error: %v", err)
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(logger, flag.Args()); err != nil {
+			logger.Fatalf("%s error: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
 	logger.Printf("Booting mainframe %s", version)
-	db, err := db.New(logger, "mainframe")
+	db, err := db.New(logger, dbPath)
 	if err != nil {
 		logger.Fatalf("database error: %v", err)
 	}
 
-	mux, err := web.Start(logger, version)
+	keys, err := secrets.FromEnv()
+	if err != nil {
+		logger.Fatalf("secrets error: %v", err)
+	}
+	if !keys.Enabled() {
+		logger.Printf(
+			"WARNING: MAINFRAME_SECRET_KEY is not set, so OAuth tokens are being stored in plaintext. " +
+				"Generate a key with `mainframe secrets generate`, set it, then run `mainframe secrets rotate` to encrypt them.",
+		)
+	}
+
+	sessions, err := auth.New(logger, db)
+	if err != nil {
+		logger.Fatalf("auth error: %v", err)
+	}
+
+	webConfig, err := web.ConfigFromEnv()
+	if err != nil {
+		logger.Fatalf("web config error: %v", err)
+	}
+	sessions.Secure = webConfig.Secure()
+
+	mux, err := web.Start(logger, version, webConfig, sessions.Middleware)
 	if err != nil {
 		logger.Fatalf("web error: %v", err)
 	}
+	mux.HandleFunc("/logout", sessions.Logout)
 
-	google, err := newGoogleClient(logger, db, mux)
+	google, err := newGoogleClient(logger, db, mux, sessions, keys)
 	if err != nil {
 		logger.Fatalf("gcp client error: %v", err)
 	}
 
+	handleAccount(logger, db, mux, sessions, google)
+	handleCalendarFeed(logger, db, mux)
+	handleAgenda(logger, db, mux)
+	handleAvailability(logger, db, mux)
+	handleCrons(logger, db, mux, version, google)
+
+	pottyDB, err := pottytrainer.NewStore(logger, db)
+	if err != nil {
+		logger.Fatalf("potty trainer error: %v", err)
+	}
 	pottyMux := http.NewServeMux()
 	mux.Handle("/potty/", http.StripPrefix("/potty", pottyMux))
-	if err := pottytrainer.Run(logger, pottyMux); err != nil {
+	if err := pottytrainer.Run(logger, pottyMux, pottyDB); err != nil {
 		logger.Fatalf("potty trainer error: %v", err)
 	}
+	handleExport(logger, db, mux)
 
 	if err := startCron(logger, db, version, mux, google); err != nil {
 		logger.Fatalf("cron error: %v", err)
@@ -62,3 +119,33 @@ func main() {
 	logger.Println("Mainframe booted")
 	select {}
 }
+
+// databasePath returns where the database lives: the -db flag if given, else
+// MAINFRAME_DB_PATH, else db.DefaultPath.
+//
+// Mainframe used to keep its database in the working directory, so if that's
+// where one is and the default has none, it's used instead, with a warning.
+func databasePath(logger *log.Logger) (string, error) {
+	if *dbFlag != "" {
+		return *dbFlag, nil
+	}
+	if path := os.Getenv("MAINFRAME_DB_PATH"); path != "" {
+		return path, nil
+	}
+
+	path, err := db.DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(legacyDBPath); err == nil {
+			logger.Printf(
+				"Using %s from the working directory; move it to %s, or set MAINFRAME_DB_PATH to keep it where it is",
+				legacyDBPath,
+				path,
+			)
+			return legacyDBPath, nil
+		}
+	}
+	return path, nil
+}
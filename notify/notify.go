@@ -0,0 +1,198 @@
+// Package notify delivers messages from mainframe to the people it works for,
+// through whichever channels are configured.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// discordMessageLimit is the most characters Discord accepts in one message.
+	discordMessageLimit = 2000
+)
+
+// Message is something worth telling someone about. Channels that can show
+// HTML use HTML; the rest use Text.
+type Message struct {
+	// Subject is a one-line summary of the message.
+	Subject string
+	// Text is the plain text body of the message.
+	Text string
+	// HTML is the HTML body of the message. It may be empty.
+	HTML string
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// FromEnv returns a Notifier that delivers through every channel configured in
+// the environment:
+//
+//   - NOTIFY_SMTP_ADDR, NOTIFY_SMTP_USERNAME, NOTIFY_SMTP_PASSWORD,
+//     NOTIFY_EMAIL_FROM and NOTIFY_EMAIL_TO (comma-separated) for email
+//   - NOTIFY_DISCORD_WEBHOOK_URL for a Discord channel
+//
+// If no channel is configured, messages are written to logger.
+func FromEnv(logger *log.Logger) Notifier {
+	var multi Multi
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		multi = append(multi, SMTP{
+			Addr:     addr,
+			Username: os.Getenv("NOTIFY_SMTP_USERNAME"),
+			Password: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			From:     os.Getenv("NOTIFY_EMAIL_FROM"),
+			To:       strings.Split(os.Getenv("NOTIFY_EMAIL_TO"), ","),
+		})
+	}
+	if url := os.Getenv("NOTIFY_DISCORD_WEBHOOK_URL"); url != "" {
+		multi = append(multi, DiscordWebhook{URL: url})
+	}
+	if len(multi) == 0 {
+		return Log{Logger: logger}
+	}
+	return multi
+}
+
+// Multi delivers messages through every one of its notifiers. A failure of one
+// does not stop delivery through the rest.
+type Multi []Notifier
+
+// Notify implements Notifier.
+func (m Multi) Notify(ctx context.Context, msg Message) error {
+	var errs []string
+	for _, n := range m {
+		if err := n.Notify(ctx, msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Log writes messages to a logger. It is useful in development, and as a last
+// resort when nothing else is configured.
+type Log struct {
+	Logger *log.Logger
+}
+
+// Notify implements Notifier.
+func (l Log) Notify(_ context.Context, m Message) error {
+	l.Logger.Printf("%s\n%s", m.Subject, m.Text)
+	return nil
+}
+
+// SMTP sends messages as email.
+type SMTP struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// Username and Password authenticate to the SMTP server using PLAIN auth.
+	// If Username is empty, no authentication is attempted.
+	Username string
+	Password string
+	// From is the sender address.
+	From string
+	// To are the recipient addresses.
+	To []string
+}
+
+// Notify implements Notifier.
+func (s SMTP) Notify(_ context.Context, m Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("bad SMTP address %s: %w", s.Addr, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fmt.Fprintf(&body, "From: %s\r\n", s.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct{ contentType, content string }{{"text/plain", m.Text}}
+	if m.HTML != "" {
+		parts = append(parts, struct{ contentType, content string }{"text/html", m.HTML})
+	}
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return fmt.Errorf("can't build email: %w", err)
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return fmt.Errorf("can't build email: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return fmt.Errorf("can't build email: %w", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("can't build email: %w", err)
+	}
+
+	if err := smtp.SendMail(s.Addr, auth, s.From, s.To, body.Bytes()); err != nil {
+		return fmt.Errorf("can't send email: %w", err)
+	}
+	return nil
+}
+
+// DiscordWebhook posts messages to a Discord channel through a webhook.
+type DiscordWebhook struct {
+	// URL is the webhook URL, from the channel's integration settings.
+	URL string
+}
+
+// Notify implements Notifier.
+func (d DiscordWebhook) Notify(ctx context.Context, m Message) error {
+	content := fmt.Sprintf("**%s**\n%s", m.Subject, m.Text)
+	if runes := []rune(content); len(runes) > discordMessageLimit {
+		content = string(runes[:discordMessageLimit-1]) + "…"
+	}
+	body, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return fmt.Errorf("can't encode Discord message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't build Discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't post to Discord: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d posting to Discord", resp.StatusCode)
+	}
+	return nil
+}
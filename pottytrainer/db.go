@@ -2,19 +2,76 @@ package pottytrainer
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"twos.dev/mainframe/ddb"
 )
 
-func LogPoop(ctx context.Context, db *ddb.Client, userID string, poopedAt time.Time, bad bool) error {
-	db.Poops.Put(&ddb.Poop{
-		ID:        uuid.New().String(),
-		UserID:    "test",
-		PoopedAt:  poopedAt,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}).Run()
-	return nil
+const (
+	// maxBristol is the highest type on the Bristol stool scale.
+	maxBristol = 7
+	// maxNotesLength is the most characters a poop's notes can have.
+	maxNotesLength = 1000
+)
+
+// LogEats records that the given user ate each of foods at ateAt, as one Eat
+// per food, all or none of them. Each food is resolved to one of the user's
+// Foods by name, creating it if it's new, so none may be blank. Foods created
+// are kept even if the eats can't be logged, and reused by the next try.
+func LogEats(ctx context.Context, db ddb.Store, userID string, ateAt time.Time, foods []string) ([]ddb.Eat, error) {
+	resolver, err := newFoodResolver(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	eats := make([]ddb.Eat, 0, len(foods))
+	for _, text := range foods {
+		foodID, err := resolver.resolve(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		eats = append(eats, ddb.Eat{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			FoodID:    foodID,
+			FoodText:  text,
+			AteAt:     ateAt,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	if err := db.PutEats(ctx, eats); err != nil {
+		return nil, fmt.Errorf("can't log eats: %w", err)
+	}
+	return eats, nil
+}
+
+// LogPoop records p as a new poop of the given user. p's quality, Bristol type
+// and notes must be valid; see validatePoop.
+func LogPoop(ctx context.Context, db ddb.Store, userID string, p ddb.Poop) (*ddb.Poop, error) {
+	if err := validatePoop(p); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p.ID = uuid.New().String()
+	p.UserID = userID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	if err := db.PutPoop(ctx, &p); err != nil {
+		return nil, fmt.Errorf("can't log poop: %w", err)
+	}
+	return &p, nil
+}
+
+// validatePoop returns an error describing what's wrong with p's user-reported
+// fields, if anything.
+func validatePoop(p ddb.Poop) error {
+	return poopPatch{
+		PoopedAt: &p.PoopedAt,
+		Quality:  &p.Quality,
+		Bristol:  &p.Bristol,
+		Notes:    &p.Notes,
+	}.validate()
 }
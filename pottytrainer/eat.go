@@ -0,0 +1,210 @@
+package pottytrainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"twos.dev/mainframe/ddb"
+)
+
+type eatRequest struct {
+	// Foods are the texts of the foods eaten, like "coffee cake". Each becomes
+	// its own Eat.
+	Foods []string `json:"foods"`
+	// AteAt is when they were eaten. It defaults to now.
+	AteAt time.Time `json:"ate_at"`
+}
+
+// eatPatch is a change to an Eat. Fields left out are left alone.
+type eatPatch struct {
+	Food  *string    `json:"food"`
+	AteAt *time.Time `json:"ate_at"`
+}
+
+type eatResponse struct {
+	ID        string    `json:"id"`
+	FoodID    string    `json:"food_id"`
+	FoodText  string    `json:"food_text"`
+	AteAt     time.Time `json:"ate_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Foods are the IDs of every food eaten: FoodID and all of its
+	// ingredients, theirs, and so on.
+	Foods []string `json:"foods"`
+}
+
+type eatsResponse struct {
+	Eats []eatResponse `json:"eats"`
+	// Next is the cursor of the next page, if there is one.
+	Next string `json:"next,omitempty"`
+}
+
+func newEatResponse(e ddb.Eat, g *foodGraph) eatResponse {
+	return eatResponse{
+		ID:        e.ID,
+		FoodID:    e.FoodID,
+		FoodText:  e.FoodText,
+		AteAt:     e.AteAt,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+		Foods:     g.expandEat(e),
+	}
+}
+
+func newEatsResponse(eats []ddb.Eat, next string, g *foodGraph) eatsResponse {
+	resp := eatsResponse{Eats: make([]eatResponse, 0, len(eats)), Next: next}
+	for _, e := range eats {
+		resp.Eats = append(resp.Eats, newEatResponse(e, g))
+	}
+	return resp
+}
+
+// eatHandler handles the /eat route in the /api/v1 namespace. GET lists the
+// user's eats, newest first, and POST logs new ones. It implements
+// customHandler.
+func eatHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	g, err := newFoodGraph(ctx, db, user.ID)
+	if err != nil {
+		logger.Printf("can't get food graph: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := parseTimeQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := db.QueryEats(ctx, user.ID, q)
+		if errors.Is(err, ddb.ErrBadCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.Printf("can't list eats: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, logger, http.StatusOK, newEatsResponse(page.Items, page.Next, g))
+
+	case http.MethodPost:
+		var e eatRequest
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(e.Foods) == 0 {
+			http.Error(w, "foods must not be empty", http.StatusBadRequest)
+			return
+		}
+		if len(e.Foods) > ddb.MaxPutEats {
+			http.Error(w, fmt.Sprintf("foods must number at most %d", ddb.MaxPutEats), http.StatusBadRequest)
+			return
+		}
+		for _, text := range e.Foods {
+			if normalizeFoodName(text) == "" {
+				http.Error(w, "foods must not be blank", http.StatusBadRequest)
+				return
+			}
+		}
+		if e.AteAt.IsZero() {
+			e.AteAt = time.Now()
+		}
+
+		eats, err := LogEats(ctx, db, user.ID, e.AteAt, e.Foods)
+		if err != nil {
+			logger.Printf("can't log eats: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, logger, http.StatusCreated, newEatsResponse(eats, "", g))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// eatItemHandler handles the /eat/{id} route in the /api/v1 namespace. GET
+// returns the eat, PATCH changes its food or time, and DELETE deletes it. It
+// implements customHandler.
+func eatItemHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	e, err := db.Eat(ctx, pathID(r, "/eat/"))
+	if errors.Is(err, ddb.ErrNotFound) || err == nil && e.UserID != user.ID {
+		http.Error(w, "no such eat", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Printf("can't get eat: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	g, err := newFoodGraph(ctx, db, user.ID)
+	if err != nil {
+		logger.Printf("can't get food graph: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, logger, http.StatusOK, newEatResponse(*e, g))
+
+	case http.MethodPatch:
+		var patch eatPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.Food != nil {
+			if normalizeFoodName(*patch.Food) == "" {
+				http.Error(w, "food must not be blank", http.StatusBadRequest)
+				return
+			}
+			resolver, err := newFoodResolver(ctx, db, user.ID)
+			if err != nil {
+				logger.Printf("can't resolve food: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if e.FoodID, err = resolver.resolve(ctx, *patch.Food); err != nil {
+				logger.Printf("can't resolve food: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			e.FoodText = *patch.Food
+		}
+		if patch.AteAt != nil {
+			if patch.AteAt.IsZero() {
+				http.Error(w, "ate_at must not be zero", http.StatusBadRequest)
+				return
+			}
+			e.AteAt = *patch.AteAt
+		}
+		e.UpdatedAt = time.Now()
+		if err := db.PutEat(ctx, e); err != nil {
+			logger.Printf("can't update eat: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, logger, http.StatusOK, newEatResponse(*e, g))
+
+	case http.MethodDelete:
+		if err := db.DeleteEat(ctx, e.ID); err != nil {
+			logger.Printf("can't delete eat: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,130 @@
+package pottytrainer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/ddb"
+)
+
+// newTestServer returns a server running the API over an in-memory store, with
+// users u1 and u2 whose tokens are their IDs.
+func newTestServer(t *testing.T) (*httptest.Server, ddb.Store) {
+	ctx := context.Background()
+	db := ddb.NewMemory()
+	for _, id := range []string{"u1", "u2"} {
+		if err := db.PutUser(ctx, &ddb.User{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.PutToken(ctx, &ddb.APIToken{ID: id, UserID: id, Token: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	if err := Run(log.New(io.Discard, "", 0), mux, db); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, db
+}
+
+// do makes a request to the test server as user, decoding the response into
+// out if it isn't nil, and returns its status.
+func do(t *testing.T, server *httptest.Server, user, method, path, body string, out any) int {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+user)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestEatAPI(t *testing.T) {
+	server, db := newTestServer(t)
+
+	var created eatsResponse
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/eat",
+		`{"foods": ["Coffee Cake", "eggs", "coffee  cake"], "ate_at": "2026-01-02T08:00:00Z"}`,
+		&created,
+	); status != http.StatusCreated {
+		t.Fatalf("got status %d logging eats, want %d", status, http.StatusCreated)
+	}
+	if len(created.Eats) != 3 {
+		t.Fatalf("logged %d eats, want 3", len(created.Eats))
+	}
+	if created.Eats[0].FoodText != "Coffee Cake" {
+		t.Errorf("got food text %q, want the text as sent", created.Eats[0].FoodText)
+	}
+	if created.Eats[0].FoodID != created.Eats[2].FoodID || created.Eats[0].FoodID == created.Eats[1].FoodID {
+		t.Errorf("got food IDs %+v, want the same food for both coffee cakes only", created.Eats)
+	}
+	foods, err := db.Foods(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(foods) != 2 {
+		t.Errorf("created %d foods, want 2", len(foods))
+	}
+
+	// A second time, the names resolve to the foods from the first.
+	var again eatsResponse
+	do(t, server, "u1", http.MethodPost, "/api/v1/eat", `{"foods": ["eggs"]}`, &again)
+	if again.Eats[0].FoodID != created.Eats[1].FoodID {
+		t.Errorf("got a new food for eggs, want the existing one")
+	}
+
+	var page eatsResponse
+	do(t, server, "u1", http.MethodGet, "/api/v1/eat?to=2026-01-03T00:00:00Z&limit=2", "", &page)
+	if len(page.Eats) != 2 || page.Next == "" {
+		t.Errorf("got %d eats and next %q, want 2 and a next page", len(page.Eats), page.Next)
+	}
+
+	eggs := "/api/v1/eat/" + created.Eats[1].ID
+	var patched eatResponse
+	if status := do(t, server, "u1", http.MethodPatch, eggs, `{"food": "toast", "ate_at": "2026-01-02T09:00:00Z"}`, &patched); status != http.StatusOK {
+		t.Fatalf("got status %d patching an eat, want %d", status, http.StatusOK)
+	}
+	if patched.FoodText != "toast" || patched.FoodID == created.Eats[1].FoodID ||
+		!patched.AteAt.Equal(time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v after patching, want toast at 9:00", patched)
+	}
+
+	if status := do(t, server, "u2", http.MethodGet, eggs, "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d getting another user's eat, want %d", status, http.StatusNotFound)
+	}
+	if status := do(t, server, "u2", http.MethodDelete, eggs, "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d deleting another user's eat, want %d", status, http.StatusNotFound)
+	}
+	if status := do(t, server, "u1", http.MethodDelete, eggs, "", nil); status != http.StatusNoContent {
+		t.Errorf("got status %d deleting an eat, want %d", status, http.StatusNoContent)
+	}
+	if status := do(t, server, "u1", http.MethodGet, eggs, "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d getting a deleted eat, want %d", status, http.StatusNotFound)
+	}
+
+	tooMany := `{"foods": ["x"` + strings.Repeat(`, "x"`, ddb.MaxPutEats) + `]}`
+	for _, body := range []string{`{"foods": []}`, `{"foods": [" "]}`, tooMany} {
+		if status := do(t, server, "u1", http.MethodPost, "/api/v1/eat", body, nil); status != http.StatusBadRequest {
+			t.Errorf("got status %d logging %s, want %d", status, body, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package pottytrainer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"twos.dev/mainframe/ddb"
+)
+
+// normalizeFoodName returns the form of text that food names are stored and
+// matched in, so "Coffee  Cake" and "coffee cake" are the same food.
+func normalizeFoodName(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// foodResolver resolves the text of a food a user ate to one of their Foods,
+// through the names they've used for it.
+type foodResolver struct {
+	db     ddb.Store
+	userID string
+	// foodIDs maps each of the user's normalized food names to its food's ID.
+	foodIDs map[string]string
+}
+
+// newFoodResolver returns a foodResolver for the given user's foods.
+func newFoodResolver(ctx context.Context, db ddb.Store, userID string) (*foodResolver, error) {
+	names, err := db.FoodNames(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get food names: %w", err)
+	}
+	foodIDs := map[string]string{}
+	for _, n := range names {
+		foodIDs[normalizeFoodName(n.Name)] = n.FoodID
+	}
+	return &foodResolver{db: db, userID: userID, foodIDs: foodIDs}, nil
+}
+
+// lookup returns the ID of the food text names, if the user has used that name.
+func (f *foodResolver) lookup(text string) (string, bool) {
+	id, ok := f.foodIDs[normalizeFoodName(text)]
+	return id, ok
+}
+
+// resolve returns the ID of the food text names. If the user has never used
+// that name, resolve creates a new Food and a FoodName for it.
+func (f *foodResolver) resolve(ctx context.Context, text string) (string, error) {
+	name := normalizeFoodName(text)
+	if name == "" {
+		return "", fmt.Errorf("food name must not be blank")
+	}
+	if id, ok := f.foodIDs[name]; ok {
+		return id, nil
+	}
+
+	now := time.Now()
+	food := ddb.Food{
+		ID:        uuid.New().String(),
+		UserID:    f.userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := f.db.PutFood(ctx, &food); err != nil {
+		return "", fmt.Errorf("can't create food %s: %w", name, err)
+	}
+	if err := f.db.PutFoodName(ctx, &ddb.FoodName{
+		ID:        uuid.New().String(),
+		UserID:    f.userID,
+		FoodID:    food.ID,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		return "", fmt.Errorf("can't name food %s: %w", name, err)
+	}
+	f.foodIDs[name] = food.ID
+	return food.ID, nil
+}
+
+type foodNameResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ingredientResponse struct {
+	ID string `json:"id"`
+	// FoodID is the ID of the food that contains the ingredient.
+	FoodID string `json:"food_id"`
+	// IngredientID is the ID of the food that is the ingredient.
+	IngredientID string `json:"ingredient_id"`
+}
+
+type foodResponse struct {
+	ID    string             `json:"id"`
+	Names []foodNameResponse `json:"names"`
+	// Ingredients are the food's direct ingredients.
+	Ingredients []ingredientResponse `json:"ingredients"`
+	// Contains are the IDs of every food this one contains: its ingredients,
+	// theirs, and so on.
+	Contains []string `json:"contains"`
+}
+
+type foodsResponse struct {
+	Foods []foodResponse `json:"foods"`
+}
+
+func newIngredientResponse(i ddb.Ingredient) ingredientResponse {
+	return ingredientResponse{ID: i.ID, FoodID: i.ResultingFoodID, IngredientID: i.ComponentFoodID}
+}
+
+// userFoods returns every one of the user's foods with its names and
+// ingredients, oldest first.
+func userFoods(ctx context.Context, db ddb.Store, userID string) ([]foodResponse, error) {
+	foods, err := db.Foods(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get foods: %w", err)
+	}
+	names, err := db.FoodNames(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get food names: %w", err)
+	}
+	ingredients, err := db.Ingredients(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get ingredients: %w", err)
+	}
+
+	g := graphOf(ingredients)
+	resps := make([]foodResponse, 0, len(foods))
+	byID := map[string]*foodResponse{}
+	for _, f := range foods {
+		contains := []string{}
+		for _, id := range g.expand(f.ID) {
+			if id != f.ID {
+				contains = append(contains, id)
+			}
+		}
+		resps = append(resps, foodResponse{
+			ID:          f.ID,
+			Names:       []foodNameResponse{},
+			Ingredients: []ingredientResponse{},
+			Contains:    contains,
+		})
+	}
+	for i := range resps {
+		byID[resps[i].ID] = &resps[i]
+	}
+	for _, n := range names {
+		if f, ok := byID[n.FoodID]; ok {
+			f.Names = append(f.Names, foodNameResponse{ID: n.ID, Name: n.Name})
+		}
+	}
+	for _, i := range ingredients {
+		if f, ok := byID[i.ResultingFoodID]; ok {
+			f.Ingredients = append(f.Ingredients, newIngredientResponse(i))
+		}
+	}
+	return resps, nil
+}
+
+// foodHandler handles the /food route in the /api/v1 namespace. GET lists the
+// user's foods with their names and ingredients. It implements customHandler.
+func foodHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	foods, err := userFoods(r.Context(), db, user.ID)
+	if err != nil {
+		logger.Printf("can't list foods: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, logger, http.StatusOK, foodsResponse{Foods: foods})
+}
+
+// foodItemHandler handles the /food/{id} route in the /api/v1 namespace. GET
+// returns the food with its names and ingredients. It implements
+// customHandler.
+func foodItemHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	foods, err := userFoods(r.Context(), db, user.ID)
+	if err != nil {
+		logger.Printf("can't list foods: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	id := pathID(r, "/food/")
+	for i := range foods {
+		if foods[i].ID == id {
+			writeJSON(w, logger, http.StatusOK, foods[i])
+			return
+		}
+	}
+	http.Error(w, "no such food", http.StatusNotFound)
+}
+
+type ingredientRequest struct {
+	// Food is the name of the food that contains the ingredient, like "coffee
+	// cake".
+	Food string `json:"food"`
+	// Ingredient is the name of the food it contains, like "coffee".
+	Ingredient string `json:"ingredient"`
+}
+
+// ingredientHandler handles the /ingredient route in the /api/v1 namespace.
+// POST makes one food an ingredient of another, creating either if it's new.
+// It implements customHandler.
+func ingredientHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req ingredientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if normalizeFoodName(req.Food) == "" || normalizeFoodName(req.Ingredient) == "" {
+		http.Error(w, "food and ingredient must not be blank", http.StatusBadRequest)
+		return
+	}
+	if normalizeFoodName(req.Food) == normalizeFoodName(req.Ingredient) {
+		http.Error(w, errCycle.Error(), http.StatusConflict)
+		return
+	}
+
+	resolver, err := newFoodResolver(ctx, db, user.ID)
+	if err != nil {
+		logger.Printf("can't resolve foods: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ingredients, err := db.Ingredients(ctx, user.ID)
+	if err != nil {
+		logger.Printf("can't get ingredients: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	g := graphOf(ingredients)
+
+	// Check for a cycle before creating anything. A new food has no
+	// ingredients, so can't be part of one.
+	resulting, _ := resolver.lookup(req.Food)
+	component, _ := resolver.lookup(req.Ingredient)
+	if resulting != "" && component != "" {
+		if resulting == component {
+			http.Error(w, errCycle.Error(), http.StatusConflict)
+			return
+		}
+		if err := g.checkAdd(resulting, component); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		for _, i := range ingredients {
+			if i.ResultingFoodID == resulting && i.ComponentFoodID == component {
+				writeJSON(w, logger, http.StatusOK, newIngredientResponse(i))
+				return
+			}
+		}
+	}
+
+	if resulting, err = resolver.resolve(ctx, req.Food); err != nil {
+		logger.Printf("can't resolve food: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if component, err = resolver.resolve(ctx, req.Ingredient); err != nil {
+		logger.Printf("can't resolve ingredient: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	i := ddb.Ingredient{
+		ID:              uuid.New().String(),
+		UserID:          user.ID,
+		ResultingFoodID: resulting,
+		ComponentFoodID: component,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := db.PutIngredient(ctx, &i); err != nil {
+		logger.Printf("can't add ingredient: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, logger, http.StatusCreated, newIngredientResponse(i))
+}
+
+// ingredientItemHandler handles the /ingredient/{id} route in the /api/v1
+// namespace. DELETE removes the relationship. It implements customHandler.
+func ingredientItemHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	i, err := db.Ingredient(ctx, pathID(r, "/ingredient/"))
+	if errors.Is(err, ddb.ErrNotFound) || err == nil && i.UserID != user.ID {
+		http.Error(w, "no such ingredient", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Printf("can't get ingredient: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := db.DeleteIngredient(ctx, i.ID); err != nil {
+		logger.Printf("can't delete ingredient: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type foodNameRequest struct {
+	// Food is a name the food already has.
+	Food string `json:"food"`
+	// Name is the new name for it.
+	Name string `json:"name"`
+}
+
+// nameHandler handles the /name route in the /api/v1 namespace. POST gives a
+// food another name, so eating either is eating the same food. It implements
+// customHandler.
+func nameHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req foodNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := normalizeFoodName(req.Name)
+	if name == "" {
+		http.Error(w, "name must not be blank", http.StatusBadRequest)
+		return
+	}
+
+	resolver, err := newFoodResolver(ctx, db, user.ID)
+	if err != nil {
+		logger.Printf("can't resolve foods: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	foodID, ok := resolver.lookup(req.Food)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no food named %s", req.Food), http.StatusNotFound)
+		return
+	}
+	if existing, ok := resolver.lookup(name); ok {
+		if existing != foodID {
+			http.Error(w, fmt.Sprintf("%s already names another food", name), http.StatusConflict)
+			return
+		}
+		names, err := db.FoodNames(ctx, user.ID)
+		if err != nil {
+			logger.Printf("can't get food names: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, n := range names {
+			if normalizeFoodName(n.Name) == name {
+				writeJSON(w, logger, http.StatusOK, foodNameResponse{ID: n.ID, Name: n.Name})
+				return
+			}
+		}
+	}
+
+	now := time.Now()
+	n := ddb.FoodName{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		FoodID:    foodID,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := db.PutFoodName(ctx, &n); err != nil {
+		logger.Printf("can't add food name: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, logger, http.StatusCreated, foodNameResponse{ID: n.ID, Name: n.Name})
+}
+
+// nameItemHandler handles the /name/{id} route in the /api/v1 namespace.
+// DELETE removes the name, unless it's its food's last. It implements
+// customHandler.
+func nameItemHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	n, err := db.FoodName(ctx, pathID(r, "/name/"))
+	if errors.Is(err, ddb.ErrNotFound) || err == nil && n.UserID != user.ID {
+		http.Error(w, "no such name", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Printf("can't get food name: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	names, err := db.FoodNames(ctx, user.ID)
+	if err != nil {
+		logger.Printf("can't get food names: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	others := 0
+	for _, other := range names {
+		if other.FoodID == n.FoodID && other.ID != n.ID {
+			others++
+		}
+	}
+	if others == 0 {
+		http.Error(w, "a food must keep at least one name", http.StatusConflict)
+		return
+	}
+
+	if err := db.DeleteFoodName(ctx, n.ID); err != nil {
+		logger.Printf("can't delete food name: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
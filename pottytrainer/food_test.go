@@ -0,0 +1,90 @@
+package pottytrainer
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFoodAPI(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for _, edge := range [][2]string{{"coffee cake", "coffee"}, {"coffee cake", "eggs"}, {"coffee", "caffeine"}} {
+		body := `{"food": "` + edge[0] + `", "ingredient": "` + edge[1] + `"}`
+		if status := do(t, server, "u1", http.MethodPost, "/api/v1/ingredient", body, nil); status != http.StatusCreated {
+			t.Fatalf("got status %d adding %s to %s, want %d", status, edge[1], edge[0], http.StatusCreated)
+		}
+	}
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/ingredient",
+		`{"food": "Caffeine", "ingredient": "coffee cake"}`, nil,
+	); status != http.StatusConflict {
+		t.Errorf("got status %d making a cycle, want %d", status, http.StatusConflict)
+	}
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/ingredient",
+		`{"food": "tea", "ingredient": "Tea"}`, nil,
+	); status != http.StatusConflict {
+		t.Errorf("got status %d making a new food its own ingredient, want %d", status, http.StatusConflict)
+	}
+	var existing ingredientResponse
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/ingredient",
+		`{"food": "coffee", "ingredient": "caffeine"}`, &existing,
+	); status != http.StatusOK {
+		t.Errorf("got status %d adding an existing ingredient, want %d", status, http.StatusOK)
+	}
+
+	var eaten eatsResponse
+	do(t, server, "u1", http.MethodPost, "/api/v1/eat", `{"foods": ["coffee cake"]}`, &eaten)
+	if got := len(eaten.Eats[0].Foods); got != 4 {
+		t.Errorf("eating coffee cake ate %d foods, want 4", got)
+	}
+
+	var name foodNameResponse
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/name",
+		`{"food": "coffee", "name": "Joe"}`, &name,
+	); status != http.StatusCreated {
+		t.Fatalf("got status %d naming coffee joe, want %d", status, http.StatusCreated)
+	}
+	var joe eatsResponse
+	do(t, server, "u1", http.MethodPost, "/api/v1/eat", `{"foods": ["joe"]}`, &joe)
+	if joe.Eats[0].FoodID != existing.FoodID {
+		t.Errorf("ate food %s as joe, want coffee, %s", joe.Eats[0].FoodID, existing.FoodID)
+	}
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/name",
+		`{"food": "coffee", "name": "eggs"}`, nil,
+	); status != http.StatusConflict {
+		t.Errorf("got status %d naming coffee eggs, want %d", status, http.StatusConflict)
+	}
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/name",
+		`{"food": "bagel", "name": "everything bagel"}`, nil,
+	); status != http.StatusNotFound {
+		t.Errorf("got status %d naming an unknown food, want %d", status, http.StatusNotFound)
+	}
+
+	var foods foodsResponse
+	do(t, server, "u1", http.MethodGet, "/api/v1/food", "", &foods)
+	if len(foods.Foods) != 4 {
+		t.Errorf("got %d foods, want 4", len(foods.Foods))
+	}
+	var coffee foodResponse
+	do(t, server, "u1", http.MethodGet, "/api/v1/food/"+existing.FoodID, "", &coffee)
+	if len(coffee.Names) != 2 || len(coffee.Ingredients) != 1 || len(coffee.Contains) != 1 {
+		t.Errorf("got coffee %+v, want two names and caffeine", coffee)
+	}
+
+	if status := do(t, server, "u2", http.MethodDelete, "/api/v1/name/"+name.ID, "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d deleting another user's name, want %d", status, http.StatusNotFound)
+	}
+	if status := do(t, server, "u1", http.MethodDelete, "/api/v1/name/"+name.ID, "", nil); status != http.StatusNoContent {
+		t.Errorf("got status %d deleting a name, want %d", status, http.StatusNoContent)
+	}
+	if status := do(t, server, "u1", http.MethodDelete, "/api/v1/name/"+coffee.Names[0].ID, "", nil); status != http.StatusConflict {
+		t.Errorf("got status %d deleting a food's last name, want %d", status, http.StatusConflict)
+	}
+
+	if status := do(t, server, "u1", http.MethodDelete, "/api/v1/ingredient/"+existing.ID, "", nil); status != http.StatusNoContent {
+		t.Errorf("got status %d deleting an ingredient, want %d", status, http.StatusNoContent)
+	}
+	do(t, server, "u1", http.MethodGet, "/api/v1/food/"+existing.FoodID, "", &coffee)
+	if len(coffee.Contains) != 0 {
+		t.Errorf("coffee contains %v after deleting caffeine, want nothing", coffee.Contains)
+	}
+}
@@ -0,0 +1,80 @@
+package pottytrainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"twos.dev/mainframe/ddb"
+)
+
+// errCycle is returned when an ingredient relationship would make a food an
+// ingredient of itself.
+var errCycle = errors.New("a food can't contain itself")
+
+// foodGraph is a user's "x contains y" ingredient relationships between foods.
+type foodGraph struct {
+	// components maps each food's ID to the IDs of the foods it directly
+	// contains.
+	components map[string][]string
+}
+
+// newFoodGraph returns the graph of the given user's ingredient relationships.
+func newFoodGraph(ctx context.Context, db ddb.Store, userID string) (*foodGraph, error) {
+	ingredients, err := db.Ingredients(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get ingredients: %w", err)
+	}
+	return graphOf(ingredients), nil
+}
+
+// graphOf returns the graph of the given ingredient relationships.
+func graphOf(ingredients []ddb.Ingredient) *foodGraph {
+	g := &foodGraph{components: map[string][]string{}}
+	for _, i := range ingredients {
+		g.components[i.ResultingFoodID] = append(g.components[i.ResultingFoodID], i.ComponentFoodID)
+	}
+	return g
+}
+
+// expand returns the IDs of foodID and every food it contains, directly or
+// through other ingredients, sorted. Eating foodID is eating all of them.
+func (g *foodGraph) expand(foodID string) []string {
+	seen := map[string]bool{foodID: true}
+	queue := []string{foodID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, c := range g.components[id] {
+			if !seen[c] {
+				seen[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// expandEat returns the IDs of every food eaten in e: its own food and
+// everything that contains, sorted.
+func (g *foodGraph) expandEat(e ddb.Eat) []string {
+	return g.expand(e.FoodID)
+}
+
+// checkAdd returns errCycle if making component an ingredient of resulting
+// would make a food contain itself.
+func (g *foodGraph) checkAdd(resulting, component string) error {
+	for _, id := range g.expand(component) {
+		if id == resulting {
+			return errCycle
+		}
+	}
+	return nil
+}
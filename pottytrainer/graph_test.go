@@ -0,0 +1,45 @@
+package pottytrainer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"twos.dev/mainframe/ddb"
+)
+
+func TestFoodGraph(t *testing.T) {
+	g := graphOf([]ddb.Ingredient{
+		{ResultingFoodID: "coffee cake", ComponentFoodID: "coffee"},
+		{ResultingFoodID: "coffee cake", ComponentFoodID: "eggs"},
+		{ResultingFoodID: "coffee", ComponentFoodID: "caffeine"},
+		{ResultingFoodID: "tea", ComponentFoodID: "caffeine"},
+	})
+
+	for food, want := range map[string][]string{
+		"coffee cake": {"caffeine", "coffee", "coffee cake", "eggs"},
+		"coffee":      {"caffeine", "coffee"},
+		"caffeine":    {"caffeine"},
+		"toast":       {"toast"},
+	} {
+		if got := g.expand(food); !reflect.DeepEqual(got, want) {
+			t.Errorf("expanded %s to %v, want %v", food, got, want)
+		}
+	}
+	if got := g.expandEat(ddb.Eat{FoodID: "tea"}); !reflect.DeepEqual(got, []string{"caffeine", "tea"}) {
+		t.Errorf("expanded an eat of tea to %v, want caffeine and tea", got)
+	}
+
+	for _, edge := range [][2]string{
+		{"caffeine", "coffee cake"},
+		{"coffee", "coffee cake"},
+		{"eggs", "eggs"},
+	} {
+		if err := g.checkAdd(edge[0], edge[1]); !errors.Is(err, errCycle) {
+			t.Errorf("got %v adding %s to %s, want errCycle", err, edge[1], edge[0])
+		}
+	}
+	if err := g.checkAdd("tea", "coffee"); err != nil {
+		t.Errorf("got %v adding coffee to tea, want no error", err)
+	}
+}
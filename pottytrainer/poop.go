@@ -0,0 +1,198 @@
+package pottytrainer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"twos.dev/mainframe/ddb"
+)
+
+type poopRequest struct {
+	// PoopedAt is when the poop happened. It defaults to now.
+	PoopedAt time.Time `json:"pooped_at"`
+	// Quality is -1 for a bad poop or 1 for a good one.
+	Quality int `json:"quality"`
+	// Bristol is the poop's type on the Bristol stool scale, from 1 to 7. It
+	// may be left out.
+	Bristol int    `json:"bristol"`
+	Notes   string `json:"notes"`
+}
+
+// poopPatch is a change to a Poop. Fields left out are left alone.
+type poopPatch struct {
+	PoopedAt *time.Time `json:"pooped_at"`
+	Quality  *int       `json:"quality"`
+	Bristol  *int       `json:"bristol"`
+	Notes    *string    `json:"notes"`
+}
+
+// validate returns an error describing what's wrong with the fields p sets, if
+// anything. Fields it leaves alone aren't checked, so poops logged before a
+// field was required can still be changed.
+func (p poopPatch) validate() error {
+	if p.PoopedAt != nil && p.PoopedAt.IsZero() {
+		return fmt.Errorf("pooped_at must be set")
+	}
+	if p.Quality != nil && *p.Quality != ddb.BadQuality && *p.Quality != ddb.GoodQuality {
+		return fmt.Errorf("quality must be %d (bad) or %d (good)", ddb.BadQuality, ddb.GoodQuality)
+	}
+	if p.Bristol != nil && (*p.Bristol < 0 || *p.Bristol > maxBristol) {
+		return fmt.Errorf("bristol must be between 1 and %d, or left out", maxBristol)
+	}
+	if p.Notes != nil && utf8.RuneCountInString(*p.Notes) > maxNotesLength {
+		return fmt.Errorf("notes must be at most %d characters", maxNotesLength)
+	}
+	return nil
+}
+
+type poopResponse struct {
+	ID        string    `json:"id"`
+	PoopedAt  time.Time `json:"pooped_at"`
+	Quality   int       `json:"quality"`
+	Bristol   int       `json:"bristol,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type poopsResponse struct {
+	Poops []poopResponse `json:"poops"`
+	// Next is the cursor of the next page, if there is one.
+	Next string `json:"next,omitempty"`
+}
+
+func newPoopResponse(p ddb.Poop) poopResponse {
+	return poopResponse{
+		ID:        p.ID,
+		PoopedAt:  p.PoopedAt,
+		Quality:   p.Quality,
+		Bristol:   p.Bristol,
+		Notes:     p.Notes,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+	}
+}
+
+// poopHandler handles the /poop route in the /api/v1 namespace. GET lists the
+// user's poops, newest first, and POST logs a new one. It implements
+// customHandler.
+func poopHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := parseTimeQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := db.QueryPoops(ctx, user.ID, q)
+		if errors.Is(err, ddb.ErrBadCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.Printf("can't list poops: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp := poopsResponse{Poops: make([]poopResponse, 0, len(page.Items)), Next: page.Next}
+		for _, p := range page.Items {
+			resp.Poops = append(resp.Poops, newPoopResponse(p))
+		}
+		writeJSON(w, logger, http.StatusOK, resp)
+
+	case http.MethodPost:
+		var req poopRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.PoopedAt.IsZero() {
+			req.PoopedAt = time.Now()
+		}
+		p := ddb.Poop{PoopedAt: req.PoopedAt, Quality: req.Quality, Bristol: req.Bristol, Notes: req.Notes}
+		if err := validatePoop(p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logged, err := LogPoop(ctx, db, user.ID, p)
+		if err != nil {
+			logger.Printf("can't log poop: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, logger, http.StatusCreated, newPoopResponse(*logged))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// poopItemHandler handles the /poop/{id} route in the /api/v1 namespace. GET
+// returns the poop, PATCH changes it, and DELETE deletes it. It implements
+// customHandler.
+func poopItemHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	ctx := r.Context()
+
+	p, err := db.Poop(ctx, pathID(r, "/poop/"))
+	if errors.Is(err, ddb.ErrNotFound) || err == nil && p.UserID != user.ID {
+		http.Error(w, "no such poop", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Printf("can't get poop: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, logger, http.StatusOK, newPoopResponse(*p))
+
+	case http.MethodPatch:
+		var patch poopPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := patch.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.PoopedAt != nil {
+			p.PoopedAt = *patch.PoopedAt
+		}
+		if patch.Quality != nil {
+			p.Quality = *patch.Quality
+		}
+		if patch.Bristol != nil {
+			p.Bristol = *patch.Bristol
+		}
+		if patch.Notes != nil {
+			p.Notes = *patch.Notes
+		}
+		p.UpdatedAt = time.Now()
+		if err := db.PutPoop(ctx, p); err != nil {
+			logger.Printf("can't update poop: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, logger, http.StatusOK, newPoopResponse(*p))
+
+	case http.MethodDelete:
+		if err := db.DeletePoop(ctx, p.ID); err != nil {
+			logger.Printf("can't delete poop: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
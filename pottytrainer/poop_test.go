@@ -0,0 +1,86 @@
+package pottytrainer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/ddb"
+)
+
+func TestPoopAPI(t *testing.T) {
+	server, db := newTestServer(t)
+
+	var created poopResponse
+	if status := do(t, server, "u1", http.MethodPost, "/api/v1/poop",
+		`{"pooped_at": "2026-01-02T08:00:00Z", "quality": -1, "bristol": 6, "notes": "after coffee"}`,
+		&created,
+	); status != http.StatusCreated {
+		t.Fatalf("got status %d logging a poop, want %d", status, http.StatusCreated)
+	}
+	stored, err := db.Poop(context.Background(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != "u1" || stored.Quality != -1 || stored.Bristol != 6 || stored.Notes != "after coffee" {
+		t.Errorf("stored %+v, want u1's bad Bristol 6 poop with notes", stored)
+	}
+
+	for _, body := range []string{
+		`{"quality": 0}`,
+		`{"quality": true}`,
+		`{"quality": 1, "bristol": 8}`,
+	} {
+		if status := do(t, server, "u1", http.MethodPost, "/api/v1/poop", body, nil); status != http.StatusBadRequest {
+			t.Errorf("got status %d logging %s, want %d", status, body, http.StatusBadRequest)
+		}
+	}
+
+	do(t, server, "u1", http.MethodPost, "/api/v1/poop", `{"pooped_at": "2026-01-03T08:00:00Z", "quality": 1}`, nil)
+	do(t, server, "u2", http.MethodPost, "/api/v1/poop", `{"pooped_at": "2026-01-02T09:00:00Z", "quality": 1}`, nil)
+	var page poopsResponse
+	do(t, server, "u1", http.MethodGet, "/api/v1/poop?from=2026-01-02T00:00:00Z&to=2026-01-03T00:00:00Z", "", &page)
+	if len(page.Poops) != 1 || page.Poops[0].ID != created.ID {
+		t.Errorf("got poops %+v on January 2, want just the first", page.Poops)
+	}
+
+	path := "/api/v1/poop/" + created.ID
+	var patched poopResponse
+	if status := do(t, server, "u1", http.MethodPatch, path, `{"quality": 1, "notes": ""}`, &patched); status != http.StatusOK {
+		t.Fatalf("got status %d patching a poop, want %d", status, http.StatusOK)
+	}
+	if patched.Quality != 1 || patched.Bristol != 6 || patched.Notes != "" {
+		t.Errorf("got %+v after patching, want good Bristol 6 without notes", patched)
+	}
+	if status := do(t, server, "u1", http.MethodPatch, path, `{"bristol": -1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d patching an invalid Bristol type, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := do(t, server, "u1", http.MethodPatch, path, `{"quality": 0}`, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d patching quality to 0, want %d", status, http.StatusBadRequest)
+	}
+
+	// Poops logged before quality was recorded can still be changed without
+	// rating them.
+	legacy := ddb.Poop{ID: "legacy", UserID: "u1", PoopedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := db.PutPoop(context.Background(), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if status := do(t, server, "u1", http.MethodPatch, "/api/v1/poop/legacy", `{"notes": "from the old app"}`, &patched); status != http.StatusOK {
+		t.Errorf("got status %d patching the notes of an unrated poop, want %d", status, http.StatusOK)
+	}
+	if patched.Quality != 0 || patched.Notes != "from the old app" {
+		t.Errorf("got %+v after patching an unrated poop's notes, want it unrated with notes", patched)
+	}
+
+	if status := do(t, server, "u2", http.MethodPatch, path, `{"quality": -1}`, nil); status != http.StatusNotFound {
+		t.Errorf("got status %d patching another user's poop, want %d", status, http.StatusNotFound)
+	}
+	if status := do(t, server, "u1", http.MethodDelete, path, "", nil); status != http.StatusNoContent {
+		t.Errorf("got status %d deleting a poop, want %d", status, http.StatusNoContent)
+	}
+	if status := do(t, server, "u1", http.MethodGet, path, "", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d getting a deleted poop, want %d", status, http.StatusNotFound)
+	}
+}
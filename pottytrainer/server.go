@@ -1,39 +1,86 @@
 package pottytrainer
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"twos.dev/mainframe/ddb"
 )
 
+// store is where Potty Trainer keeps its data: "sqlite" for mainframe's own
+// database, or "dynamodb". If it's unset, DynamoDB is used when AWS
+// credentials are, so an instance that kept its data there keeps finding it.
+var store = os.Getenv("POTTYTRAINER_STORE")
+
+// NewStore returns the store POTTYTRAINER_STORE asks for. conn is mainframe's
+// database. DynamoDB tables are prefixed with POTTYTRAINER_TABLE_PREFIX, or
+// "pottytrainer" like Potty Trainer's own tables if it's unset.
+func NewStore(logger *log.Logger, conn *sql.DB) (ddb.Store, error) {
+	kind := store
+	if kind == "" {
+		kind = "sqlite"
+		if ddb.HasCredentials() {
+			kind = "dynamodb"
+		}
+		logger.Printf("Keeping Potty Trainer's data in %s; set POTTYTRAINER_STORE to choose", kind)
+	}
+	switch kind {
+	case "sqlite":
+		return ddb.NewSQLite(conn), nil
+	case "dynamodb":
+		db, err := ddb.New(&ddb.Config{
+			Region:          os.Getenv("AWS_REGION"),
+			Endpoint:        os.Getenv("DYNAMODB_ENDPOINT"),
+			TableNamePrefix: os.Getenv("POTTYTRAINER_TABLE_PREFIX"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ddb client: %w", err)
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("POTTYTRAINER_STORE must be sqlite or dynamodb, not %s", kind)
+	}
+}
+
 // Run attaches all the right handlers to the given serve mux.
-func Run(logger *log.Logger, mux *http.ServeMux) error {
+func Run(logger *log.Logger, mux *http.ServeMux, db ddb.Store) error {
 	logger = log.New(logger.Writer(), "[potty] ", logger.Flags())
 
-	ddb, err := ddb.New(&ddb.Config{
-		Region:          "us-east-1",
-		TableNamePrefix: "mainframe-potty",
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create ddb client: %w", err)
-	}
-
 	apiMux := http.NewServeMux()
-	apiMux.Handle("/eat", wrapHandler(eatHandler, logger, ddb))
-	apiMux.Handle("/poop", wrapHandler(poopHandler, logger, ddb))
+	apiMux.Handle("/eat", wrapHandler(eatHandler, logger, db))
+	apiMux.Handle("/eat/", wrapHandler(eatItemHandler, logger, db))
+	apiMux.Handle("/poop", wrapHandler(poopHandler, logger, db))
+	apiMux.Handle("/poop/", wrapHandler(poopItemHandler, logger, db))
+	apiMux.Handle("/food", wrapHandler(foodHandler, logger, db))
+	apiMux.Handle("/food/", wrapHandler(foodItemHandler, logger, db))
+	apiMux.Handle("/ingredient", wrapHandler(ingredientHandler, logger, db))
+	apiMux.Handle("/ingredient/", wrapHandler(ingredientItemHandler, logger, db))
+	apiMux.Handle("/name", wrapHandler(nameHandler, logger, db))
+	apiMux.Handle("/name/", wrapHandler(nameItemHandler, logger, db))
+	apiMux.Handle("/triggers", wrapHandler(triggersHandler, logger, db))
 
 	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", apiMux))
 	return nil
 }
 
-type customHandler func(http.ResponseWriter, *http.Request, *log.Logger, *ddb.Client, *ddb.User)
+const (
+	// defaultPageSize is how many records a list returns when ?limit= isn't
+	// given.
+	defaultPageSize = 100
+	// maxPageSize is the most records a list returns at once.
+	maxPageSize = 1000
+)
+
+type customHandler func(http.ResponseWriter, *http.Request, *log.Logger, ddb.Store, *ddb.User)
 
-func wrapHandler(h customHandler, logger *log.Logger, db *ddb.Client) http.Handler {
+func wrapHandler(h customHandler, logger *log.Logger, db ddb.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -66,39 +113,45 @@ func wrapHandler(h customHandler, logger *log.Logger, db *ddb.Client) http.Handl
 	})
 }
 
-type eatRequest struct {
-	AteAt time.Time `json:"ate_at"`
-}
-
-// eatHandler returns a handler that handles the /eat route in the /api/v1
-// namespace. It implements customHandler.
-func eatHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, ddb *ddb.Client, user *ddb.User) {
-	//ctx := r.Context()
-
-	//var e eatRequest
-	if _, err := w.Write([]byte("yum")); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// parseTimeQuery returns the time range and page a list request asks for. It
+// takes ?from= and ?to=, RFC 3339 times bounding the list, ?limit=, and
+// ?cursor=, the next cursor of the page before.
+func parseTimeQuery(r *http.Request) (ddb.TimeQuery, error) {
+	q := ddb.TimeQuery{Limit: defaultPageSize, Cursor: r.FormValue("cursor")}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		v := r.FormValue(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return ddb.TimeQuery{}, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+		}
+		*bound.t = t
 	}
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxPageSize {
+			return ddb.TimeQuery{}, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	return q, nil
 }
 
-type poopRequest struct {
-	PoopedAt time.Time `json:"pooped_at"`
-	Bad      bool      `json:"quality"`
+// pathID returns the ID at the end of a request to prefix followed by an ID,
+// like /eat/{id}.
+func pathID(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.Path, prefix)
 }
 
-// poopHandler returns a handler that handles the /poop route in the /api/v1
-// namespace. It implements customHandler.
-func poopHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db *ddb.Client, user *ddb.User) {
-	ctx := r.Context()
-
-	var p poopRequest
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	if err := LogPoop(ctx, db, user.ID, p.PoopedAt, p.Bad); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// writeJSON writes v as the response with the given status.
+func writeJSON(w http.ResponseWriter, logger *log.Logger, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("can't write response: %v", err)
 	}
 }
@@ -0,0 +1,252 @@
+package pottytrainer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"twos.dev/mainframe/ddb"
+)
+
+const (
+	// defaultTriggerWindow is how long after eating a food a poop is blamed on
+	// it, unless ?window= says otherwise.
+	defaultTriggerWindow = 24 * time.Hour
+	// maxTriggerWindow is the longest ?window= can be.
+	maxTriggerWindow = 7 * 24 * time.Hour
+	// defaultMinExposures is how many poops must have followed a food for it to
+	// be ranked, unless ?min_exposures= says otherwise. Fewer than that and its
+	// rate is noise.
+	defaultMinExposures = 3
+)
+
+// triggerOptions are the knobs of a trigger analysis.
+type triggerOptions struct {
+	// Window is how long after eating a food a poop counts as following it.
+	Window time.Duration
+	// MinExposures is how many poops must have followed a food for it to be
+	// ranked.
+	MinExposures int
+}
+
+// foodStats is how the poops that followed eating a food went.
+type foodStats struct {
+	FoodID string   `json:"food_id"`
+	Names  []string `json:"names"`
+	// Exposures is how many poops followed eating the food within the window.
+	Exposures int `json:"exposures"`
+	// Bad is how many of those were bad.
+	Bad int `json:"bad"`
+	// BadRate is Bad out of Exposures.
+	BadRate float64 `json:"bad_rate"`
+	// Lift is BadRate over the baseline rate: above 1 means bad poops are more
+	// likely after the food than overall.
+	Lift float64 `json:"lift"`
+	// Confidence is how sure we are, from 0 to 1, that poops after the food go
+	// differently than poops not after it, from a two-proportion z-test.
+	Confidence float64 `json:"confidence"`
+}
+
+// triggerReport is a user's suspected trigger foods and safe foods.
+type triggerReport struct {
+	Window       string `json:"window"`
+	MinExposures int    `json:"min_exposures"`
+	// Poops is how many rated poops were considered.
+	Poops int `json:"poops"`
+	// BadPoops is how many of them were bad.
+	BadPoops int `json:"bad_poops"`
+	// BaselineRate is BadPoops out of Poops.
+	BaselineRate float64 `json:"baseline_rate"`
+	// Triggers are the foods followed by bad poops more often than the
+	// baseline, most confident first.
+	Triggers []foodStats `json:"triggers"`
+	// Safe are the foods followed by bad poops less often than the baseline,
+	// most confident first.
+	Safe []foodStats `json:"safe"`
+}
+
+// analyzeTriggers compares how often bad poops follow each food, including
+// foods eaten as ingredients of others according to g, to how often they
+// happen at all. Poops without a quality are ignored. names maps food IDs to
+// their names.
+func analyzeTriggers(
+	eats []ddb.Eat,
+	poops []ddb.Poop,
+	g *foodGraph,
+	names map[string][]string,
+	opts triggerOptions,
+) triggerReport {
+	report := triggerReport{
+		Window:       opts.Window.String(),
+		MinExposures: opts.MinExposures,
+		Triggers:     []foodStats{},
+		Safe:         []foodStats{},
+	}
+
+	eats = append([]ddb.Eat(nil), eats...)
+	sort.Slice(eats, func(i, j int) bool { return eats[i].AteAt.Before(eats[j].AteAt) })
+
+	stats := map[string]*foodStats{}
+	for _, p := range poops {
+		if p.Quality != ddb.BadQuality && p.Quality != ddb.GoodQuality {
+			continue
+		}
+		bad := p.Quality == ddb.BadQuality
+		report.Poops++
+		if bad {
+			report.BadPoops++
+		}
+
+		// Every food eaten in the window before p, counted once however many
+		// times it was eaten.
+		from := p.PoopedAt.Add(-opts.Window)
+		first := sort.Search(len(eats), func(i int) bool { return !eats[i].AteAt.Before(from) })
+		exposed := map[string]bool{}
+		for _, e := range eats[first:] {
+			if !e.AteAt.Before(p.PoopedAt) {
+				break
+			}
+			for _, id := range g.expandEat(e) {
+				exposed[id] = true
+			}
+		}
+
+		for id := range exposed {
+			s, ok := stats[id]
+			if !ok {
+				s = &foodStats{FoodID: id, Names: names[id]}
+				if s.Names == nil {
+					s.Names = []string{}
+				}
+				stats[id] = s
+			}
+			s.Exposures++
+			if bad {
+				s.Bad++
+			}
+		}
+	}
+	if report.Poops == 0 {
+		return report
+	}
+	report.BaselineRate = float64(report.BadPoops) / float64(report.Poops)
+
+	for _, s := range stats {
+		if s.Exposures < opts.MinExposures {
+			continue
+		}
+		s.BadRate = float64(s.Bad) / float64(s.Exposures)
+		if report.BaselineRate > 0 {
+			s.Lift = s.BadRate / report.BaselineRate
+		}
+		s.Confidence = confidence(s.Bad, s.Exposures, report.BadPoops-s.Bad, report.Poops-s.Exposures)
+
+		switch {
+		case s.BadRate > report.BaselineRate:
+			report.Triggers = append(report.Triggers, *s)
+		case s.BadRate < report.BaselineRate:
+			report.Safe = append(report.Safe, *s)
+		}
+	}
+
+	// Most confident first, then furthest from the baseline.
+	rank := func(list []foodStats) {
+		sort.Slice(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if a.Confidence != b.Confidence {
+				return a.Confidence > b.Confidence
+			}
+			da := math.Abs(a.BadRate - report.BaselineRate)
+			db := math.Abs(b.BadRate - report.BaselineRate)
+			if da != db {
+				return da > db
+			}
+			return a.FoodID < b.FoodID
+		})
+	}
+	rank(report.Triggers)
+	rank(report.Safe)
+	return report
+}
+
+// confidence returns how sure we are, from 0 to 1, that bad1 out of n1 and
+// bad2 out of n2 come from different rates, by a two-sided two-proportion
+// z-test. It's 0 if either side is empty or every poop went the same way.
+func confidence(bad1, n1, bad2, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 0
+	}
+	pooled := float64(bad1+bad2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 0
+	}
+	z := (float64(bad1)/float64(n1) - float64(bad2)/float64(n2)) / se
+	return math.Erf(math.Abs(z) / math.Sqrt2)
+}
+
+// userTriggers ranks the given user's suspected trigger foods and safe
+// foods from everything they've logged.
+func userTriggers(ctx context.Context, db ddb.Store, userID string, opts triggerOptions) (triggerReport, error) {
+	eats, err := db.Eats(ctx, userID)
+	if err != nil {
+		return triggerReport{}, fmt.Errorf("can't get eats: %w", err)
+	}
+	poops, err := db.Poops(ctx, userID)
+	if err != nil {
+		return triggerReport{}, fmt.Errorf("can't get poops: %w", err)
+	}
+	g, err := newFoodGraph(ctx, db, userID)
+	if err != nil {
+		return triggerReport{}, err
+	}
+	foodNames, err := db.FoodNames(ctx, userID)
+	if err != nil {
+		return triggerReport{}, fmt.Errorf("can't get food names: %w", err)
+	}
+	names := map[string][]string{}
+	for _, n := range foodNames {
+		names[n.FoodID] = append(names[n.FoodID], n.Name)
+	}
+	return analyzeTriggers(eats, poops, g, names, opts), nil
+}
+
+// triggersHandler handles the /triggers route in the /api/v1 namespace. GET
+// returns the user's suspected trigger foods and safe foods. It takes
+// ?window=, how long after eating a food a poop is blamed on it, like 12h,
+// and ?min_exposures=. It implements customHandler.
+func triggersHandler(w http.ResponseWriter, r *http.Request, logger *log.Logger, db ddb.Store, user *ddb.User) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := triggerOptions{Window: defaultTriggerWindow, MinExposures: defaultMinExposures}
+	if v := r.FormValue("window"); v != "" {
+		var err error
+		if opts.Window, err = time.ParseDuration(v); err != nil || opts.Window <= 0 || opts.Window > maxTriggerWindow {
+			http.Error(w, fmt.Sprintf("window must be a duration up to %s, like 12h", maxTriggerWindow), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("min_exposures"); v != "" {
+		var err error
+		if opts.MinExposures, err = strconv.Atoi(v); err != nil || opts.MinExposures < 1 {
+			http.Error(w, "min_exposures must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := userTriggers(r.Context(), db, user.ID, opts)
+	if err != nil {
+		logger.Printf("can't analyze triggers: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, logger, http.StatusOK, report)
+}
@@ -0,0 +1,104 @@
+package pottytrainer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/ddb"
+)
+
+func TestTriggers(t *testing.T) {
+	ctx := context.Background()
+	server, db := newTestServer(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A latte with milk every even day, then a bad poop; rice every odd day,
+	// then a good one. Bread every day, and a snack long before each poop, out
+	// of the window.
+	do(t, server, "u1", http.MethodPost, "/api/v1/ingredient", `{"food": "latte", "ingredient": "milk"}`, nil)
+	for day := 0; day < 20; day++ {
+		morning := start.Add(time.Duration(day) * 24 * time.Hour)
+		food, quality := "latte", ddb.BadQuality
+		if day%2 == 1 {
+			food, quality = "rice", ddb.GoodQuality
+		}
+		if _, err := LogEats(ctx, db, "u1", morning.Add(8*time.Hour), []string{food, "bread"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LogEats(ctx, db, "u1", morning.Add(-3*24*time.Hour), []string{"snack"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LogPoop(ctx, db, "u1", ddb.Poop{PoopedAt: morning.Add(14 * time.Hour), Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unrated poops don't count.
+	if err := db.PutPoop(ctx, &ddb.Poop{ID: "old", UserID: "u1", PoopedAt: start.Add(9 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var report triggerReport
+	if status := do(t, server, "u1", http.MethodGet, "/api/v1/triggers?window=12h", "", &report); status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if report.Poops != 20 || report.BadPoops != 10 || report.BaselineRate != 0.5 {
+		t.Errorf("got %d poops, %d bad, baseline %v, want 20, 10 and 0.5", report.Poops, report.BadPoops, report.BaselineRate)
+	}
+
+	names := func(list []foodStats) string {
+		var s []string
+		for _, f := range list {
+			s = append(s, f.Names...)
+		}
+		sort.Strings(s)
+		return fmt.Sprint(s)
+	}
+	// Bread is followed by bad poops exactly as often as anything, so it's
+	// neither.
+	if got, want := names(report.Triggers), "[latte milk]"; got != want {
+		t.Errorf("got triggers %s, want %s", got, want)
+	}
+	if got, want := names(report.Safe), "[rice]"; got != want {
+		t.Errorf("got safe foods %s, want %s", got, want)
+	}
+	for _, f := range report.Triggers {
+		if f.Exposures != 10 || f.BadRate != 1 || f.Lift != 2 || f.Confidence < 0.99 {
+			t.Errorf("got %+v, want 10 bad exposures with lift 2 and high confidence", f)
+		}
+	}
+
+	// A window too short to reach any poop ranks nothing.
+	do(t, server, "u1", http.MethodGet, "/api/v1/triggers?window=1h", "", &report)
+	if len(report.Triggers) != 0 || len(report.Safe) != 0 {
+		t.Errorf("got %s and %s with a 1h window, want nothing", names(report.Triggers), names(report.Safe))
+	}
+
+	for _, query := range []string{"window=0s", "window=8d", "window=forever", "min_exposures=0"} {
+		if status := do(t, server, "u1", http.MethodGet, "/api/v1/triggers?"+query, "", nil); status != http.StatusBadRequest {
+			t.Errorf("got status %d for %s, want %d", status, query, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	for _, c := range []struct {
+		bad1, n1, bad2, n2 int
+		want               float64
+	}{
+		{5, 10, 5, 10, 0},
+		{0, 0, 5, 10, 0},
+		{10, 10, 10, 10, 0},
+		// z = 2, so about 95%.
+		{30, 50, 20, 50, 0.9545},
+	} {
+		got := confidence(c.bad1, c.n1, c.bad2, c.n2)
+		if math.Abs(got-c.want) > 0.001 {
+			t.Errorf("confidence(%d, %d, %d, %d) = %v, want %v", c.bad1, c.n1, c.bad2, c.n2, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// granularity is how finely a retention policy's rollups are bucketed.
+type granularity string
+
+const (
+	hourlyRollups granularity = "hourly"
+	dailyRollups  granularity = "daily"
+)
+
+// bucket returns an SQL expression for the start of the hour or day that the
+// time in column falls in, in UTC.
+func (g granularity) bucket(column string) string {
+	if g == hourlyRollups {
+		return fmt.Sprintf(`STRFTIME('%%Y-%%m-%%d %%H:00:00', %s)`, column)
+	}
+	return fmt.Sprintf(`DATE(%s) || ' 00:00:00'`, column)
+}
+
+// retentionPolicy says how long a time-series table keeps its raw rows, and
+// what happens to them after.
+type retentionPolicy struct {
+	table string
+	// timeColumn holds when each row happened. It may be in any format SQLite's
+	// date functions understand.
+	timeColumn string
+	// keepDays is how many days raw rows are kept by default. It can be
+	// overridden by MAINFRAME_RETENTION_DAYS, and zero keeps them forever.
+	keepDays int
+	// where, if set, further limits which rows may be pruned.
+	where string
+	// rollupTable gets aggregates of raw rows before they're deleted.
+	rollupTable string
+	granularity granularity
+	// rollup aggregates the rows being pruned into rollupTable. %[1]s is
+	// replaced with the bucket each row falls into and %[2]s with the condition
+	// a row must meet to be pruned.
+	rollup string
+}
+
+// retentionPolicies are enforced nightly by the retention cron. Any table that
+// grows with time belongs here.
+var retentionPolicies = []retentionPolicy{
+	{
+		table:       "speedtests",
+		timeColumn:  "started_at",
+		keepDays:    90,
+		rollupTable: "speedtest_rollups",
+		granularity: hourlyRollups,
+		rollup: `
+			INSERT INTO speedtest_rollups (
+				period_start,
+				hostname,
+				tests,
+				kbps_down_min,
+				kbps_down_max,
+				kbps_down_sum
+			)
+			SELECT %[1]s, hostname, COUNT(*), MIN(kbps_down), MAX(kbps_down), SUM(kbps_down)
+			FROM speedtests
+			WHERE %[2]s
+			GROUP BY 1, 2
+			ON CONFLICT (period_start, hostname) DO UPDATE SET
+				tests = tests + excluded.tests,
+				kbps_down_min = MIN(kbps_down_min, excluded.kbps_down_min),
+				kbps_down_max = MAX(kbps_down_max, excluded.kbps_down_max),
+				kbps_down_sum = kbps_down_sum + excluded.kbps_down_sum,
+				updated_at = CURRENT_TIMESTAMP
+		`,
+	},
+	{
+		table:      "ip_addresses",
+		timeColumn: "created_at",
+		keepDays:   365,
+		// dyndns compares against the newest row, so it's never pruned.
+		where:       `id < (SELECT MAX(id) FROM ip_addresses)`,
+		rollupTable: "ip_address_rollups",
+		granularity: dailyRollups,
+		rollup: `
+			INSERT INTO ip_address_rollups (
+				period_start,
+				ip_address,
+				sightings
+			)
+			SELECT %[1]s, ip_address, COUNT(*)
+			FROM ip_addresses
+			WHERE %[2]s
+			GROUP BY 1, 2
+			ON CONFLICT (period_start, ip_address) DO UPDATE SET
+				sightings = sightings + excluded.sightings,
+				updated_at = CURRENT_TIMESTAMP
+		`,
+	},
+	{
+		table:       "cron_runs",
+		timeColumn:  "started_at",
+		keepDays:    90,
+		rollupTable: "cron_run_rollups",
+		granularity: dailyRollups,
+		rollup: `
+			INSERT INTO cron_run_rollups (
+				period_start,
+				name,
+				runs,
+				failures,
+				seconds
+			)
+			SELECT
+				%[1]s,
+				name,
+				COUNT(*),
+				SUM(error IS NOT NULL),
+				COALESCE(SUM((JULIANDAY(ended_at) - JULIANDAY(started_at)) * 86400), 0)
+			FROM cron_runs
+			WHERE %[2]s
+			GROUP BY 1, 2
+			ON CONFLICT (period_start, name) DO UPDATE SET
+				runs = runs + excluded.runs,
+				failures = failures + excluded.failures,
+				seconds = seconds + excluded.seconds,
+				updated_at = CURRENT_TIMESTAMP
+		`,
+	},
+}
+
+// retentionDays is a comma-separated list of table=days pairs overriding how
+// many days of raw rows retentionPolicies keep.
+var retentionDays = os.Getenv("MAINFRAME_RETENTION_DAYS")
+
+// retentionOverrides parses MAINFRAME_RETENTION_DAYS.
+func retentionOverrides() (map[string]int, error) {
+	overrides := map[string]int{}
+	if retentionDays == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(retentionDays, ",") {
+		table, days, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("MAINFRAME_RETENTION_DAYS entry %q must look like table=days", pair)
+		}
+		known := false
+		for _, policy := range retentionPolicies {
+			known = known || policy.table == table
+		}
+		if !known {
+			return nil, fmt.Errorf("MAINFRAME_RETENTION_DAYS has no policy for table %s", table)
+		}
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("MAINFRAME_RETENTION_DAYS for %s must be a non-negative number of days", table)
+		}
+		overrides[table] = n
+	}
+	return overrides, nil
+}
+
+// enforce rolls up and deletes the policy's rows from before cutoff, and
+// returns how many it deleted.
+func (p retentionPolicy) enforce(ctx context.Context, db *sql.DB, cutoff time.Time) (int64, error) {
+	cond := fmt.Sprintf(`DATETIME(%s) < $1`, p.timeColumn)
+	if p.where != "" {
+		cond += " AND " + p.where
+	}
+	arg := cutoff.UTC().Format(time.DateTime)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(p.rollup, p.granularity.bucket(p.timeColumn), cond), arg); err != nil {
+		return 0, fmt.Errorf("can't roll %s up into %s: %w", p.table, p.rollupTable, err)
+	}
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s`, p.table, cond), arg)
+	if err != nil {
+		return 0, fmt.Errorf("can't prune %s: %w", p.table, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("can't count pruned %s: %w", p.table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("can't commit pruning %s: %w", p.table, err)
+	}
+	return n, nil
+}
+
+// runRetention enforces retentionPolicies: raw rows older than each policy
+// allows are rolled up into its rollup table, then deleted.
+func runRetention(
+	logger *log.Logger,
+	_ string,
+	db *sql.DB,
+	_ *http.ServeMux,
+	_ *googleClient,
+) error {
+	logger = log.New(logger.Writer(), "[retention] ", logger.Flags())
+	ctx := context.Background()
+	now := time.Now()
+
+	overrides, err := retentionOverrides()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, policy := range retentionPolicies {
+		days := policy.keepDays
+		if n, ok := overrides[policy.table]; ok {
+			days = n
+		}
+		if days == 0 {
+			continue
+		}
+
+		n, err := policy.enforce(ctx, db, now.AddDate(0, 0, -days))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logger.Printf(
+				"Pruned %d rows older than %d days from %s, rolling them up %s into %s",
+				n,
+				days,
+				policy.table,
+				policy.granularity,
+				policy.rollupTable,
+			)
+		}
+		total += n
+	}
+	logger.Printf("Pruned %d rows in total", total)
+	return nil
+}
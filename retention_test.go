@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"twos.dev/mainframe/db"
+)
+
+func TestRetentionEnforce(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.New(log.New(io.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for _, q := range []string{
+		`INSERT INTO speedtests (started_at, ended_at, kbps_down, hostname) VALUES
+			('2026-01-09T10:15:00Z', '2026-01-09T10:16:00Z', 100, 'h'),
+			('2026-01-09T10:45:00Z', '2026-01-09T10:46:00Z', 300, 'h'),
+			('2026-01-09T11:05:00Z', '2026-01-09T11:06:00Z', 50, 'h'),
+			('2026-01-10T00:00:00Z', '2026-01-10T00:01:00Z', 999, 'h'),
+			('2026-01-11T09:00:00Z', '2026-01-11T09:01:00Z', 999, 'h')`,
+		// The newest address is old too, but dyndns needs it.
+		`INSERT INTO ip_addresses (ip_address, created_at) VALUES
+			('1.1.1.1', '2026-01-08 10:00:00'),
+			('1.1.1.1', '2026-01-08 12:00:00'),
+			('2.2.2.2', '2026-01-09 08:00:00'),
+			('3.3.3.3', '2026-01-09 09:00:00')`,
+		`INSERT INTO cron_runs (name, version, started_at, ended_at, error) VALUES
+			('x', 'v', '2026-01-09T10:00:00Z', '2026-01-09T10:00:30Z', NULL),
+			('x', 'v', '2026-01-09T11:00:00Z', '2026-01-09T11:01:00Z', 'boom'),
+			('x', 'v', '2026-01-09T12:00:00Z', NULL, NULL),
+			('y', 'v', '2026-01-11T12:00:00Z', '2026-01-11T12:00:10Z', NULL)`,
+	} {
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := func(query string) []string {
+		t.Helper()
+		rs, err := conn.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Close()
+		cols, err := rs.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for rs.Next() {
+			values := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rs.Scan(ptrs...); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+		}
+		if err := rs.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, c := range []struct {
+			query string
+			want  []string
+		}{
+			{
+				`SELECT period_start, hostname, tests, kbps_down_min, kbps_down_max, kbps_down_sum FROM speedtest_rollups ORDER BY 1`,
+				[]string{"2026-01-09 10:00:00 h 2 100 300 400", "2026-01-09 11:00:00 h 1 50 50 50"},
+			},
+			{
+				`SELECT kbps_down FROM speedtests ORDER BY started_at`,
+				[]string{"999", "999"},
+			},
+			{
+				`SELECT period_start, ip_address, sightings FROM ip_address_rollups ORDER BY 1`,
+				[]string{"2026-01-08 00:00:00 1.1.1.1 2", "2026-01-09 00:00:00 2.2.2.2 1"},
+			},
+			{
+				`SELECT ip_address FROM ip_addresses`,
+				[]string{"3.3.3.3"},
+			},
+			{
+				`SELECT period_start, name, runs, failures, ROUND(seconds) FROM cron_run_rollups ORDER BY 1`,
+				[]string{"2026-01-09 00:00:00 x 3 1 90"},
+			},
+			{
+				`SELECT name FROM cron_runs`,
+				[]string{"y"},
+			},
+		} {
+			if got := rows(c.query); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: %s got %q, want %q", when, c.query, got, c.want)
+			}
+		}
+	}
+
+	cutoff := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	want := map[string]int64{"speedtests": 3, "ip_addresses": 3, "cron_runs": 3}
+	for _, policy := range retentionPolicies {
+		n, err := policy.enforce(ctx, conn, cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want[policy.table] {
+			t.Errorf("pruned %d rows from %s, want %d", n, policy.table, want[policy.table])
+		}
+	}
+	check("after pruning")
+
+	// Running again changes nothing.
+	for _, policy := range retentionPolicies {
+		n, err := policy.enforce(ctx, conn, cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("pruned %d more rows from %s on a rerun, want 0", n, policy.table)
+		}
+	}
+	check("after rerunning")
+}
@@ -0,0 +1,284 @@
+// Package secrets encrypts values, like OAuth tokens, before they're written to
+// the database.
+//
+// Each value is encrypted with its own random data key, which is itself
+// encrypted ("wrapped") with a key encryption key from the environment. Only
+// the wrapped data key is stored, next to the ciphertext, along with the ID of
+// the key that wrapped it. Rotating the key encryption key means re-wrapping
+// each value's data key, which Rewrap does.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// prefix starts every encrypted value. Values without it are treated as
+	// plaintext written before encryption was turned on.
+	prefix = "enc1."
+	// KeySize is the length in bytes of a key encryption key.
+	KeySize = 32
+
+	envKeySecret         = "MAINFRAME_SECRET_KEY"
+	envKeySecretFile     = "MAINFRAME_SECRET_KEY_FILE"
+	envKeySecretPrevious = "MAINFRAME_SECRET_KEY_PREVIOUS"
+)
+
+// ErrUnknownKey is returned when decrypting a value wrapped with a key that
+// isn't in the keyring.
+var ErrUnknownKey = errors.New("value was encrypted with a key that isn't configured")
+
+// Keyring holds the current key encryption key, used for all new values, and
+// any previous ones, used only to decrypt values written before a rotation.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// FromEnv returns a keyring whose current key is the base64 in
+// MAINFRAME_SECRET_KEY, or in the file at MAINFRAME_SECRET_KEY_FILE. Previous
+// keys are read from MAINFRAME_SECRET_KEY_PREVIOUS, comma-separated.
+//
+// If no key is set at all, the keyring stores values in plaintext, as
+// mainframe did before encryption; see Enabled.
+func FromEnv() (*Keyring, error) {
+	encoded := os.Getenv(envKeySecret)
+	if path := os.Getenv(envKeySecretFile); path != "" {
+		if encoded != "" {
+			return nil, fmt.Errorf("only one of %s and %s may be set", envKeySecret, envKeySecretFile)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %w", envKeySecretFile, err)
+		}
+		encoded = strings.TrimSpace(string(b))
+	}
+	if encoded == "" {
+		if os.Getenv(envKeySecretPrevious) != "" {
+			return nil, fmt.Errorf("%s is set, but neither %s nor %s is", envKeySecretPrevious, envKeySecret, envKeySecretFile)
+		}
+		return &Keyring{keys: map[string]cipher.AEAD{}}, nil
+	}
+
+	current, err := decodeKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envKeySecret, err)
+	}
+	var previous [][]byte
+	for _, encoded := range strings.Split(os.Getenv(envKeySecretPrevious), ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envKeySecretPrevious, err)
+		}
+		previous = append(previous, key)
+	}
+
+	return New(current, previous...)
+}
+
+// New returns a keyring that encrypts with current and can decrypt with
+// current or any of previous.
+func New(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := Keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key must be %d bytes, not %d", KeySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			k.current = id
+		}
+		k.keys[id] = aead
+	}
+	return &k, nil
+}
+
+// Enabled returns true if k has a key to encrypt with. If it doesn't, Encrypt
+// returns values unchanged and only plaintext values can be decrypted.
+func (k *Keyring) Enabled() bool {
+	return k.current != ""
+}
+
+// GenerateKey returns a new random key encryption key, base64-encoded as the
+// environment expects it.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", fmt.Errorf("can't generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Encrypt returns plaintext encrypted under a new data key, wrapped with the
+// current key. If k isn't enabled, plaintext is returned unchanged.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if !k.Enabled() {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("can't generate data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(data, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(dataKey, ciphertext)
+}
+
+// Decrypt returns the plaintext of a value returned by Encrypt. Values that
+// were never encrypted are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRewrap returns true if value isn't encrypted, or is wrapped with a key
+// other than the current one.
+func (k *Keyring) NeedsRewrap(value string) bool {
+	if !IsEncrypted(value) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ".")
+	return id != k.current
+}
+
+// Rewrap returns value with its data key wrapped with the current key instead
+// of whichever key wrapped it before. Values that were never encrypted are
+// encrypted.
+func (k *Keyring) Rewrap(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return k.Encrypt(value)
+	}
+	dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(dataKey, ciphertext)
+}
+
+// IsEncrypted returns true if value was returned by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// wrap returns the stored form of ciphertext and the data key it was encrypted
+// with: enc1.<key ID>.<wrapped data key>.<ciphertext>.
+func (k *Keyring) wrap(dataKey, ciphertext []byte) (string, error) {
+	wrapped, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+	return prefix + strings.Join([]string{
+		k.current,
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+	}, "."), nil
+}
+
+// unwrap returns the data key and ciphertext stored in value.
+func (k *Keyring) unwrap(value string) ([]byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("malformed encrypted value")
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, nil, fmt.Errorf("key %s: %w", parts[0], ErrUnknownKey)
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed data key: %w", err)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't unwrap data key: %w", err)
+	}
+	return dataKey, ciphertext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("can't create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("can't create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts plaintext with a random nonce, which it prepends to the
+// result.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("can't generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal.
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, not %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// keyID returns a short, non-secret name for key.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("mainframe key id:"), key...))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newKey returns a random key, and its base64 as the environment holds it.
+func newKey(t *testing.T) ([]byte, string) {
+	t.Helper()
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, encoded
+}
+
+func newKeyring(t *testing.T, current []byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	k, err := New(current, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	key, _ := newKey(t)
+	k := newKeyring(t, key)
+
+	for _, plaintext := range []string{"", "ya29.token", strings.Repeat("ü", 1000)} {
+		encrypted, err := k.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(encrypted) || plaintext != "" && strings.Contains(encrypted, plaintext) {
+			t.Errorf("got %q from encrypting %q, want it encrypted", encrypted, plaintext)
+		}
+		decrypted, err := k.Decrypt(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != plaintext {
+			t.Errorf("got %q from decrypting, want %q", decrypted, plaintext)
+		}
+	}
+
+	again, err := k.Encrypt("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if once, _ := k.Encrypt("ya29.token"); once == again {
+		t.Errorf("got the same value from encrypting twice, want a new data key each time")
+	}
+
+	if got, err := k.Decrypt("plain"); err != nil || got != "plain" {
+		t.Errorf("got %q, %v from decrypting plaintext, want it unchanged", got, err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	key, _ := newKey(t)
+	k := newKeyring(t, key)
+	encrypted, err := k.Encrypt("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(encrypted, prefix), ".")
+
+	flip := func(s string) string {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[len(b)-1] ^= 1
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	for name, value := range map[string]string{
+		"data key":   prefix + strings.Join([]string{parts[0], flip(parts[1]), parts[2]}, "."),
+		"ciphertext": prefix + strings.Join([]string{parts[0], parts[1], flip(parts[2])}, "."),
+		"swapped":    prefix + strings.Join([]string{parts[0], parts[2], parts[1]}, "."),
+		"truncated":  prefix + strings.Join(parts[:2], "."),
+	} {
+		if got, err := k.Decrypt(value); err == nil {
+			t.Errorf("got %q from decrypting a tampered %s, want an error", got, name)
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	key, _ := newKey(t)
+	other, _ := newKey(t)
+	encrypted, err := newKeyring(t, key).Encrypt("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newKeyring(t, other).Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v decrypting with the wrong key, want ErrUnknownKey", err)
+	}
+
+	if _, err := New(key[:16]); err == nil {
+		t.Errorf("made a keyring with a 16-byte key, want an error")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old, oldEncoded := newKey(t)
+	current, currentEncoded := newKey(t)
+	encrypted, err := newKeyring(t, old).Encrypt("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(envKeySecret, currentEncoded)
+	t.Setenv(envKeySecretPrevious, oldEncoded)
+	k, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := k.Decrypt(encrypted); err != nil || got != "ya29.token" {
+		t.Errorf("got %q, %v decrypting with a previous key, want ya29.token", got, err)
+	}
+
+	if !k.NeedsRewrap(encrypted) {
+		t.Errorf("got no rewrap needed for a value under a previous key")
+	}
+	if !k.NeedsRewrap("plain") {
+		t.Errorf("got no rewrap needed for a plaintext value")
+	}
+	for _, value := range []string{encrypted, "plain"} {
+		rewrapped, err := k.Rewrap(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.NeedsRewrap(rewrapped) {
+			t.Errorf("got rewrap needed after rewrapping %q", value)
+		}
+		// The previous key is no longer needed.
+		if _, err := newKeyring(t, current).Decrypt(rewrapped); err != nil {
+			t.Errorf("can't decrypt %q rewrapped without the previous key: %v", value, err)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	_, encoded := newKey(t)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name                 string
+		key, file, previous  string
+		wantErr, wantEnabled bool
+	}{
+		{name: "env", key: encoded, wantEnabled: true},
+		{name: "file", file: path, wantEnabled: true},
+		{name: "both", key: encoded, file: path, wantErr: true},
+		{name: "missing file", file: filepath.Join(t.TempDir(), "nope"), wantErr: true},
+		{name: "not base64", key: "!!!", wantErr: true},
+		{name: "too short", key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)), wantErr: true},
+		{name: "bad previous", key: encoded, previous: "!!!", wantErr: true},
+		{name: "only previous", previous: encoded, wantErr: true},
+		{name: "none"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(envKeySecret, c.key)
+			t.Setenv(envKeySecretFile, c.file)
+			t.Setenv(envKeySecretPrevious, c.previous)
+			k, err := FromEnv()
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			if err == nil && k.Enabled() != c.wantEnabled {
+				t.Errorf("got enabled %t, want %t", k.Enabled(), c.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	t.Setenv(envKeySecret, "")
+	t.Setenv(envKeySecretFile, "")
+	t.Setenv(envKeySecretPrevious, "")
+	k, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := k.Encrypt("ya29.token"); err != nil || got != "ya29.token" {
+		t.Errorf("got %q, %v encrypting without a key, want it unchanged", got, err)
+	}
+
+	key, _ := newKey(t)
+	encrypted, err := newKeyring(t, key).Encrypt("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v decrypting without a key, want ErrUnknownKey", err)
+	}
+}
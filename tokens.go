@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"twos.dev/mainframe/secrets"
+)
+
+// googleTokenLeeway is how long before a stored access token expires that we
+// start refreshing it instead, so it doesn't expire mid-request.
+const googleTokenLeeway = time.Minute
+
+// errLinkDead is returned when a Google link's refresh token has been revoked
+// or has expired. The link's owner must register with Google again.
+var errLinkDead = errors.New("Google link is dead; register with Google again to revive it")
+
+// googleClient hands out HTTP clients authenticated as the Google accounts
+// linked to mainframe users.
+type googleClient struct {
+	config *oauth2.Config
+	db     *sql.DB
+	keys   *secrets.Keyring
+	logger *log.Logger
+
+	mu      sync.Mutex
+	sources map[int64]oauth2.TokenSource
+}
+
+// googleLink is a Google account a user has given mainframe access to.
+type googleLink struct {
+	ID     int64
+	UserID int64
+	// Email is the Google account's email address.
+	Email string
+	// Scopes are the scopes the account has granted.
+	Scopes []string
+}
+
+// requireScope returns a *missingScopeError if link hasn't granted scope.
+func (l googleLink) requireScope(scope string) error {
+	for _, s := range l.Scopes {
+		if s == scope {
+			return nil
+		}
+	}
+	return &missingScopeError{Account: l.Email, Scope: scope}
+}
+
+// links returns every live Google link, oldest first.
+func (g *googleClient) links(ctx context.Context) ([]googleLink, error) {
+	rows, err := g.db.QueryContext(
+		ctx,
+		`
+		SELECT google_links.id, google_users.user_id, google_users.email
+		FROM google_links
+		INNER JOIN google_users
+			ON google_links.google_user_id = google_users.id
+		WHERE google_links.dead_at IS NULL
+		AND google_users.user_id IS NOT NULL
+		ORDER BY google_links.id
+		`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query Google links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []googleLink
+	for rows.Next() {
+		var link googleLink
+		if err := rows.Scan(&link.ID, &link.UserID, &link.Email); err != nil {
+			return nil, fmt.Errorf("can't scan Google link: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("can't read Google links: %w", err)
+	}
+	rows.Close()
+
+	for i := range links {
+		if links[i].Scopes, err = g.scopes(ctx, links[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// scopes returns the scopes the given Google link has granted.
+func (g *googleClient) scopes(ctx context.Context, linkID int64) ([]string, error) {
+	rows, err := g.db.QueryContext(
+		ctx,
+		`
+		SELECT scope
+		FROM google_link_scopes
+		WHERE google_link_id = $1
+		ORDER BY scope
+		`,
+		linkID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't query Google link scopes: %w", err)
+	}
+	defer rows.Close()
+
+	var scopes []string
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, fmt.Errorf("can't scan Google link scope: %w", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, rows.Err()
+}
+
+// tokenSource returns a token source for the given Google link. Tokens are
+// refreshed as needed, and refreshed tokens are saved back to the database.
+func (g *googleClient) tokenSource(linkID int64) oauth2.TokenSource {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.sources == nil {
+		g.sources = map[int64]oauth2.TokenSource{}
+	}
+	if ts, ok := g.sources[linkID]; ok {
+		return ts
+	}
+	ts := oauth2.ReuseTokenSource(nil, &linkTokenSource{
+		config: g.config,
+		db:     g.db,
+		keys:   g.keys,
+		logger: g.logger,
+		linkID: linkID,
+	})
+	g.sources[linkID] = ts
+	return ts
+}
+
+// forget drops the cached token source for the given Google link, so a deleted
+// link's token is never used again.
+func (g *googleClient) forget(linkID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sources, linkID)
+}
+
+// client returns an HTTP client authenticated as the given Google link.
+func (g *googleClient) client(ctx context.Context, linkID int64) *http.Client {
+	return oauth2.NewClient(ctx, g.tokenSource(linkID))
+}
+
+// linkTokenSource is an oauth2.TokenSource backed by a row in google_links.
+type linkTokenSource struct {
+	config *oauth2.Config
+	db     *sql.DB
+	keys   *secrets.Keyring
+	logger *log.Logger
+	linkID int64
+}
+
+// Token returns the link's stored access token if it's still good, or
+// refreshes it otherwise. If Google says the refresh token is no good, the link
+// is marked dead and errLinkDead is returned.
+func (s *linkTokenSource) Token() (*oauth2.Token, error) {
+	ctx := context.Background()
+
+	var (
+		token     oauth2.Token
+		expiresAt string
+		deadAt    sql.NullString
+	)
+	if err := s.db.QueryRowContext(
+		ctx,
+		`
+		SELECT access_token, token_type, refresh_token, expires_at, dead_at
+		FROM google_links
+		WHERE id = $1
+		`,
+		s.linkID,
+	).Scan(&token.AccessToken, &token.TokenType, &token.RefreshToken, &expiresAt, &deadAt); err != nil {
+		return nil, fmt.Errorf("can't look up Google link %d: %w", s.linkID, err)
+	}
+	if deadAt.Valid {
+		return nil, fmt.Errorf("link %d: %w", s.linkID, errLinkDead)
+	}
+	var err error
+	if token.Expiry, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+		return nil, fmt.Errorf("invalid datetime in Google link expires_at column `%s`: %w", expiresAt, err)
+	}
+	if token.AccessToken, err = s.keys.Decrypt(token.AccessToken); err != nil {
+		return nil, fmt.Errorf("can't decrypt access token of Google link %d: %w", s.linkID, err)
+	}
+	if token.RefreshToken, err = s.keys.Decrypt(token.RefreshToken); err != nil {
+		return nil, fmt.Errorf("can't decrypt refresh token of Google link %d: %w", s.linkID, err)
+	}
+	if time.Until(token.Expiry) > googleTokenLeeway {
+		return &token, nil
+	}
+
+	refreshed, err := s.config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		if isInvalidGrant(err) {
+			s.logger.Printf("Google link %d was revoked or expired; marking it dead", s.linkID)
+			if _, err := s.db.ExecContext(
+				ctx,
+				`
+				UPDATE google_links
+				SET dead_at = $1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $2
+				`,
+				time.Now().UTC().Format(time.RFC3339),
+				s.linkID,
+			); err != nil {
+				return nil, fmt.Errorf("can't mark Google link %d dead: %w", s.linkID, err)
+			}
+			return nil, fmt.Errorf("link %d: %w", s.linkID, errLinkDead)
+		}
+		return nil, fmt.Errorf("can't refresh Google link %d: %w", s.linkID, err)
+	}
+	// Google usually doesn't send a new refresh token, in which case the old one
+	// still works.
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	accessToken, err := s.keys.Encrypt(refreshed.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt access token: %w", err)
+	}
+	refreshToken, err := s.keys.Encrypt(refreshed.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt refresh token: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		`
+		UPDATE google_links
+		SET
+			access_token = $1,
+			token_type = $2,
+			refresh_token = $3,
+			expires_at = $4,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+		`,
+		accessToken,
+		refreshed.TokenType,
+		refreshToken,
+		refreshed.Expiry.UTC().Format(time.RFC3339),
+		s.linkID,
+	); err != nil {
+		return nil, fmt.Errorf("can't save refreshed token for Google link %d: %w", s.linkID, err)
+	}
+
+	return refreshed, nil
+}
+
+// isInvalidGrant returns true if err is Google refusing a refresh token because
+// it has been revoked or has expired.
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(retrieveErr.Body, &body) != nil {
+		return false
+	}
+	return body.Error == "invalid_grant"
+}
+
+// saveGoogleLink stores token, encrypted, as the Google link for the given
+// Google user, replacing (and reviving) any link it already has, along with the
+// scopes token was granted. It returns the link's ID.
+func saveGoogleLink(
+	ctx context.Context,
+	tx *sql.Tx,
+	keys *secrets.Keyring,
+	googleUserID int64,
+	token *oauth2.Token,
+) (int64, error) {
+	accessToken, err := keys.Encrypt(token.AccessToken)
+	if err != nil {
+		return 0, fmt.Errorf("can't encrypt access token: %w", err)
+	}
+	// An empty refresh token stays empty, so an existing one is kept below.
+	var refreshToken string
+	if token.RefreshToken != "" {
+		if refreshToken, err = keys.Encrypt(token.RefreshToken); err != nil {
+			return 0, fmt.Errorf("can't encrypt refresh token: %w", err)
+		}
+	}
+
+	var linkID int64
+	err = tx.QueryRowContext(
+		ctx,
+		`
+		SELECT id
+		FROM google_links
+		WHERE google_user_id = $1
+		ORDER BY id DESC
+		LIMIT 1
+		`,
+		googleUserID,
+	).Scan(&linkID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		result, err := tx.ExecContext(
+			ctx,
+			`
+			INSERT INTO google_links (
+				access_token,
+				token_type,
+				refresh_token,
+				expires_at,
+				google_user_id
+			) VALUES (
+				$1, $2, $3, $4, $5
+			)
+			`,
+			accessToken,
+			token.TokenType,
+			refreshToken,
+			token.Expiry.UTC().Format(time.RFC3339),
+			googleUserID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("can't insert google link: %w", err)
+		}
+		if linkID, err = result.LastInsertId(); err != nil {
+			return 0, fmt.Errorf("can't get google link id: %w", err)
+		}
+	case err != nil:
+		return 0, fmt.Errorf("can't look up google link: %w", err)
+	default:
+		// Keep the old refresh token if Google didn't send a new one.
+		if _, err := tx.ExecContext(
+			ctx,
+			`
+			UPDATE google_links
+			SET
+				access_token = $1,
+				token_type = $2,
+				refresh_token = COALESCE(NULLIF($3, ''), refresh_token),
+				expires_at = $4,
+				dead_at = NULL,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5
+			`,
+			accessToken,
+			token.TokenType,
+			refreshToken,
+			token.Expiry.UTC().Format(time.RFC3339),
+			linkID,
+		); err != nil {
+			return 0, fmt.Errorf("can't update google link: %w", err)
+		}
+	}
+
+	scope, _ := token.Extra("scope").(string)
+	if scope == "" {
+		return linkID, nil
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`
+		DELETE FROM google_link_scopes
+		WHERE google_link_id = $1
+		`,
+		linkID,
+	); err != nil {
+		return 0, fmt.Errorf("can't clear google link scopes: %w", err)
+	}
+	for _, s := range strings.Fields(scope) {
+		if _, err := tx.ExecContext(
+			ctx,
+			`
+			INSERT INTO google_link_scopes (
+				google_link_id,
+				scope
+			) VALUES (
+				$1, $2
+			)
+			`,
+			linkID,
+			s,
+		); err != nil {
+			return 0, fmt.Errorf("can't insert google link scope: %w", err)
+		}
+	}
+
+	return linkID, nil
+}
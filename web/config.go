@@ -0,0 +1,162 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	envKeyBaseURL        = "MAINFRAME_BASE_URL"
+	envKeyListenAddr     = "MAINFRAME_LISTEN_ADDR"
+	envKeyPort           = "MAINFRAME_PORT"
+	envKeyTrustedProxies = "MAINFRAME_TRUSTED_PROXIES"
+
+	defaultPort = 9000
+)
+
+// config is the configuration the server was started with.
+var config *Config
+
+// Config is where the web server listens and how the outside world reaches it.
+type Config struct {
+	// BaseURL is the public URL mainframe is reached at, like
+	// https://mainframe.example.com. Every absolute URL mainframe generates
+	// starts with it.
+	BaseURL *url.URL
+	// Addr is the host:port the server listens on.
+	Addr string
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-*
+	// headers are believed. Those headers are dropped from everyone else.
+	TrustedProxies []*net.IPNet
+}
+
+// ConfigFromEnv reads the configuration from the environment:
+//
+//	MAINFRAME_BASE_URL         public URL (default http://localhost:<port>)
+//	MAINFRAME_LISTEN_ADDR      host or IP to listen on (default all of them)
+//	MAINFRAME_PORT             port to listen on (default 9000)
+//	MAINFRAME_TRUSTED_PROXIES  comma-separated IPs or CIDRs of reverse proxies
+func ConfigFromEnv() (*Config, error) {
+	port := defaultPort
+	if v := os.Getenv(envKeyPort); v != "" {
+		var err error
+		if port, err = strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%s must be a port number, not %s", envKeyPort, v)
+		}
+	}
+	c := Config{Addr: net.JoinHostPort(os.Getenv(envKeyListenAddr), strconv.Itoa(port))}
+
+	base := os.Getenv(envKeyBaseURL)
+	if base == "" {
+		base = fmt.Sprintf("http://localhost:%d", port)
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envKeyBaseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s must look like https://mainframe.example.com, not %s", envKeyBaseURL, base)
+	}
+	c.BaseURL = u
+
+	for _, proxy := range strings.Split(os.Getenv(envKeyTrustedProxies), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %s: %w", envKeyTrustedProxies, proxy, err)
+		}
+		c.TrustedProxies = append(c.TrustedProxies, network)
+	}
+
+	return &c, nil
+}
+
+// Secure returns true if mainframe is reached over HTTPS.
+func (c *Config) Secure() bool {
+	return c.BaseURL.Scheme == "https"
+}
+
+// Secure returns true if mainframe is reached over HTTPS, so cookies should
+// only be sent over HTTPS. Start must have been called first.
+func Secure() bool {
+	return config.Secure()
+}
+
+// URL returns the absolute URL of the given path, which may include a query
+// string. Before Start is called, the path is returned as is.
+func URL(path string) string {
+	if config == nil {
+		return "/" + strings.TrimPrefix(path, "/")
+	}
+	return config.BaseURL.String() + "/" + strings.TrimPrefix(path, "/")
+}
+
+// trusted returns true if remoteAddr is one of c's trusted proxies.
+func (c *Config) trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded applies X-Forwarded-For, -Host and -Proto to requests from trusted
+// proxies, so handlers see the client's address, the public host and the
+// public scheme. Requests from anywhere else have those headers removed.
+func (c *Config) forwarded(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.trusted(r.RemoteAddr) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Forwarded-Host")
+			r.Header.Del("X-Forwarded-Proto")
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// Each proxy appends the address it received the request from, so the
+		// client is the rightmost address that isn't one of our proxies.
+		if v := r.Header.Get("X-Forwarded-For"); v != "" {
+			hops := strings.Split(v, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break
+				}
+				r.RemoteAddr = net.JoinHostPort(hop, "0")
+				if !c.trusted(hop) {
+					break
+				}
+			}
+		}
+		if v := r.Header.Get("X-Forwarded-Host"); v != "" {
+			r.Host = strings.TrimSpace(strings.Split(v, ",")[0])
+		}
+		if v := r.Header.Get("X-Forwarded-Proto"); v == "https" || v == "http" {
+			r.URL.Scheme = v
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"embed"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
@@ -12,14 +13,15 @@ import (
 	"twos.dev/mainframe/coldbrewcrew/iworkout"
 )
 
-const port = 9000
-
 //go:embed html
 var html embed.FS
 
 //go:embed static
 var static embed.FS
 
+// templates holds every template in html/, parsed when the server starts.
+var templates *template.Template
+
 // IworkoutParams are the fields sent to the template which renders
 // html/iworkout.html.
 type IworkoutParams struct {
@@ -42,8 +44,14 @@ func overrideMIMEType(logger *log.Logger, h http.Handler) http.Handler {
 }
 
 // Start boots the web server in a goroutine and then immediately returns the
-// root serve mux.
-func Start(logger *log.Logger, version string) (*http.ServeMux, error) {
+// root serve mux. Every request passes through middleware before reaching the
+// mux.
+func Start(
+	logger *log.Logger,
+	version string,
+	c *Config,
+	middleware func(http.Handler) http.Handler,
+) (*http.ServeMux, error) {
 	logger = log.New(logger.Writer(), "[web] ", logger.Flags())
 	logger.Println("Booting web")
 
@@ -55,7 +63,7 @@ func Start(logger *log.Logger, version string) (*http.ServeMux, error) {
 	mux := http.NewServeMux()
 	mux.Handle("/static/", overrideMIMEType(logger, http.FileServer(http.FS(static))))
 	mux.Handle("/", http.FileServer(http.FS(htmlfs)))
-	t, err := template.ParseFS(htmlfs, "*.tmpl")
+	templates, err = template.ParseFS(htmlfs, "*.tmpl")
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse templates: %w", err)
 	}
@@ -65,16 +73,17 @@ func Start(logger *log.Logger, version string) (*http.ServeMux, error) {
 			Messages:  iworkout.Messages(),
 			Reactions: iworkout.Reactions(),
 		}
-		if err := t.Lookup("iworkout.html.tmpl").Execute(w, params); err != nil {
+		if err := Render(w, "iworkout.html.tmpl", params); err != nil {
 			logger.Printf("error executing iworkout template: %s", err)
 			w.WriteHeader(500)
 			return
 		}
 	})
 
-	logger.Printf("Listening on http://%s:%d\n", "localhost", port)
+	config = c
+	logger.Printf("Listening on %s for %s", c.Addr, c.BaseURL)
 	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		if err := http.ListenAndServe(c.Addr, c.forwarded(middleware(mux))); err != nil {
 			logger.Printf("server stopped: %v", err)
 		}
 	}()
@@ -82,6 +91,16 @@ func Start(logger *log.Logger, version string) (*http.ServeMux, error) {
 	return mux, nil
 }
 
+// Render executes the template in html/ with the given name. Start must have
+// been called first.
+func Render(w io.Writer, name string, data any) error {
+	t := templates.Lookup(name)
+	if t == nil {
+		return fmt.Errorf("no such template %s", name)
+	}
+	return t.Execute(w, data)
+}
+
 // AddOne is a template convenience function that returns 1 + its argument.
 func AddOne(i int) int {
 	return i + 1